@@ -0,0 +1,147 @@
+package dot
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// counterSlots is the number of parts QueryCounter window is split into; it
+// slides by one part at a time.
+const counterSlots = 60
+
+// QueryCounter counts queries by name and type over a sliding time window,
+// so that the most queried names can be reported, as local DNS proxies
+// usually do:
+//
+//	qc := dot.NewQueryCounter(time.Hour)
+//	p := &dot.Proxy{Handler: qc.Handler(dot.NewCache(c, 1000))}
+//	...
+//	for _, v := range qc.Top(10, 0) {
+//		log.Printf("%6d %v %s", v.Count, v.Type, v.Name)
+//	}
+//
+// Memory it takes is proportional to the number of distinct names queried
+// within the window.
+//
+// QueryCounter is safe for concurrent use.
+type QueryCounter struct {
+	slot time.Duration
+
+	mu    sync.Mutex
+	slots [counterSlots]counterSlot
+}
+
+type counterSlot struct {
+	epoch  int64 // time of slot start divided by slot duration
+	counts map[counterKey]uint64
+}
+
+type counterKey struct {
+	name string
+	typ  dnsmessage.Type
+}
+
+// QueryCount is the number of queries for a single name and type.
+type QueryCount struct {
+	Name  string // lowercase name without trailing dot
+	Type  dnsmessage.Type
+	Count uint64
+}
+
+// NewQueryCounter returns QueryCounter keeping counts for the last window,
+// one hour if window is not positive.
+func NewQueryCounter(window time.Duration) *QueryCounter {
+	if window <= 0 {
+		window = time.Hour
+	}
+	slot := window / counterSlots
+	if slot <= 0 {
+		slot = 1
+	}
+	return &QueryCounter{slot: slot}
+}
+
+// Handler returns Handler that counts queries and passes them to next.
+// Queries that cannot be parsed are passed without being counted.
+func (qc *QueryCounter) Handler(next Handler) Handler {
+	if next == nil {
+		panic("dot: nil Handler")
+	}
+	return HandlerFunc(func(ctx context.Context, query []byte) ([]byte, error) {
+		var p dnsmessage.Parser
+		if _, err := p.Start(query); err == nil {
+			if q, err := p.Question(); err == nil {
+				qc.Record(q.Name.String(), q.Type)
+			}
+		}
+		return next.ServeDNS(ctx, query)
+	})
+}
+
+// Record counts a single query for name of type typ, for use by Handler
+// implementations that parse queries themselves. Name is case-insensitive,
+// trailing dot is optional.
+func (qc *QueryCounter) Record(name string, typ dnsmessage.Type) {
+	key := counterKey{name: hostsKey(name), typ: typ}
+	epoch := time.Now().UnixNano() / int64(qc.slot)
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+	s := &qc.slots[epoch%counterSlots]
+	if s.epoch != epoch || s.counts == nil {
+		s.epoch = epoch
+		s.counts = make(map[counterKey]uint64)
+	}
+	s.counts[key]++
+}
+
+// Top returns up to n most queried names and types within the last d,
+// ordered by count, highest first. If n is not positive, all of them are
+// returned. If d is not positive or exceeds the window, the whole window is
+// used; otherwise d is rounded up to the 1/60 of the window.
+func (qc *QueryCounter) Top(n int, d time.Duration) []QueryCount {
+	parts := int64(counterSlots)
+	if d > 0 && d < qc.slot*counterSlots {
+		parts = int64((d + qc.slot - 1) / qc.slot)
+	}
+	epoch := time.Now().UnixNano() / int64(qc.slot)
+	sum := make(map[counterKey]uint64)
+	qc.mu.Lock()
+	for i := range qc.slots {
+		s := &qc.slots[i]
+		if s.epoch <= epoch-parts || s.epoch > epoch {
+			continue
+		}
+		for k, v := range s.counts {
+			sum[k] += v
+		}
+	}
+	qc.mu.Unlock()
+	out := make([]QueryCount, 0, len(sum))
+	for k, v := range sum {
+		out = append(out, QueryCount{Name: k.name, Type: k.typ, Count: v})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		if out[i].Name != out[j].Name {
+			return out[i].Name < out[j].Name
+		}
+		return out[i].Type < out[j].Type
+	})
+	if n > 0 && len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// Reset discards all counts.
+func (qc *QueryCounter) Reset() {
+	qc.mu.Lock()
+	defer qc.mu.Unlock()
+	qc.slots = [counterSlots]counterSlot{}
+}
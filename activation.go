@@ -0,0 +1,70 @@
+package dot
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket
+// activation, see sd_listen_fds(3).
+const listenFDsStart = 3
+
+// ActivationListeners returns UDP socket and TCP listener passed to the
+// process by systemd socket activation, so that Proxy or Server can be
+// served on port 53 or 853 without running as root:
+//
+//	pc, l, err := dot.ActivationListeners()
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	if pc != nil || l != nil {
+//		err = p.Serve(pc, l)
+//	} else {
+//		err = p.ListenAndServe()
+//	}
+//
+// Socket unit should have ListenDatagram= and ListenStream= settings with
+// the same address. If more sockets of the same kind are passed, only the
+// first one is returned, others are closed. If process was not socket
+// activated, ActivationListeners returns nil socket and listener, and nil
+// error. Environment variables systemd passes are unset, so that child
+// processes do not inherit them.
+func ActivationListeners() (net.PacketConn, net.Listener, error) {
+	pid, fds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if pid == "" || fds == "" || pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, nil, fmt.Errorf("dot: malformed LISTEN_FDS value %q", fds)
+	}
+	var pc net.PacketConn
+	var l net.Listener
+	for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+		f := os.NewFile(uintptr(fd), "listen-fd-"+strconv.Itoa(fd))
+		if ll, err := net.FileListener(f); err == nil {
+			if l == nil {
+				l = ll
+			} else {
+				ll.Close()
+			}
+		} else if c, err := net.FilePacketConn(f); err == nil {
+			if pc == nil {
+				pc = c
+			} else {
+				c.Close()
+			}
+		}
+		f.Close()
+	}
+	if pc == nil && l == nil {
+		return nil, nil, errors.New("dot: no usable sockets passed by socket activation")
+	}
+	return pc, l, nil
+}
@@ -0,0 +1,29 @@
+// Package auto replaces net.DefaultResolver with a DNS-over-TLS one when
+// imported, so that the whole program resolves names over DoT without any
+// code changes:
+//
+//	import _ "github.com/artyom/dot/auto"
+//
+// Cloudflare is used by default. Set DOT_PROVIDER environment variable to the
+// name of another built-in provider, as listed by dot.Providers, to use it
+// instead. If DOT_PROVIDER names unknown provider, program panics at startup
+// rather than silently falling back to plain DNS.
+package auto
+
+import (
+	"os"
+
+	"github.com/artyom/dot"
+)
+
+func init() {
+	name := os.Getenv("DOT_PROVIDER")
+	if name == "" {
+		name = "cloudflare"
+	}
+	p, ok := dot.LookupProvider(name)
+	if !ok {
+		panic("dot/auto: unknown provider " + name + " in DOT_PROVIDER")
+	}
+	dot.SetDefault(p.Resolver())
+}
@@ -0,0 +1,125 @@
+package dot
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// BatchOptions configures batch lookups.
+type BatchOptions struct {
+	// Workers limits the number of lookups done concurrently, 16 if zero.
+	Workers int
+
+	// Timeout, if positive, limits how long every single lookup may take.
+	Timeout time.Duration
+
+	// Network selects address family for LookupHosts as LookupNetIP
+	// network does, "ip" if empty.
+	Network string
+}
+
+// HostResult is the result of lookup of a single host name.
+type HostResult struct {
+	Addrs []netip.Addr
+	Err   error
+}
+
+// AddrResult is the result of reverse lookup of a single address.
+type AddrResult struct {
+	Names []string
+	Err   error
+}
+
+// LookupAddrs does reverse lookups of given addresses concurrently, returning
+// results keyed by addresses. Duplicate addresses are looked up once.
+// Failure of some lookups does not affect others: results of lookups that
+// failed, including those not done because ctx got canceled, have their Err
+// field set. opts may be nil.
+//
+// Use it with Resolver returned by Client.Resolver, so that lookups are
+// pipelined over pooled connections.
+func LookupAddrs(ctx context.Context, r *net.Resolver, ips []netip.Addr, opts *BatchOptions) map[netip.Addr]AddrResult {
+	return batch(ctx, ips, opts, func(ctx context.Context, ip netip.Addr) AddrResult {
+		names, err := LookupNetAddr(ctx, r, ip)
+		return AddrResult{Names: names, Err: err}
+	})
+}
+
+// LookupHosts looks up given host names concurrently, returning results keyed
+// by names. Names that only differ in case or trailing dot are looked up
+// once. Failure of some lookups does not affect others, as with LookupAddrs.
+// opts may be nil.
+func LookupHosts(ctx context.Context, r *net.Resolver, names []string, opts *BatchOptions) map[string]HostResult {
+	network := "ip"
+	if opts != nil && opts.Network != "" {
+		network = opts.Network
+	}
+	keys := make([]string, len(names))
+	for i, name := range names {
+		keys[i] = hostsKey(name)
+	}
+	res := batch(ctx, keys, opts, func(ctx context.Context, host string) HostResult {
+		addrs, err := LookupNetIP(ctx, r, network, host)
+		return HostResult{Addrs: addrs, Err: err}
+	})
+	out := make(map[string]HostResult, len(names))
+	for i, name := range names {
+		out[name] = res[keys[i]]
+	}
+	return out
+}
+
+// batch calls fn for every unique key concurrently, limited by
+// opts.Workers, and returns fn results keyed by keys.
+func batch[K comparable, V any](ctx context.Context, keys []K, opts *BatchOptions, fn func(context.Context, K) V) map[K]V {
+	workers := 16
+	var timeout time.Duration
+	if opts != nil {
+		if opts.Workers > 0 {
+			workers = opts.Workers
+		}
+		timeout = opts.Timeout
+	}
+	out := make(map[K]V, len(keys))
+	seen := make(map[K]struct{}, len(keys))
+	var uniq []K
+	for _, k := range keys {
+		if _, ok := seen[k]; !ok {
+			seen[k] = struct{}{}
+			uniq = append(uniq, k)
+		}
+	}
+	if workers > len(uniq) {
+		workers = len(uniq)
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	ch := make(chan K)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range ch {
+				ctx := ctx
+				var cancel context.CancelFunc = func() {}
+				if timeout > 0 {
+					ctx, cancel = context.WithTimeout(ctx, timeout)
+				}
+				v := fn(ctx, k)
+				cancel()
+				mu.Lock()
+				out[k] = v
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, k := range uniq {
+		ch <- k
+	}
+	close(ch)
+	wg.Wait()
+	return out
+}
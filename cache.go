@@ -0,0 +1,461 @@
+package dot
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Cache is a Handler that caches responses of another Handler for the
+// duration of their smallest TTL. Cached responses are returned with their
+// TTLs decreased by the time spent in cache.
+//
+// Only successful and NXDOMAIN responses are cached, truncated responses
+// and responses with other error codes are passed through as is.
+type Cache struct {
+	// MinTTL and MaxTTL, if positive, bound TTLs of cached records, e.g.
+	// to keep records with TTLs of a few seconds, as some CDNs use, cached
+	// for longer, or to have records refreshed at least every hour.
+	// Records are served from cache with their TTLs adjusted to these
+	// bounds. Responses with zero TTL are never cached. Fields must not be
+	// changed once Cache is used.
+	MinTTL, MaxTTL time.Duration
+
+	// ServeStale, if positive, makes Cache keep responses for that long
+	// after they expire, and answer with them if upstream fails to
+	// refresh them in time, as described in RFC 8767, so that
+	// applications keep working through upstream outages. RFC 8767
+	// suggests a value between one and three days. Expired response is
+	// refreshed in background once queried, query is answered with the
+	// stale one if refreshing takes more than 1.8 seconds or fails; such
+	// answers have TTL of 30 seconds. After refreshing fails, Cache
+	// answers with stale response right away for 30 seconds before
+	// trying again. Field must not be changed once Cache is used.
+	ServeStale time.Duration
+
+	next Handler
+	size int
+
+	mu         sync.Mutex
+	ll         *list.List // of *cacheEntry, most recently used at front
+	items      map[cacheKey]*list.Element
+	refreshing map[cacheKey]*staleRefresh
+
+	hits, misses, stale atomic.Uint64
+}
+
+// Serve-stale timers, as recommended by RFC 8767, section 5.
+const (
+	staleAnswerTTL  = 30                      // TTL of stale answers, seconds
+	staleWait       = 1800 * time.Millisecond // client response timer
+	staleRecheck    = 30 * time.Second        // failure recheck timer
+	staleRefreshMax = 10 * time.Second        // limit on background refresh
+)
+
+// staleRefresh is a background refresh of an expired response.
+type staleRefresh struct {
+	done chan struct{} // closed once resp and ok are set
+	resp []byte
+	ok   bool // upstream answered with a usable response
+}
+
+// CacheStats describes Cache usage.
+type CacheStats struct {
+	Hits   uint64 // queries answered from cache
+	Misses uint64 // cacheable queries passed upstream
+	Stale  uint64 // queries answered with expired responses, see ServeStale
+	Len    int    // number of responses currently cached
+}
+
+// Stats returns Cache usage statistics accumulated since it was created.
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	n := c.ll.Len()
+	c.mu.Unlock()
+	return CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load(), Stale: c.stale.Load(), Len: n}
+}
+
+// NewCache returns Cache wrapping next that holds at most size responses,
+// evicting the least recently used ones when full. It panics if size is not
+// positive.
+func NewCache(next Handler, size int) *Cache {
+	if next == nil {
+		panic("dot: nil Handler")
+	}
+	if size <= 0 {
+		panic("dot: cache size must be positive")
+	}
+	return &Cache{
+		next:       next,
+		size:       size,
+		ll:         list.New(),
+		items:      make(map[cacheKey]*list.Element),
+		refreshing: make(map[cacheKey]*staleRefresh),
+	}
+}
+
+type cacheKey struct {
+	name  string // lowercased
+	typ   dnsmessage.Type
+	class dnsmessage.Class
+	do    bool // DNSSEC OK bit
+	cd    bool // checking disabled bit
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	msg     dnsmessage.Message
+	stored  time.Time
+	expires time.Time
+	retry   time.Time // when to try refreshing stale response after failure
+}
+
+// ServeDNS implements Handler interface.
+func (c *Cache) ServeDNS(ctx context.Context, query []byte) ([]byte, error) {
+	var q dnsmessage.Message
+	if err := q.Unpack(query); err != nil || len(q.Questions) != 1 {
+		return c.next.ServeDNS(ctx, query)
+	}
+	key := newCacheKey(&q)
+	resp, stale := c.get(key, &q)
+	if resp != nil && !stale {
+		c.hits.Add(1)
+		return resp, nil
+	}
+	if resp != nil {
+		return c.serveStale(ctx, key, query, &q, resp), nil
+	}
+	c.misses.Add(1)
+	resp, err := c.next.ServeDNS(ctx, query)
+	if err != nil || resp == nil {
+		return resp, err
+	}
+	c.put(key, resp)
+	return resp, nil
+}
+
+func newCacheKey(q *dnsmessage.Message) cacheKey {
+	key := cacheKey{
+		name:  strings.ToLower(q.Questions[0].Name.String()),
+		typ:   q.Questions[0].Type,
+		class: q.Questions[0].Class,
+		cd:    q.Header.CheckingDisabled,
+	}
+	for _, rr := range q.Additionals {
+		if rr.Header.Type == dnsmessage.TypeOPT {
+			key.do = rr.Header.DNSSECAllowed()
+		}
+	}
+	return key
+}
+
+// get returns cached response for key adjusted to match query q, or nil if
+// no response is cached. If response has expired, but can still be served
+// stale, get returns it with true.
+func (c *Cache) get(key cacheKey, q *dnsmessage.Message) (resp []byte, stale bool) {
+	now := time.Now()
+	c.mu.Lock()
+	el, ok := c.items[key]
+	if !ok {
+		c.mu.Unlock()
+		return nil, false
+	}
+	ent := el.Value.(*cacheEntry)
+	if !now.Before(ent.expires) {
+		stale = c.ServeStale > 0 && now.Before(ent.expires.Add(c.ServeStale))
+		if !stale {
+			c.ll.Remove(el)
+			delete(c.items, key)
+			c.mu.Unlock()
+			return nil, false
+		}
+	}
+	c.ll.MoveToFront(el)
+	msg := ent.msg
+	age := uint32(now.Sub(ent.stored) / time.Second)
+	c.mu.Unlock()
+
+	// msg shares record slices with the cached entry, copy them before
+	// adjusting TTLs
+	msg.Header.ID = q.Header.ID
+	msg.Questions = q.Questions
+	msg.Answers = agedRecords(msg.Answers, age, stale)
+	msg.Authorities = agedRecords(msg.Authorities, age, stale)
+	msg.Additionals = agedRecords(msg.Additionals, age, stale)
+	resp, err := msg.Pack()
+	if err != nil {
+		return nil, false
+	}
+	return resp, stale
+}
+
+// agedRecords returns copy of rrs with TTLs decreased by age, or set to
+// staleAnswerTTL if stale is true.
+func agedRecords(rrs []dnsmessage.Resource, age uint32, stale bool) []dnsmessage.Resource {
+	if len(rrs) == 0 {
+		return nil
+	}
+	out := make([]dnsmessage.Resource, len(rrs))
+	copy(out, rrs)
+	for i := range out {
+		if out[i].Header.Type == dnsmessage.TypeOPT {
+			continue // TTL field of OPT carries flags
+		}
+		if stale {
+			out[i].Header.TTL = staleAnswerTTL
+			continue
+		}
+		if out[i].Header.TTL > age {
+			out[i].Header.TTL -= age
+		} else {
+			out[i].Header.TTL = 0
+		}
+	}
+	return out
+}
+
+// serveStale answers query q, for which there is expired response stale
+// cached, refreshing it in background. If refresh completes in time, its
+// result is returned, otherwise stale is.
+func (c *Cache) serveStale(ctx context.Context, key cacheKey, query []byte, q *dnsmessage.Message, stale []byte) []byte {
+	if r := c.refresh(ctx, key, query); r != nil {
+		timer := time.NewTimer(staleWait)
+		defer timer.Stop()
+		select {
+		case <-r.done:
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		select {
+		case <-r.done:
+			if !r.ok {
+				break
+			}
+			c.misses.Add(1)
+			if resp, stale := c.get(key, q); resp != nil && !stale {
+				return resp
+			}
+			// refreshed response is not cacheable, or was already
+			// evicted
+			resp := append([]byte(nil), r.resp...)
+			copy(resp, query[:2]) // ID
+			return resp
+		default:
+		}
+	}
+	c.stale.Add(1)
+	return stale
+}
+
+// refresh starts refreshing response for key in background with query,
+// unless it is already in progress. It returns nil if previous refresh
+// failed recently, and refresh should not be tried yet.
+func (c *Cache) refresh(ctx context.Context, key cacheKey, query []byte) *staleRefresh {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r, ok := c.refreshing[key]; ok {
+		return r
+	}
+	if el, ok := c.items[key]; ok && time.Now().Before(el.Value.(*cacheEntry).retry) {
+		return nil
+	}
+	r := &staleRefresh{done: make(chan struct{})}
+	c.refreshing[key] = r
+	query = append([]byte(nil), query...)
+	go func() {
+		// refresh outlives query that started it
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), staleRefreshMax)
+		defer cancel()
+		resp, err := c.next.ServeDNS(ctx, query)
+		ok := err == nil && len(resp) >= 4 && !retryableRCode(dnsmessage.RCode(resp[3]&0x0f))
+		if ok {
+			c.put(key, resp)
+		}
+		c.mu.Lock()
+		delete(c.refreshing, key)
+		if el, found := c.items[key]; found && !ok {
+			el.Value.(*cacheEntry).retry = time.Now().Add(staleRecheck)
+		}
+		c.mu.Unlock()
+		r.resp, r.ok = resp, ok
+		close(r.done)
+	}()
+	return r
+}
+
+// clampTTL returns ttl adjusted to c.MinTTL and c.MaxTTL bounds.
+func (c *Cache) clampTTL(ttl uint32) uint32 {
+	if min := uint32(c.MinTTL / time.Second); c.MinTTL > 0 && ttl < min {
+		ttl = min
+	}
+	if max := uint32(c.MaxTTL / time.Second); c.MaxTTL > 0 && ttl > max {
+		ttl = max
+	}
+	return ttl
+}
+
+func (c *Cache) put(key cacheKey, resp []byte) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(resp); err != nil || msg.Header.Truncated {
+		return
+	}
+	if msg.Header.RCode != dnsmessage.RCodeSuccess && msg.Header.RCode != dnsmessage.RCodeNameError {
+		return
+	}
+	ttl, ok := minTTL(resp)
+	if !ok || ttl == 0 {
+		return
+	}
+	if c.MinTTL > 0 || c.MaxTTL > 0 {
+		ttl = c.clampTTL(ttl)
+		for _, rrs := range [][]dnsmessage.Resource{msg.Answers, msg.Authorities, msg.Additionals} {
+			for i := range rrs {
+				if rrs[i].Header.Type != dnsmessage.TypeOPT {
+					rrs[i].Header.TTL = c.clampTTL(rrs[i].Header.TTL)
+				}
+			}
+		}
+	}
+	now := time.Now()
+	ent := &cacheEntry{
+		key:     key,
+		msg:     msg,
+		stored:  now,
+		expires: now.Add(time.Duration(ttl) * time.Second),
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value = ent
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(ent)
+	for c.ll.Len() > c.size {
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		delete(c.items, el.Value.(*cacheEntry).key)
+	}
+}
+
+// CacheEntry describes a response held by Cache.
+type CacheEntry struct {
+	Name  string // lowercase name without trailing dot
+	Type  dnsmessage.Type
+	Class dnsmessage.Class
+	RCode dnsmessage.RCode
+	TTL   time.Duration // time left until response expires
+
+	// DNSSECOK and CheckingDisabled report bits of the query response
+	// was cached for, responses to queries that differ in them are cached
+	// separately.
+	DNSSECOK, CheckingDisabled bool
+}
+
+// Entries returns responses currently cached, most recently used first.
+// Expired entries are not included.
+func (c *Cache) Entries() []CacheEntry {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]CacheEntry, 0, c.ll.Len())
+	for el := c.ll.Front(); el != nil; el = el.Next() {
+		ent := el.Value.(*cacheEntry)
+		if !now.Before(ent.expires) {
+			continue
+		}
+		out = append(out, ent.describe(now))
+	}
+	return out
+}
+
+// TTL returns time left until response for name of type typ expires from
+// cache, and false if there is no such response cached. Name is
+// case-insensitive, trailing dot is optional. If responses to queries with
+// different DNSSEC-related bits are cached, the longest TTL is returned.
+func (c *Cache) TTL(name string, typ dnsmessage.Type) (time.Duration, bool) {
+	name = hostsKey(name) + "."
+	now := time.Now()
+	var ttl time.Duration
+	var found bool
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, do := range [...]bool{false, true} {
+		for _, cd := range [...]bool{false, true} {
+			el, ok := c.items[cacheKey{name: name, typ: typ, class: dnsmessage.ClassINET, do: do, cd: cd}]
+			if !ok {
+				continue
+			}
+			ent := el.Value.(*cacheEntry)
+			if left := ent.expires.Sub(now); left > 0 && left > ttl {
+				ttl, found = left, true
+			}
+		}
+	}
+	return ttl, found
+}
+
+// Flush removes all cached responses for name, of any type, and reports how
+// many were removed. Name is case-insensitive, trailing dot is optional.
+func (c *Cache) Flush(name string) int {
+	name = hostsKey(name) + "."
+	return c.flush(func(key cacheKey) bool { return key.name == name })
+}
+
+// FlushZone is like Flush, but it also removes responses for all names
+// within zone, e.g. FlushZone("example.com") removes both "example.com" and
+// "www.example.com", but not "notexample.com". FlushZone(".") is the same as
+// FlushAll.
+func (c *Cache) FlushZone(zone string) int {
+	zone = hostsKey(zone)
+	if zone == "" {
+		return c.FlushAll()
+	}
+	zone += "."
+	return c.flush(func(key cacheKey) bool {
+		return key.name == zone || strings.HasSuffix(key.name, "."+zone)
+	})
+}
+
+// FlushAll removes all cached responses and reports how many were removed.
+// It does not reset Stats counters.
+func (c *Cache) FlushAll() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.ll.Len()
+	c.ll.Init()
+	clear(c.items)
+	return n
+}
+
+// flush removes entries whose keys match fn and returns their number.
+func (c *Cache) flush(fn func(cacheKey) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var n int
+	for key, el := range c.items {
+		if fn(key) {
+			c.ll.Remove(el)
+			delete(c.items, key)
+			n++
+		}
+	}
+	return n
+}
+
+func (ent *cacheEntry) describe(now time.Time) CacheEntry {
+	return CacheEntry{
+		Name:             hostsKey(ent.key.name),
+		Type:             ent.key.typ,
+		Class:            ent.key.class,
+		RCode:            ent.msg.Header.RCode,
+		TTL:              ent.expires.Sub(now),
+		DNSSECOK:         ent.key.do,
+		CheckingDisabled: ent.key.cd,
+	}
+}
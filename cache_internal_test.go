@@ -0,0 +1,427 @@
+package dot
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// testUpstream is a Handler answering queries with a single record of
+// configured TTL, counting queries it gets. For NXDOMAIN responses the
+// record is an SOA one in the authority section.
+type testUpstream struct {
+	calls atomic.Int32
+
+	mu    sync.Mutex
+	a     [4]byte
+	ttl   uint32
+	rcode dnsmessage.RCode
+	trunc bool
+	err   error
+	delay time.Duration
+}
+
+// set calls fn to reconfigure u while no query is being answered.
+func (u *testUpstream) set(fn func(u *testUpstream)) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	fn(u)
+}
+
+func (u *testUpstream) ServeDNS(ctx context.Context, query []byte) ([]byte, error) {
+	u.calls.Add(1)
+	u.mu.Lock()
+	a, ttl, rcode, trunc, err, delay := u.a, u.ttl, u.rcode, u.trunc, u.err, u.delay
+	u.mu.Unlock()
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	var q dnsmessage.Message
+	if err := q.Unpack(query); err != nil {
+		return nil, err
+	}
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:                 q.Header.ID,
+			Response:           true,
+			RecursionAvailable: true,
+			Truncated:          trunc,
+			RCode:              rcode,
+		},
+		Questions: q.Questions,
+	}
+	hdr := dnsmessage.ResourceHeader{Name: q.Questions[0].Name, Class: dnsmessage.ClassINET, TTL: ttl}
+	switch rcode {
+	case dnsmessage.RCodeSuccess:
+		hdr.Type = dnsmessage.TypeA
+		msg.Answers = []dnsmessage.Resource{{Header: hdr, Body: &dnsmessage.AResource{A: a}}}
+	case dnsmessage.RCodeNameError:
+		hdr.Type = dnsmessage.TypeSOA
+		msg.Authorities = []dnsmessage.Resource{{Header: hdr, Body: &dnsmessage.SOAResource{
+			NS:     dnsmessage.MustNewName("ns.example."),
+			MBox:   dnsmessage.MustNewName("hostmaster.example."),
+			MinTTL: ttl,
+		}}}
+	}
+	return msg.Pack()
+}
+
+// ageCache makes all responses cached by c look d older.
+func ageCache(c *Cache, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for el := c.ll.Front(); el != nil; el = el.Next() {
+		ent := el.Value.(*cacheEntry)
+		ent.stored = ent.stored.Add(-d)
+		ent.expires = ent.expires.Add(-d)
+		if !ent.retry.IsZero() {
+			ent.retry = ent.retry.Add(-d)
+		}
+	}
+}
+
+// cacheQuery sends query of type typ for name to c and returns its
+// unpacked response, checking that response ID matches query.
+func cacheQuery(t *testing.T, c *Cache, name string, typ dnsmessage.Type) (dnsmessage.Message, error) {
+	t.Helper()
+	query, err := newQuery(name, typ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.ServeDNS(context.Background(), query)
+	if err != nil {
+		return dnsmessage.Message{}, err
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(resp); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Header.ID != uint16(query[0])<<8|uint16(query[1]) {
+		t.Fatalf("response ID %d does not match query", msg.Header.ID)
+	}
+	return msg, nil
+}
+
+// recordTTL returns TTL of the first answer or authority record of msg.
+func recordTTL(t *testing.T, msg dnsmessage.Message) uint32 {
+	t.Helper()
+	for _, rrs := range [][]dnsmessage.Resource{msg.Answers, msg.Authorities} {
+		if len(rrs) != 0 {
+			return rrs[0].Header.TTL
+		}
+	}
+	t.Fatalf("no records in response: %+v", msg)
+	return 0
+}
+
+func TestCache(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		set    func(u *testUpstream)
+		cached bool
+	}{
+		{"noerror", func(u *testUpstream) {}, true},
+		{"nxdomain", func(u *testUpstream) { u.rcode = dnsmessage.RCodeNameError }, true},
+		{"servfail", func(u *testUpstream) { u.rcode = dnsmessage.RCodeServerFailure }, false},
+		{"refused", func(u *testUpstream) { u.rcode = dnsmessage.RCodeRefused }, false},
+		{"truncated", func(u *testUpstream) { u.trunc = true }, false},
+		{"zero ttl", func(u *testUpstream) { u.ttl = 0 }, false},
+		{"error", func(u *testUpstream) { u.err = errors.New("upstream failure") }, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			u := &testUpstream{a: [4]byte{192, 0, 2, 1}, ttl: 300}
+			u.set(tc.set)
+			c := NewCache(u, 10)
+			for range 2 {
+				cacheQuery(t, c, "example.com", dnsmessage.TypeA)
+			}
+			wantCalls, wantHits := int32(2), uint64(0)
+			if tc.cached {
+				wantCalls, wantHits = 1, 1
+			}
+			if n := u.calls.Load(); n != wantCalls {
+				t.Errorf("upstream got %d queries, want %d", n, wantCalls)
+			}
+			if st := c.Stats(); st.Hits != wantHits || st.Misses != uint64(wantCalls) {
+				t.Errorf("got stats %+v, want %d hits, %d misses", st, wantHits, wantCalls)
+			}
+		})
+	}
+}
+
+func TestCacheAging(t *testing.T) {
+	u := &testUpstream{a: [4]byte{192, 0, 2, 1}, ttl: 300}
+	c := NewCache(u, 10)
+	for _, tc := range []struct {
+		age   time.Duration
+		ttl   uint32
+		calls int32
+	}{
+		{0, 300, 1},
+		{100 * time.Second, 200, 1},
+		{150 * time.Second, 50, 1},
+		{50 * time.Second, 300, 2}, // expired, queried upstream again
+	} {
+		ageCache(c, tc.age)
+		msg, err := cacheQuery(t, c, "example.com", dnsmessage.TypeA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ttl := recordTTL(t, msg); ttl != tc.ttl {
+			t.Errorf("after %v: got TTL %d, want %d", tc.age, ttl, tc.ttl)
+		}
+		if n := u.calls.Load(); n != tc.calls {
+			t.Errorf("after %v: upstream got %d queries, want %d", tc.age, n, tc.calls)
+		}
+	}
+}
+
+func TestCacheKeys(t *testing.T) {
+	u := &testUpstream{a: [4]byte{192, 0, 2, 1}, ttl: 300}
+	c := NewCache(u, 10)
+	for _, tc := range []struct {
+		name  string
+		typ   dnsmessage.Type
+		calls int32
+	}{
+		{"example.com", dnsmessage.TypeA, 1},
+		{"EXAMPLE.com", dnsmessage.TypeA, 1},
+		{"example.com", dnsmessage.TypeAAAA, 2},
+		{"www.example.com", dnsmessage.TypeA, 3},
+	} {
+		if _, err := cacheQuery(t, c, tc.name, tc.typ); err != nil {
+			t.Fatal(err)
+		}
+		if n := u.calls.Load(); n != tc.calls {
+			t.Errorf("%s %v: upstream got %d queries, want %d", tc.name, tc.typ, n, tc.calls)
+		}
+	}
+	if n := c.Stats().Len; n != 3 {
+		t.Errorf("got %d responses cached, want 3", n)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	u := &testUpstream{a: [4]byte{192, 0, 2, 1}, ttl: 300}
+	c := NewCache(u, 2)
+	for _, name := range []string{"a.example", "b.example", "a.example", "c.example"} {
+		if _, err := cacheQuery(t, c, name, dnsmessage.TypeA); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var names []string
+	for _, ent := range c.Entries() {
+		names = append(names, ent.Name)
+	}
+	if len(names) != 2 || names[0] != "c.example" || names[1] != "a.example" {
+		t.Fatalf("got entries %q, want c.example and a.example", names)
+	}
+	if _, ok := c.TTL("b.example", dnsmessage.TypeA); ok {
+		t.Error("least recently used response was not evicted")
+	}
+}
+
+func TestCacheTTLBounds(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		min, max time.Duration
+		rcode    dnsmessage.RCode
+		ttl      uint32 // upstream TTL
+		want     uint32
+	}{
+		{"raised", time.Minute, 0, dnsmessage.RCodeSuccess, 5, 60},
+		{"lowered", 0, 5 * time.Minute, dnsmessage.RCodeSuccess, 3600, 300},
+		{"within", time.Minute, 5 * time.Minute, dnsmessage.RCodeSuccess, 120, 120},
+		{"nxdomain raised", time.Minute, 0, dnsmessage.RCodeNameError, 5, 60},
+		{"nxdomain lowered", 0, 5 * time.Minute, dnsmessage.RCodeNameError, 3600, 300},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			u := &testUpstream{a: [4]byte{192, 0, 2, 1}, ttl: tc.ttl, rcode: tc.rcode}
+			c := NewCache(u, 10)
+			c.MinTTL, c.MaxTTL = tc.min, tc.max
+			// the first response is passed from upstream as is, bounds
+			// only apply to responses served from cache
+			for i, want := range []uint32{tc.ttl, tc.want} {
+				msg, err := cacheQuery(t, c, "example.com", dnsmessage.TypeA)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ttl := recordTTL(t, msg); ttl != want {
+					t.Errorf("query #%d: got TTL %d, want %d", i+1, ttl, want)
+				}
+			}
+			ttl, ok := c.TTL("example.com", dnsmessage.TypeA)
+			if want := time.Duration(tc.want) * time.Second; !ok || ttl > want || ttl < want-time.Second {
+				t.Errorf("got cache TTL %v, %t, want %v", ttl, ok, want)
+			}
+			if n := u.calls.Load(); n != 1 {
+				t.Errorf("upstream got %d queries, want 1", n)
+			}
+		})
+	}
+}
+
+func TestCacheServeStale(t *testing.T) {
+	const serveStale = 24 * time.Hour
+	for _, tc := range []struct {
+		name    string
+		age     time.Duration
+		set     func(u *testUpstream)
+		wantA   [4]byte
+		wantTTL uint32
+		stale   bool
+		wantErr bool
+	}{
+		{
+			name:    "refreshed",
+			age:     301 * time.Second,
+			set:     func(u *testUpstream) { u.a = [4]byte{192, 0, 2, 2} },
+			wantA:   [4]byte{192, 0, 2, 2},
+			wantTTL: 300,
+		},
+		{
+			name:    "upstream error",
+			age:     301 * time.Second,
+			set:     func(u *testUpstream) { u.err = errors.New("upstream failure") },
+			wantA:   [4]byte{192, 0, 2, 1},
+			wantTTL: staleAnswerTTL,
+			stale:   true,
+		},
+		{
+			name:    "servfail",
+			age:     301 * time.Second,
+			set:     func(u *testUpstream) { u.rcode = dnsmessage.RCodeServerFailure },
+			wantA:   [4]byte{192, 0, 2, 1},
+			wantTTL: staleAnswerTTL,
+			stale:   true,
+		},
+		{
+			name:    "too old",
+			age:     300*time.Second + serveStale,
+			set:     func(u *testUpstream) { u.err = errors.New("upstream failure") },
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			u := &testUpstream{a: [4]byte{192, 0, 2, 1}, ttl: 300}
+			c := NewCache(u, 10)
+			c.ServeStale = serveStale
+			if _, err := cacheQuery(t, c, "example.com", dnsmessage.TypeA); err != nil {
+				t.Fatal(err)
+			}
+			ageCache(c, tc.age)
+			u.set(tc.set)
+			msg, err := cacheQuery(t, c, "example.com", dnsmessage.TypeA)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("got no error for response expired beyond ServeStale")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a := msg.Answers[0].Body.(*dnsmessage.AResource).A; a != tc.wantA {
+				t.Errorf("got address %v, want %v", a, tc.wantA)
+			}
+			if ttl := recordTTL(t, msg); ttl != tc.wantTTL {
+				t.Errorf("got TTL %d, want %d", ttl, tc.wantTTL)
+			}
+			var wantStale uint64
+			if tc.stale {
+				wantStale = 1
+			}
+			if n := c.Stats().Stale; n != wantStale {
+				t.Errorf("got %d stale answers, want %d", n, wantStale)
+			}
+		})
+	}
+}
+
+func TestCacheServeStaleRecheck(t *testing.T) {
+	u := &testUpstream{a: [4]byte{192, 0, 2, 1}, ttl: 300}
+	c := NewCache(u, 10)
+	c.ServeStale = time.Hour
+	if _, err := cacheQuery(t, c, "example.com", dnsmessage.TypeA); err != nil {
+		t.Fatal(err)
+	}
+	ageCache(c, 301*time.Second)
+	u.set(func(u *testUpstream) { u.err = errors.New("upstream failure") })
+	for _, tc := range []struct {
+		age   time.Duration
+		calls int32
+	}{
+		{0, 2},                             // refresh fails
+		{10 * time.Second, 2},              // no retry until staleRecheck passes
+		{staleRecheck - 10*time.Second, 3}, // retried
+	} {
+		ageCache(c, tc.age)
+		msg, err := cacheQuery(t, c, "example.com", dnsmessage.TypeA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ttl := recordTTL(t, msg); ttl != staleAnswerTTL {
+			t.Errorf("got TTL %d, want stale answer", ttl)
+		}
+		if n := u.calls.Load(); n != tc.calls {
+			t.Errorf("after %v: upstream got %d queries, want %d", tc.age, n, tc.calls)
+		}
+	}
+}
+
+func TestCacheServeStaleSlow(t *testing.T) {
+	u := &testUpstream{a: [4]byte{192, 0, 2, 1}, ttl: 300}
+	c := NewCache(u, 10)
+	c.ServeStale = time.Hour
+	if _, err := cacheQuery(t, c, "example.com", dnsmessage.TypeA); err != nil {
+		t.Fatal(err)
+	}
+	ageCache(c, 301*time.Second)
+	u.set(func(u *testUpstream) {
+		u.a = [4]byte{192, 0, 2, 2}
+		u.delay = staleWait + 500*time.Millisecond
+	})
+	begin := time.Now()
+	msg, err := cacheQuery(t, c, "example.com", dnsmessage.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(begin); d > staleWait+250*time.Millisecond {
+		t.Errorf("stale answer took %v", d)
+	}
+	if ttl := recordTTL(t, msg); ttl != staleAnswerTTL {
+		t.Fatalf("got TTL %d, want stale answer", ttl)
+	}
+	// refresh completes in background
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := c.TTL("example.com", dnsmessage.TypeA); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("response was not refreshed in background")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if msg, err = cacheQuery(t, c, "example.com", dnsmessage.TypeA); err != nil {
+		t.Fatal(err)
+	}
+	if a := msg.Answers[0].Body.(*dnsmessage.AResource).A; a != [4]byte{192, 0, 2, 2} {
+		t.Errorf("got address %v after refresh, want 192.0.2.2", a)
+	}
+	if n := u.calls.Load(); n != 2 {
+		t.Errorf("upstream got %d queries, want 2", n)
+	}
+}
@@ -0,0 +1,38 @@
+package dot
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// CanaryDomain is the domain networks use to signal that applications should
+// not enable encrypted DNS on their own, see
+// https://support.mozilla.org/kb/canary-domain-use-application-dnsnet.
+const CanaryDomain = "use-application-dns.net"
+
+// CheckCanary looks up CanaryDomain using network-provided resolver r, and
+// reports whether the network asks to keep using its own resolver, which
+// networks doing enterprise filtering or parental controls do by answering
+// the lookup with NXDOMAIN or no addresses. If r is nil, a plain resolver
+// using system configuration is used; note that it is not
+// net.DefaultResolver, which might have been replaced with SetDefault.
+//
+// If lookup fails for other reasons, CheckCanary returns an error, and it is
+// up to the caller to decide whether to enable encrypted DNS. For example, to
+// only switch to encrypted DNS if network does not object:
+//
+//	if disable, err := dot.CheckCanary(ctx, nil); err == nil && !disable {
+//		dot.SetDefault(dot.Quad9())
+//	}
+func CheckCanary(ctx context.Context, r *net.Resolver) (disable bool, err error) {
+	if r == nil {
+		r = &net.Resolver{}
+	}
+	_, err = r.LookupHost(ctx, CanaryDomain)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return true, nil
+	}
+	return false, err
+}
@@ -0,0 +1,308 @@
+package dot
+
+import (
+	"bufio"
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// captiveCheckInterval limits how often CaptivePortal checks whether
+	// network is behind a captive portal, and whether upstream is
+	// reachable again while bypass is active.
+	captiveCheckInterval = 5 * time.Second
+
+	// plainTimeout limits a single plain DNS exchange if its context has
+	// no earlier deadline.
+	plainTimeout = 5 * time.Second
+)
+
+// CaptivePortal is a Handler that sends queries to strict, usually a
+// DNS-over-TLS upstream, but once strict fails on a network that looks like
+// the one behind a captive portal, temporarily answers them using plain DNS
+// servers of the network instead, so that the user can complete the portal
+// login. Network is considered to be behind a captive portal if its
+// DNS servers answer a query for a random name in the "invalid." domain,
+// which does not exist, with addresses, as portals hijacking DNS do.
+//
+// While bypass is active, strict is checked in background every few seconds,
+// and bypass ends as soon as it works again, or once the bypass window
+// passes, whichever happens first. Bypass is never entered on networks that
+// merely block DNS-over-TLS without hijacking plain DNS.
+type CaptivePortal struct {
+	// Nameservers lists plain DNS servers of the network in the host:port
+	// form. If empty, servers from /etc/resolv.conf are used, or
+	// 127.0.0.1:53 if it lists none. Set it where /etc/resolv.conf does not
+	// list network servers, as on Windows, or on macOS, where system
+	// configuration manages DNS settings; there plain queries otherwise go
+	// to 127.0.0.1:53, where usually nothing answers. It must not be changed
+	// once CaptivePortal is used.
+	Nameservers []string
+
+	strict Handler
+	window time.Duration
+	notify func(bypass bool)
+
+	mu        sync.Mutex
+	until     time.Time     // bypass is active until this time
+	checked   time.Time     // last time portal detection was done
+	detecting chan struct{} // closed once detection in progress completes
+	probing   bool          // background check of strict is in progress
+}
+
+// NewCaptivePortal returns CaptivePortal wrapping strict, that bypasses it
+// for at most window, 5 minutes if window is not positive. Bypassing queries
+// leaks them to the network in clear text, so if notify is not nil, it is
+// called with true when bypass starts and with false when it ends, letting
+// the application clearly flag this to the user. It must not block.
+func NewCaptivePortal(strict Handler, window time.Duration, notify func(bypass bool)) *CaptivePortal {
+	if strict == nil {
+		panic("dot: nil Handler")
+	}
+	if window <= 0 {
+		window = 5 * time.Minute
+	}
+	if notify == nil {
+		notify = func(bool) {}
+	}
+	return &CaptivePortal{strict: strict, window: window, notify: notify}
+}
+
+// Bypassed reports whether queries are currently answered using plain DNS.
+func (c *CaptivePortal) Bypassed() bool {
+	c.mu.Lock()
+	bypassed, ended := c.bypassed()
+	c.mu.Unlock()
+	if ended {
+		c.notify(false)
+	}
+	return bypassed
+}
+
+// bypassed reports whether bypass is active, ending it if its window has
+// passed, which is reported by ended; c.mu must be held.
+func (c *CaptivePortal) bypassed() (bypassed, ended bool) {
+	if c.until.IsZero() {
+		return false, false
+	}
+	if time.Now().Before(c.until) {
+		return true, false
+	}
+	c.until = time.Time{}
+	return false, true
+}
+
+// ServeDNS implements Handler interface.
+func (c *CaptivePortal) ServeDNS(ctx context.Context, query []byte) ([]byte, error) {
+	c.mu.Lock()
+	bypassed, ended := c.bypassed()
+	if bypassed && !c.probing {
+		c.probing = true
+		go c.probe()
+	}
+	c.mu.Unlock()
+	if ended {
+		c.notify(false)
+	}
+	if bypassed {
+		return plainExchange(ctx, c.nameservers(), query)
+	}
+	resp, err := c.strict.ServeDNS(ctx, query)
+	if err == nil || ctx.Err() != nil || !c.detect(ctx) {
+		return resp, err
+	}
+	return plainExchange(ctx, c.nameservers(), query)
+}
+
+// detect reports whether network is behind a captive portal, starting bypass
+// if it is. Detection is done at most once per captiveCheckInterval; in
+// between, detect only reports whether bypass is active, waiting for
+// detection in progress to complete first.
+func (c *CaptivePortal) detect(ctx context.Context) bool {
+	c.mu.Lock()
+	if done := c.detecting; done != nil {
+		c.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return false
+		}
+		c.mu.Lock()
+	}
+	if time.Since(c.checked) < captiveCheckInterval {
+		bypassed, _ := c.bypassed()
+		c.mu.Unlock()
+		return bypassed
+	}
+	c.checked = time.Now()
+	done := make(chan struct{})
+	c.detecting = done
+	c.mu.Unlock()
+
+	captive := dnsHijacked(ctx, c.nameservers())
+	c.mu.Lock()
+	c.detecting = nil
+	close(done)
+	bypassed, _ := c.bypassed()
+	started := captive && !bypassed
+	if started {
+		c.until = time.Now().Add(c.window)
+	}
+	c.mu.Unlock()
+	if started {
+		c.notify(true)
+	}
+	return captive
+}
+
+// probe checks whether strict works again, ending bypass if it does.
+func (c *CaptivePortal) probe() {
+	defer func() {
+		c.mu.Lock()
+		c.probing = false
+		c.mu.Unlock()
+	}()
+	time.Sleep(captiveCheckInterval)
+	query, err := newQuery(verifyName, dnsmessage.TypeA)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), captiveCheckInterval)
+	defer cancel()
+	if _, err := c.strict.ServeDNS(ctx, query); err != nil {
+		return
+	}
+	c.mu.Lock()
+	ended := !c.until.IsZero()
+	c.until = time.Time{}
+	c.mu.Unlock()
+	if ended {
+		c.notify(false)
+	}
+}
+
+// dnsHijacked reports whether plain DNS servers answer a query for a name
+// that cannot exist with addresses.
+func dnsHijacked(ctx context.Context, servers []string) bool {
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		return false
+	}
+	query, err := newQuery(hex.EncodeToString(b[:])+".invalid", dnsmessage.TypeA)
+	if err != nil {
+		return false
+	}
+	resp, err := plainExchange(ctx, servers, query)
+	if err != nil || checkResponse(query, resp, false) != nil {
+		return false
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(resp); err != nil {
+		return false
+	}
+	for _, rr := range msg.Answers {
+		if rr.Header.Type == dnsmessage.TypeA {
+			return true
+		}
+	}
+	return false
+}
+
+// plainExchange sends query to the first of servers that answers it over
+// plain DNS, retrying over TCP if response is truncated.
+func plainExchange(ctx context.Context, servers []string, query []byte) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, plainTimeout)
+		defer cancel()
+	}
+	err := errors.New("dot: no plain DNS servers")
+	for _, addr := range servers {
+		var resp []byte
+		if resp, err = plainExchangeWith(ctx, "udp", addr, query); err == nil {
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, err
+}
+
+func plainExchangeWith(ctx context.Context, network, addr string, query []byte) ([]byte, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if d, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(d)
+	}
+	stop := closeOnDone(ctx, conn)
+	defer stop()
+	if network == "tcp" {
+		if err := writeMsg(conn, query); err != nil {
+			return nil, err
+		}
+		return readMsg(conn)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, maxMsgSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		resp := buf[:n]
+		if len(resp) < 12 || resp[0] != query[0] || resp[1] != query[1] {
+			continue // not a response to this query
+		}
+		if resp[2]&0x02 != 0 { // TC bit
+			return plainExchangeWith(ctx, "tcp", addr, query)
+		}
+		return append([]byte(nil), resp...), nil
+	}
+}
+
+// nameservers returns addresses of plain DNS servers to use.
+func (c *CaptivePortal) nameservers() []string {
+	if len(c.Nameservers) != 0 {
+		return c.Nameservers
+	}
+	return systemNameservers()
+}
+
+// systemNameservers returns addresses of DNS servers listed in
+// /etc/resolv.conf, or the local one if there are none.
+func systemNameservers() []string {
+	var out []string
+	if f, err := os.Open("/etc/resolv.conf"); err == nil {
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			fields := strings.Fields(sc.Text())
+			if len(fields) < 2 || fields[0] != "nameserver" {
+				continue
+			}
+			if ip := net.ParseIP(strings.SplitN(fields[1], "%", 2)[0]); ip != nil {
+				out = append(out, net.JoinHostPort(fields[1], "53"))
+			}
+		}
+	}
+	if len(out) == 0 {
+		out = []string{"127.0.0.1:53"}
+	}
+	return out
+}
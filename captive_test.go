@@ -0,0 +1,108 @@
+package dot_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/artyom/dot"
+	"github.com/artyom/dot/dottest"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// plainServer starts plain DNS server answering queries with h and returns
+// its address.
+func plainServer(t *testing.T, h dot.Handler) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		t.Fatal(err)
+	}
+	p := &dot.Proxy{Handler: h}
+	go p.Serve(pc, l)
+	t.Cleanup(func() { p.Close() })
+	return pc.LocalAddr().String()
+}
+
+// hijacker answers every A query with the same address, as DNS servers of
+// captive portals do.
+type hijacker struct{}
+
+func (hijacker) ServeDNS(ctx context.Context, query []byte) ([]byte, error) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil {
+		return nil, err
+	}
+	msg.Header.Response, msg.Header.RecursionAvailable = true, true
+	for _, q := range msg.Questions {
+		if q.Type == dnsmessage.TypeA {
+			msg.Answers = append(msg.Answers, dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: q.Class, TTL: 60},
+				Body:   &dnsmessage.AResource{A: [4]byte{10, 1, 2, 3}},
+			})
+		}
+	}
+	msg.Additionals = nil
+	return msg.Pack()
+}
+
+func TestCaptivePortal(t *testing.T) {
+	failing := dot.HandlerFunc(func(context.Context, []byte) ([]byte, error) {
+		return nil, errors.New("port 853 blocked")
+	})
+	working := dottest.Zone{"example.com": {"192.0.2.1"}}
+	for _, tc := range []struct {
+		name       string
+		strict     dot.Handler
+		plain      dot.Handler
+		want       string // expected address, empty if lookup must fail
+		wantBypass bool
+	}{
+		{name: "strict works", strict: working, plain: hijacker{}, want: "192.0.2.1"},
+		{name: "captive portal", strict: failing, plain: hijacker{}, want: "10.1.2.3", wantBypass: true},
+		{name: "only dot blocked", strict: failing, plain: working},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var events []bool
+			cp := dot.NewCaptivePortal(tc.strict, time.Minute, func(bypass bool) {
+				mu.Lock()
+				defer mu.Unlock()
+				events = append(events, bypass)
+			})
+			cp.Nameservers = []string{plainServer(t, tc.plain)}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			addrs, err := dot.NewResolver(cp).LookupHost(ctx, "example.com")
+			if tc.want == "" {
+				if err == nil {
+					t.Fatalf("lookup succeeded with %v, want error", addrs)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if len(addrs) != 1 || addrs[0] != tc.want {
+				t.Fatalf("got %v, want %s", addrs, tc.want)
+			}
+			if got := cp.Bypassed(); got != tc.wantBypass {
+				t.Errorf("Bypassed() = %t, want %t", got, tc.wantBypass)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if tc.wantBypass && (len(events) != 1 || !events[0]) {
+				t.Errorf("notify called with %v, want single true", events)
+			}
+			if !tc.wantBypass && len(events) != 0 {
+				t.Errorf("notify called with %v, want no calls", events)
+			}
+		})
+	}
+}
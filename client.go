@@ -0,0 +1,667 @@
+package dot
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ErrClientClosed is returned by Client's Exchange method after a call to
+// Shutdown or Close.
+var ErrClientClosed = errors.New("dot: client closed")
+
+const (
+	// maxPipelined limits the number of queries in flight over a single
+	// pooled connection; once all connections reach it, a new one is
+	// established.
+	maxPipelined = 100
+
+	// connIdleTimeout is how long pooled connection is kept open with no
+	// queries in flight.
+	connIdleTimeout = 30 * time.Second
+
+	// writeTimeout limits how long writing a single query may take if the
+	// query context has no earlier deadline.
+	writeTimeout = 10 * time.Second
+)
+
+// Client exchanges DNS messages with DNS-over-TLS service over a pool of
+// persistent connections, pipelining concurrent queries over them as
+// described in RFC 7766, section 6.2.1.1. Unlike Resolver returned by New,
+// which establishes a new connection for every lookup, Client reuses
+// connections until they stay idle for a while, or the service closes them,
+// unless created with WithoutPooling option.
+//
+// If a pooled connection turns out to be broken when sending query over it,
+// or breaks before the response arrives, as happens when upstream restarts or
+// silently drops idle connections, query is transparently retried over a new
+// connection.
+//
+// Client is safe for concurrent use. It implements Handler, so it can serve
+// as an upstream for Proxy, Server or Cache.
+type Client struct {
+	dial      func(ctx context.Context, network, address string) (net.Conn, error)
+	timeout   time.Duration
+	noPooling bool
+	keepWarm  time.Duration
+
+	mu      sync.Mutex
+	conns   map[*clientConn]struct{}
+	dialing chan struct{} // closed once connection being dialed is ready
+	active  int           // queries in flight
+	closed  bool
+	stats   clientCounters
+
+	inShutdown bool // new queries are refused
+}
+
+// NewClient returns Client using DNS-over-TLS service reachable on given
+// addresses, verifying that its certificate is valid for serverName. Its
+// arguments have the same meaning as those of New.
+//
+// NewClient panics if serverName or addrs are empty, and reports other
+// problems with them only once it dials upstream. Use NewClientChecked to
+// build Client from user-supplied configuration: it returns an error
+// instead.
+func NewClient(serverName string, addrs []string, opts ...Option) *Client {
+	cfg := newConfig(opts)
+	return &Client{
+		dial:      newDialFunc(serverName, addrs, cfg),
+		timeout:   cfg.timeout,
+		noPooling: cfg.noPooling,
+		keepWarm:  cfg.keepWarm,
+		conns:     make(map[*clientConn]struct{}),
+	}
+}
+
+// NewClientConn returns Client doing DNS-over-TLS over conn, an established
+// connection to upstream supplied by the caller, such as one tunneled over
+// SSH. TLS handshake with upstream is done over conn, verifying that its
+// certificate is valid for serverName, and queries are pipelined over it.
+// Once conn breaks or is closed for staying idle, Client fails all further
+// queries; use WithDialer option with NewClient to have new connections
+// established instead. WithKeepWarm option keeps conn from being closed as
+// idle.
+//
+// NewClientConn panics if conn is nil or serverName is empty.
+func NewClientConn(conn net.Conn, serverName string, opts ...Option) *Client {
+	if conn == nil {
+		panic("dot: nil conn")
+	}
+	var mu sync.Mutex
+	dial := func(context.Context, string, string) (net.Conn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if conn == nil {
+			return nil, errConnUsed
+		}
+		c := conn
+		conn = nil
+		return c, nil
+	}
+	opts = append(opts[:len(opts):len(opts)], WithDialer(dial))
+	return NewClient(serverName, []string{"conn"}, opts...)
+}
+
+// errConnUsed is returned by Client created with NewClientConn once its
+// connection is gone.
+var errConnUsed = errors.New("dot: supplied connection is no longer usable")
+
+// Resolver returns Resolver doing its lookups through c. Since its
+// connections are shared, Verify cannot inspect them; use it with Resolver
+// returned by New instead.
+func (c *Client) Resolver() *net.Resolver {
+	return NewResolver(c)
+}
+
+// ServeDNS calls c.Exchange(ctx, query).
+func (c *Client) ServeDNS(ctx context.Context, query []byte) ([]byte, error) {
+	return c.Exchange(ctx, query)
+}
+
+// Exchange sends wire-format DNS query upstream and returns wire-format
+// response.
+func (c *Client) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	resp, _, err := c.exchange(ctx, query)
+	return resp, err
+}
+
+// exchange is like Exchange, but it also returns address of the upstream the
+// response came from.
+func (c *Client) exchange(ctx context.Context, query []byte) (resp []byte, addr net.Addr, err error) {
+	if len(query) < 12 {
+		return nil, nil, errors.New("dot: query too short")
+	}
+	if len(query) > maxMsgSize {
+		return nil, nil, errors.New("dot: message too large")
+	}
+	c.mu.Lock()
+	if c.closed || c.inShutdown {
+		c.mu.Unlock()
+		return nil, nil, ErrClientClosed
+	}
+	c.active++
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.active--
+		c.stats.countQuery(query, resp, err)
+		c.mu.Unlock()
+	}()
+	if _, ok := ctx.Deadline(); !ok && c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	if _, ok := upstreamFrom(ctx); ok || c.noPooling {
+		return c.exchangeDirect(ctx, query)
+	}
+	var fresh bool
+	for {
+		cc, reused, err := c.getConn(ctx, fresh)
+		if err != nil {
+			return nil, nil, err
+		}
+		resp, err := cc.roundTrip(ctx, query)
+		c.putConn(cc)
+		c.countUpstream(cc, err)
+		if err == nil {
+			return resp, cc.conn.RemoteAddr(), nil
+		}
+		// retry once over a new connection if pooled one turned out to
+		// be broken; queries are idempotent, so it is safe even if
+		// upstream got the query before connection broke
+		if reused && !fresh && errors.Is(err, errConnLost) && ctx.Err() == nil {
+			fresh = true
+			continue
+		}
+		return nil, nil, err
+	}
+}
+
+// exchangeDirect is like exchange, but it sends query over a new connection
+// not shared with other queries, which is closed once response is received.
+func (c *Client) exchangeDirect(ctx context.Context, query []byte) ([]byte, net.Addr, error) {
+	cc, err := c.newConn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cc.conn.Close()
+	go cc.readLoop()
+	resp, err := cc.roundTrip(ctx, query)
+	c.countUpstream(cc, err)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, cc.conn.RemoteAddr(), nil
+}
+
+// getConn returns connection with a slot reserved for a new query, which must
+// be released with putConn. Unless fresh is true, it prefers the least loaded
+// pooled connection, only establishing a new one if none have slots left.
+// The reused result reports whether returned connection was taken from the
+// pool.
+func (c *Client) getConn(ctx context.Context, fresh bool) (cc *clientConn, reused bool, err error) {
+	c.mu.Lock()
+	for {
+		if c.closed {
+			c.mu.Unlock()
+			return nil, false, ErrClientClosed
+		}
+		if !fresh {
+			for v := range c.conns {
+				if v.inflight < maxPipelined && (cc == nil || v.inflight < cc.inflight) {
+					cc = v
+				}
+			}
+		}
+		if cc != nil {
+			cc.reserve()
+			c.mu.Unlock()
+			return cc, true, nil
+		}
+		if fresh || c.dialing == nil {
+			break
+		}
+		// wait for connection being dialed by another query instead of
+		// dialing many at once on a burst of queries
+		dialing := c.dialing
+		c.mu.Unlock()
+		select {
+		case <-dialing:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+		c.mu.Lock()
+	}
+	var dialing chan struct{}
+	if !fresh {
+		dialing = make(chan struct{})
+		c.dialing = dialing
+	}
+	c.mu.Unlock()
+	if dialing != nil {
+		defer func() {
+			c.mu.Lock()
+			c.dialing = nil
+			c.mu.Unlock()
+			close(dialing)
+		}()
+	}
+
+	if cc, err = c.newConn(ctx); err != nil {
+		return nil, false, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		cc.conn.Close()
+		return nil, false, ErrClientClosed
+	}
+	c.conns[cc] = struct{}{}
+	cc.reserve()
+	go cc.readLoop()
+	return cc, false, nil
+}
+
+// newConn establishes a new connection, not yet added to the pool.
+func (c *Client) newConn(ctx context.Context) (*clientConn, error) {
+	conn, err := c.dial(ctx, "tcp", "")
+	if err != nil {
+		return nil, err
+	}
+	if h, ok := conn.(interface {
+		HandshakeContext(context.Context) error
+	}); ok {
+		if err := h.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	c.mu.Lock()
+	c.stats.dials++
+	c.mu.Unlock()
+	return &clientConn{
+		c:       c,
+		conn:    conn,
+		pending: make(map[uint16]chan []byte),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// putConn releases query slot on cc reserved by getConn.
+func (c *Client) putConn(cc *clientConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cc.inflight--
+	if cc.inflight != 0 {
+		return
+	}
+	if _, ok := c.conns[cc]; !ok {
+		return
+	}
+	wait := connIdleTimeout
+	if c.keepWarm > 0 {
+		wait = c.keepWarm
+	}
+	cc.idle = time.AfterFunc(wait, func() {
+		c.mu.Lock()
+		idle := cc.inflight == 0
+		if _, ok := c.conns[cc]; idle && ok && c.keepWarm > 0 && len(c.conns) == 1 {
+			cc.reserve()
+			c.mu.Unlock()
+			go c.keepWarmConn(cc)
+			return
+		}
+		if idle {
+			delete(c.conns, cc)
+		}
+		c.mu.Unlock()
+		if idle {
+			cc.conn.Close()
+		}
+	})
+}
+
+// keepWarmConn sends a probe query over idle cc, which must have a slot
+// reserved, closing it if probe fails.
+func (c *Client) keepWarmConn(cc *clientConn) {
+	defer c.putConn(cc)
+	query, err := newQuery(".", dnsmessage.TypeNS)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+	if _, err := cc.roundTrip(ctx, query); err != nil {
+		cc.fail(err)
+	}
+}
+
+// removeConn removes cc from the pool.
+func (c *Client) removeConn(cc *clientConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.conns, cc)
+}
+
+// Shutdown gracefully shuts down the client: it refuses new queries with
+// ErrClientClosed, waits for queries in flight to complete, then closes all
+// pooled connections. If ctx expires before that, Shutdown closes everything
+// and returns context's error. Use it to let lookups in progress finish when
+// the program is stopping:
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+//	defer cancel()
+//	c.Shutdown(ctx)
+func (c *Client) Shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	c.inShutdown = true
+	c.mu.Unlock()
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		c.mu.Lock()
+		active := c.active
+		c.mu.Unlock()
+		if active == 0 {
+			return c.Close()
+		}
+		select {
+		case <-ctx.Done():
+			c.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close closes all pooled connections, failing queries in flight over them.
+// Once closed, Client cannot be used anymore. For a graceful shutdown, use
+// Shutdown.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	conns := c.conns
+	c.conns = make(map[*clientConn]struct{})
+	c.mu.Unlock()
+	for cc := range conns {
+		cc.conn.Close()
+	}
+	return nil
+}
+
+// errConnLost marks errors caused by connection breaking while query was in
+// flight over it, which makes query worth retrying over another connection.
+var errConnLost = errors.New("dot: connection lost")
+
+// connLostError wraps an error caused by connection breaking.
+type connLostError struct{ err error }
+
+func (e *connLostError) Error() string        { return e.err.Error() }
+func (e *connLostError) Unwrap() error        { return e.err }
+func (e *connLostError) Is(target error) bool { return target == errConnLost }
+
+// clientConn is a single pooled connection multiplexing queries by their IDs.
+type clientConn struct {
+	c    *Client
+	conn net.Conn
+
+	// guarded by Client.mu
+	inflight int
+	idle     *time.Timer
+
+	wmu sync.Mutex // serializes writes
+
+	mu      sync.Mutex
+	pending map[uint16]chan []byte
+	nextID  uint16
+	err     error         // set once connection is broken
+	done    chan struct{} // closed once connection is broken
+}
+
+// reserve reserves query slot on cc; Client.mu must be held.
+func (cc *clientConn) reserve() {
+	cc.inflight++
+	if cc.idle != nil {
+		cc.idle.Stop()
+		cc.idle = nil
+	}
+}
+
+// roundTrip sends query over cc and waits for the matching response. Query ID
+// is replaced with the one unique among queries in flight over cc, and
+// restored in response.
+func (cc *clientConn) roundTrip(ctx context.Context, query []byte) ([]byte, error) {
+	cc.mu.Lock()
+	if cc.err != nil {
+		err := cc.err
+		cc.mu.Unlock()
+		return nil, &connLostError{err}
+	}
+	for {
+		cc.nextID++
+		if _, ok := cc.pending[cc.nextID]; !ok {
+			break
+		}
+	}
+	id := cc.nextID
+	ch := make(chan []byte, 1)
+	cc.pending[id] = ch
+	cc.mu.Unlock()
+	defer func() {
+		cc.mu.Lock()
+		delete(cc.pending, id)
+		cc.mu.Unlock()
+	}()
+
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+	binary.BigEndian.PutUint16(msg[2:], id)
+	deadline := time.Now().Add(writeTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	cc.wmu.Lock()
+	if err := ctx.Err(); err != nil {
+		// don't break connection with a write that cannot succeed
+		cc.wmu.Unlock()
+		return nil, err
+	}
+	cc.conn.SetWriteDeadline(deadline)
+	_, err := cc.conn.Write(msg)
+	cc.wmu.Unlock()
+	if err != nil {
+		// partially written query breaks framing, connection cannot
+		// be used anymore
+		cc.fail(err)
+		return nil, &connLostError{err}
+	}
+	select {
+	case resp := <-ch:
+		copy(resp[:2], query[:2])
+		return resp, nil
+	case <-cc.done:
+		return nil, &connLostError{cc.err}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// readLoop reads responses from cc, dispatching them to queries they answer,
+// until connection breaks.
+func (cc *clientConn) readLoop() {
+	for {
+		resp, err := readMsg(cc.conn)
+		if err != nil {
+			cc.fail(err)
+			return
+		}
+		if len(resp) < 12 {
+			cc.fail(errors.New("dot: response too short"))
+			return
+		}
+		cc.mu.Lock()
+		ch := cc.pending[binary.BigEndian.Uint16(resp)]
+		delete(cc.pending, binary.BigEndian.Uint16(resp))
+		cc.mu.Unlock()
+		if ch != nil {
+			ch <- resp
+		}
+	}
+}
+
+// fail marks cc as broken, closes it and removes it from the pool.
+func (cc *clientConn) fail(err error) {
+	cc.mu.Lock()
+	if cc.err == nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		cc.err = err
+		close(cc.done)
+	}
+	cc.mu.Unlock()
+	cc.conn.Close()
+	cc.c.removeConn(cc)
+}
+
+// queryConn is a net.Conn handed out by Resolver returned by NewResolver. It
+// collects length-prefixed queries written to it, and answers them through
+// Handler on read.
+type queryConn struct {
+	h   Handler
+	ctx context.Context
+
+	mu       sync.Mutex
+	deadline time.Time
+	cancel   context.CancelFunc // cancels exchange in progress, if any
+	remote   net.Addr
+	wbuf     []byte // queries written, but not yet answered
+	rbuf     []byte // framed response not yet read
+	closed   bool
+}
+
+func (q *queryConn) Write(b []byte) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return 0, net.ErrClosed
+	}
+	q.wbuf = append(q.wbuf, b...)
+	return len(b), nil
+}
+
+func (q *queryConn) Read(b []byte) (int, error) {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return 0, net.ErrClosed
+	}
+	if len(q.rbuf) == 0 {
+		if len(q.wbuf) < 2 || len(q.wbuf) < 2+int(binary.BigEndian.Uint16(q.wbuf)) {
+			q.mu.Unlock()
+			return 0, io.EOF
+		}
+		n := 2 + int(binary.BigEndian.Uint16(q.wbuf))
+		query := q.wbuf[2:n]
+		q.wbuf = q.wbuf[n:]
+		ctx, cancel := context.WithCancel(q.ctx)
+		defer cancel()
+		if !q.deadline.IsZero() {
+			if !time.Now().Before(q.deadline) {
+				q.mu.Unlock()
+				return 0, os.ErrDeadlineExceeded
+			}
+			ctx, cancel = context.WithDeadline(ctx, q.deadline)
+			defer cancel()
+		}
+		q.cancel = cancel
+		q.mu.Unlock()
+		var resp []byte
+		var remote net.Addr
+		var err error
+		if c, ok := q.h.(*Client); ok {
+			resp, remote, err = c.exchange(ctx, query)
+		} else {
+			resp, err = q.h.ServeDNS(ctx, query)
+			if err == nil && resp == nil {
+				err = errors.New("dot: query dropped")
+			}
+		}
+		q.mu.Lock()
+		q.cancel = nil
+		if err != nil {
+			closed := q.closed
+			q.mu.Unlock()
+			if closed {
+				return 0, net.ErrClosed
+			}
+			if ctx.Err() != nil && q.ctx.Err() == nil {
+				return 0, os.ErrDeadlineExceeded
+			}
+			return 0, err
+		}
+		q.remote = remote
+		q.rbuf = make([]byte, 2+len(resp))
+		binary.BigEndian.PutUint16(q.rbuf, uint16(len(resp)))
+		copy(q.rbuf[2:], resp)
+	}
+	n := copy(b, q.rbuf)
+	q.rbuf = q.rbuf[n:]
+	q.mu.Unlock()
+	return n, nil
+}
+
+func (q *queryConn) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	if q.cancel != nil {
+		q.cancel()
+	}
+	return nil
+}
+
+// SetDeadline sets deadline for future reads. If a read is in progress and
+// the new deadline has already passed, it is aborted; later deadlines only
+// apply to the next read.
+func (q *queryConn) SetDeadline(t time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.deadline = t
+	if q.cancel != nil && !t.IsZero() && !time.Now().Before(t) {
+		q.cancel()
+	}
+	return nil
+}
+
+func (q *queryConn) SetReadDeadline(t time.Time) error { return q.SetDeadline(t) }
+func (q *queryConn) SetWriteDeadline(time.Time) error  { return nil }
+
+func (q *queryConn) LocalAddr() net.Addr { return clientAddr{} }
+
+func (q *queryConn) RemoteAddr() net.Addr {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.remote != nil {
+		return q.remote
+	}
+	return clientAddr{}
+}
+
+// clientAddr is the address of queryConn, which is not bound to any
+// particular connection.
+type clientAddr struct{}
+
+func (clientAddr) Network() string { return "tcp" }
+func (clientAddr) String() string  { return "dot" }
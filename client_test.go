@@ -0,0 +1,228 @@
+package dot_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/artyom/dot"
+	"github.com/artyom/dot/dottest"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// newTestQuery returns A query for name with given ID.
+func newTestQuery(t *testing.T, name string, id uint16) []byte {
+	t.Helper()
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  dnsmessage.MustNewName(name),
+			Type:  dnsmessage.TypeA,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	b, err := msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// answerA returns the first A record of response, failing test if there is
+// none.
+func answerA(t *testing.T, resp []byte) [4]byte {
+	t.Helper()
+	var msg dnsmessage.Message
+	if err := msg.Unpack(resp); err != nil {
+		t.Fatal(err)
+	}
+	for _, rr := range msg.Answers {
+		if a, ok := rr.Body.(*dnsmessage.AResource); ok {
+			return a.A
+		}
+	}
+	t.Fatalf("no A records in response: %v", msg.Answers)
+	return [4]byte{}
+}
+
+func newTestClient(srv *dottest.Server, opts ...dot.Option) *dot.Client {
+	opts = append([]dot.Option{dot.WithRootCAs(srv.RootCAs())}, opts...)
+	return dot.NewClient(dottest.ServerName, []string{srv.Addr}, opts...)
+}
+
+func TestClientPipelining(t *testing.T) {
+	srv := dottest.NewUnstartedServer(dottest.Zone{"example.com": {"192.0.2.1"}})
+	srv.Delay = 50 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+	c := newTestClient(srv)
+	defer c.Close()
+
+	const n = 50
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(id uint16) {
+			defer wg.Done()
+			resp, err := c.Exchange(ctx, newTestQuery(t, "example.com.", id))
+			if err != nil {
+				errs <- err
+				return
+			}
+			if got := binary.BigEndian.Uint16(resp); got != id {
+				errs <- errors.New("response ID does not match query ID")
+			}
+		}(uint16(1000 + i))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	st := c.Stats()
+	if st.Dials != 1 {
+		t.Errorf("%d connections dialed, want queries pipelined over one", st.Dials)
+	}
+	if st.Queries != n || st.Failures != 0 {
+		t.Errorf("stats: %d queries, %d failures; want %d and 0", st.Queries, st.Failures, n)
+	}
+
+	// connection stays pooled for later queries
+	resp, err := c.Exchange(ctx, newTestQuery(t, "example.com.", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := answerA(t, resp); a != [4]byte{192, 0, 2, 1} {
+		t.Fatalf("got %v, want 192.0.2.1", a)
+	}
+	if st := c.Stats(); st.Dials != 1 || st.Conns != 1 {
+		t.Errorf("after reuse: %d dials, %d conns; want 1 and 1", st.Dials, st.Conns)
+	}
+}
+
+func TestClientShutdown(t *testing.T) {
+	srv := dottest.NewUnstartedServer(dottest.Zone{"example.com": {"192.0.2.1"}})
+	srv.Delay = 200 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+	c := newTestClient(srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c.Exchange(ctx, newTestQuery(t, "example.com.", 1))
+		errc <- err
+	}()
+	for c.Stats().Inflight == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := c.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("query in flight during shutdown failed: %v", err)
+	}
+	if _, err := c.Exchange(ctx, newTestQuery(t, "example.com.", 2)); !errors.Is(err, dot.ErrClientClosed) {
+		t.Fatalf("query after shutdown: got %v, want ErrClientClosed", err)
+	}
+}
+
+func TestClientShutdownTimeout(t *testing.T) {
+	srv := dottest.NewUnstartedServer(dottest.Zone{"example.com": {"192.0.2.1"}})
+	srv.Fault = func([]byte) dottest.Fault { return dottest.Drop }
+	srv.Start()
+	defer srv.Close()
+	c := newTestClient(srv)
+
+	errc := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err := c.Exchange(ctx, newTestQuery(t, "example.com.", 1))
+		errc <- err
+	}()
+	for c.Stats().Inflight == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := c.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown: got %v, want deadline exceeded", err)
+	}
+	if err := <-errc; err == nil {
+		t.Fatal("query in flight succeeded after forced shutdown")
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		fault   func(n int64) dottest.Fault // n counts queries server got, from 1
+		close   bool                        // close pooled connection between queries
+		wantErr bool
+		dials   uint64
+	}{
+		{name: "closed by server", close: true, dials: 2},
+		{name: "reset mid-query", fault: func(n int64) dottest.Fault {
+			if n == 2 {
+				return dottest.Reset
+			}
+			return dottest.NoFault
+		}, dials: 2},
+		{name: "fresh connection fails too", fault: func(n int64) dottest.Fault {
+			if n > 1 {
+				return dottest.Reset
+			}
+			return dottest.NoFault
+		}, wantErr: true, dials: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var queries atomic.Int64
+			srv := dottest.NewUnstartedServer(dottest.Zone{"example.com": {"192.0.2.1"}})
+			srv.Fault = func([]byte) dottest.Fault {
+				n := queries.Add(1)
+				if tc.fault == nil {
+					return dottest.NoFault
+				}
+				return tc.fault(n)
+			}
+			srv.Start()
+			defer srv.Close()
+			c := newTestClient(srv)
+			defer c.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if _, err := c.Exchange(ctx, newTestQuery(t, "example.com.", 1)); err != nil {
+				t.Fatal(err)
+			}
+			if tc.close {
+				srv.CloseClientConnections()
+			}
+			resp, err := c.Exchange(ctx, newTestQuery(t, "example.com.", 2))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("query succeeded, want error")
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("query was not retried: %v", err)
+				}
+				if a := answerA(t, resp); a != [4]byte{192, 0, 2, 1} {
+					t.Fatalf("got %v, want 192.0.2.1", a)
+				}
+			}
+			if st := c.Stats(); st.Dials != tc.dials {
+				t.Errorf("%d connections dialed, want %d", st.Dials, tc.dials)
+			}
+		})
+	}
+}
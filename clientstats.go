@@ -0,0 +1,135 @@
+package dot
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ClientStats describes Client connection pool and queries it sent since it
+// was created.
+type ClientStats struct {
+	Conns    int // open pooled connections
+	Inflight int // queries in flight
+
+	Queries  uint64 // queries completed, successfully or not
+	Failures uint64 // queries that got no response, including timeouts
+	Timeouts uint64 // queries that got no response in time
+	Dials    uint64 // connections established
+
+	BytesSent     uint64 // size of queries that got response
+	BytesReceived uint64 // size of responses
+
+	// RCodes counts responses by their response code, so that, e.g.,
+	// SERVFAIL responses can be told apart from successful ones.
+	RCodes map[dnsmessage.RCode]uint64
+
+	// Upstreams lists statistics of upstream addresses Client has
+	// connected to, ordered by address.
+	Upstreams []UpstreamStats
+}
+
+// ReuseRatio returns fraction of queries that did not need a new connection,
+// from 0 to 1.
+func (s ClientStats) ReuseRatio() float64 {
+	if s.Queries == 0 || s.Dials >= s.Queries {
+		return 0
+	}
+	return 1 - float64(s.Dials)/float64(s.Queries)
+}
+
+// UpstreamStats describes queries Client sent to a single upstream address.
+// A query retried over a new connection is counted for every attempt.
+type UpstreamStats struct {
+	Addr     string // upstream address in the host:port form
+	Conns    int    // open pooled connections
+	Queries  uint64 // queries sent
+	Failures uint64 // queries that got no response
+}
+
+// Stats returns current state of c connection pool and its query counters.
+func (c *Client) Stats() ClientStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := ClientStats{
+		Conns:         len(c.conns),
+		Inflight:      c.active,
+		Queries:       c.stats.queries,
+		Failures:      c.stats.failures,
+		Timeouts:      c.stats.timeouts,
+		Dials:         c.stats.dials,
+		BytesSent:     c.stats.sent,
+		BytesReceived: c.stats.received,
+		RCodes:        make(map[dnsmessage.RCode]uint64, len(c.stats.rcodes)),
+	}
+	for code, n := range c.stats.rcodes {
+		st.RCodes[code] = n
+	}
+	conns := make(map[string]int)
+	for cc := range c.conns {
+		conns[cc.conn.RemoteAddr().String()]++
+	}
+	for addr, u := range c.stats.upstreams {
+		st.Upstreams = append(st.Upstreams, UpstreamStats{
+			Addr:     addr,
+			Conns:    conns[addr],
+			Queries:  u.queries,
+			Failures: u.failures,
+		})
+	}
+	sort.Slice(st.Upstreams, func(i, j int) bool { return st.Upstreams[i].Addr < st.Upstreams[j].Addr })
+	return st
+}
+
+// clientCounters are Client query counters, guarded by Client.mu.
+type clientCounters struct {
+	queries, failures, timeouts, dials uint64
+	sent, received                     uint64
+	rcodes                             map[dnsmessage.RCode]uint64
+	upstreams                          map[string]*upstreamCounters
+}
+
+type upstreamCounters struct {
+	queries, failures uint64
+}
+
+// countQuery records outcome of a single Exchange call.
+func (s *clientCounters) countQuery(query, resp []byte, err error) {
+	s.queries++
+	if err != nil {
+		s.failures++
+		var ne net.Error
+		if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &ne) && ne.Timeout() {
+			s.timeouts++
+		}
+		return
+	}
+	s.sent += uint64(len(query))
+	s.received += uint64(len(resp))
+	if s.rcodes == nil {
+		s.rcodes = make(map[dnsmessage.RCode]uint64)
+	}
+	s.rcodes[dnsmessage.RCode(resp[3]&0x0f)]++
+}
+
+// countUpstream records outcome of a single attempt to send query over cc.
+func (c *Client) countUpstream(cc *clientConn, err error) {
+	addr := cc.conn.RemoteAddr().String()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stats.upstreams == nil {
+		c.stats.upstreams = make(map[string]*upstreamCounters)
+	}
+	u := c.stats.upstreams[addr]
+	if u == nil {
+		u = new(upstreamCounters)
+		c.stats.upstreams[addr] = u
+	}
+	u.queries++
+	if err != nil {
+		u.failures++
+	}
+}
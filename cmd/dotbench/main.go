@@ -0,0 +1,97 @@
+// Command dotbench measures built-in DNS-over-TLS providers from the current
+// vantage point and prints them ranked by failure rate and median latency.
+//
+// For every provider it repeatedly establishes a fresh connection, measuring
+// how long TCP and TLS handshakes take, then sends a single query over it,
+// measuring how long it takes to get the answer.
+//
+// Usage:
+//
+//	dotbench [flags] [name...]
+//
+// If no names are given, a few popular domains are queried.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/artyom/dot"
+)
+
+func main() {
+	log.SetFlags(0)
+	args := runArgs{
+		rounds: 10,
+	}
+	flag.IntVar(&args.rounds, "n", args.rounds, "number of rounds; every round queries each name once")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [name...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	args.names = flag.Args()
+	if len(args.names) == 0 {
+		args.names = []string{"example.com", "google.com", "wikipedia.org", "github.com", "cloudflare.com"}
+	}
+	if err := run(context.Background(), args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+type runArgs struct {
+	names  []string
+	rounds int
+}
+
+func run(ctx context.Context, args runArgs) error {
+	if args.rounds < 1 {
+		return errors.New("number of rounds must be positive")
+	}
+	var names []string
+	for i := 0; i < args.rounds; i++ {
+		names = append(names, args.names...)
+	}
+	resolvers := make(map[string]*net.Resolver)
+	for _, p := range dot.Providers() {
+		resolvers[p.Name] = p.Resolver()
+	}
+	results, err := dot.Measure(ctx, resolvers, names)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "#\tprovider\thandshake p50\tquery p50\tquery p90\tquery max\ttotal p50\tfailures\t")
+	for i, m := range results {
+		fmt.Fprintf(tw, "%d\t%s\t%v\t%v\t%v\t%v\t%v\t%.0f%%\t\n", i+1, m.Name,
+			ms(m.Handshake.P50),
+			ms(m.Query.P50),
+			ms(m.Query.P90),
+			ms(m.Query.Max),
+			ms(m.Total.P50),
+			100*m.FailureRate())
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, m := range results {
+		if m.Err != nil {
+			fmt.Fprintf(os.Stderr, "%s: last error: %v\n", m.Name, m.Err)
+		}
+	}
+	return nil
+}
+
+func ms(d time.Duration) string {
+	if d == 0 {
+		return "-"
+	}
+	return d.Round(100 * time.Microsecond).String()
+}
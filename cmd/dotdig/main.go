@@ -0,0 +1,383 @@
+// Command dotdig queries DNS-over-TLS resolvers and prints the parsed answer
+// along with timing and TLS connection details, similar to dig.
+//
+// Usage:
+//
+//	dotdig [flags] name [type]
+//
+// By default it queries Cloudflare; use -provider to pick another built-in
+// provider, or -server with -tls-name to query a custom endpoint.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/artyom/dot"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func main() {
+	log.SetFlags(0)
+	args := runArgs{
+		provider: "cloudflare",
+		timeout:  5 * time.Second,
+	}
+	flag.StringVar(&args.provider, "provider", args.provider, "built-in provider: "+strings.Join(providerNames(), ", "))
+	flag.StringVar(&args.server, "server", args.server, "custom server `host:port`, overrides -provider")
+	flag.StringVar(&args.tlsName, "tls-name", args.tlsName, "TLS server name of custom -server, defaults to its host")
+	flag.DurationVar(&args.timeout, "timeout", args.timeout, "query timeout")
+	flag.BoolVar(&args.noRecurse, "norecurse", args.noRecurse, "don't set the RD (recursion desired) bit")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] name [type]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	switch flag.NArg() {
+	case 1:
+		args.name, args.qtype = flag.Arg(0), "A"
+	case 2:
+		args.name, args.qtype = flag.Arg(0), flag.Arg(1)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(context.Background(), args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+type runArgs struct {
+	provider  string
+	server    string
+	tlsName   string
+	name      string
+	qtype     string
+	timeout   time.Duration
+	noRecurse bool
+}
+
+func run(ctx context.Context, args runArgs) error {
+	r, err := args.resolver()
+	if err != nil {
+		return err
+	}
+	qtype, err := parseType(args.qtype)
+	if err != nil {
+		return err
+	}
+	name, err := dnsmessage.NewName(fqdn(args.name))
+	if err != nil {
+		return fmt.Errorf("invalid name %q: %w", args.name, err)
+	}
+	query, err := newQuery(name, qtype, !args.noRecurse)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, args.timeout)
+	defer cancel()
+
+	begin := time.Now()
+	conn, err := r.Dial(ctx, "tcp", "")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if d, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(d)
+	}
+	tconn, ok := conn.(*tls.Conn)
+	if !ok {
+		return errors.New("resolver connection is not a TLS one")
+	}
+	if err := tconn.HandshakeContext(ctx); err != nil {
+		return err
+	}
+	handshake := time.Since(begin)
+	begin = time.Now()
+	resp, err := roundTrip(tconn, query)
+	if err != nil {
+		return err
+	}
+	queryTime := time.Since(begin)
+
+	var msg dnsmessage.Message
+	if err := msg.Unpack(resp); err != nil {
+		return fmt.Errorf("parsing response: %w", err)
+	}
+	printMessage(os.Stdout, &msg)
+	fmt.Printf("\n;; Query time: %v (handshake %v)\n", queryTime.Round(time.Millisecond), handshake.Round(time.Millisecond))
+	fmt.Printf(";; SERVER: %v\n", conn.RemoteAddr())
+	fmt.Printf(";; MSG SIZE rcvd: %d\n", len(resp))
+	printTLS(os.Stdout, tconn.ConnectionState())
+	return nil
+}
+
+func (args runArgs) resolver() (*net.Resolver, error) {
+	if args.server != "" {
+		host, _, err := net.SplitHostPort(args.server)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -server value: %w", err)
+		}
+		name := args.tlsName
+		if name == "" {
+			name = host
+		}
+		return dot.NewChecked(name, []string{args.server})
+	}
+	p, ok := dot.LookupProvider(args.provider)
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q, known are: %s", args.provider, strings.Join(providerNames(), ", "))
+	}
+	return p.Resolver(), nil
+}
+
+func providerNames() []string {
+	var names []string
+	for _, p := range dot.Providers() {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+func newQuery(name dnsmessage.Name, qtype dnsmessage.Type, recurse bool) ([]byte, error) {
+	var id [2]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:               binary.BigEndian.Uint16(id[:]),
+		RecursionDesired: recurse,
+	})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: name, Type: qtype, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	if err := b.StartAdditionals(); err != nil {
+		return nil, err
+	}
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(4096, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, err
+	}
+	if err := b.OPTResource(opt, dnsmessage.OPTResource{}); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+func roundTrip(conn net.Conn, query []byte) ([]byte, error) {
+	buf := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(buf, uint16(len(query)))
+	copy(buf[2:], query)
+	if _, err := conn.Write(buf); err != nil {
+		return nil, err
+	}
+	var hdr [2]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(hdr[:]))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+	if len(resp) < 2 || binary.BigEndian.Uint16(resp) != binary.BigEndian.Uint16(query) {
+		return nil, errors.New("response id does not match query id")
+	}
+	return resp, nil
+}
+
+func printMessage(w io.Writer, msg *dnsmessage.Message) {
+	h := msg.Header
+	fmt.Fprintf(w, ";; ->>HEADER<<- opcode: %d, status: %s, id: %d\n", h.OpCode, rcodeString(h.RCode), h.ID)
+	var flags []string
+	for _, f := range []struct {
+		set  bool
+		name string
+	}{
+		{h.Response, "qr"}, {h.Authoritative, "aa"}, {h.Truncated, "tc"},
+		{h.RecursionDesired, "rd"}, {h.RecursionAvailable, "ra"},
+		{h.AuthenticData, "ad"}, {h.CheckingDisabled, "cd"},
+	} {
+		if f.set {
+			flags = append(flags, f.name)
+		}
+	}
+	fmt.Fprintf(w, ";; flags: %s; QUERY: %d, ANSWER: %d, AUTHORITY: %d, ADDITIONAL: %d\n",
+		strings.Join(flags, " "), len(msg.Questions), len(msg.Answers), len(msg.Authorities), len(msg.Additionals))
+
+	fmt.Fprintln(w, "\n;; QUESTION SECTION:")
+	for _, q := range msg.Questions {
+		fmt.Fprintf(w, ";%s\t\t%s\t%s\n", q.Name, classString(q.Class), typeString(q.Type))
+	}
+	for _, sec := range []struct {
+		name string
+		rrs  []dnsmessage.Resource
+	}{
+		{"ANSWER", msg.Answers},
+		{"AUTHORITY", msg.Authorities},
+		{"ADDITIONAL", msg.Additionals},
+	} {
+		var printed bool
+		for _, rr := range sec.rrs {
+			if rr.Header.Type == dnsmessage.TypeOPT {
+				continue
+			}
+			if !printed {
+				fmt.Fprintf(w, "\n;; %s SECTION:\n", sec.name)
+				printed = true
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", rr.Header.Name, rr.Header.TTL,
+				classString(rr.Header.Class), typeString(rr.Header.Type), rdataString(rr.Body))
+		}
+	}
+}
+
+func printTLS(w io.Writer, st tls.ConnectionState) {
+	fmt.Fprintf(w, ";; TLS: %s, %s, resumed: %t", tls.VersionName(st.Version),
+		tls.CipherSuiteName(st.CipherSuite), st.DidResume)
+	if st.NegotiatedProtocol != "" {
+		fmt.Fprintf(w, ", ALPN: %s", st.NegotiatedProtocol)
+	}
+	fmt.Fprintln(w)
+	for i, cert := range st.PeerCertificates {
+		fmt.Fprintf(w, ";; CERT %d: %s\n", i, certString(cert))
+	}
+}
+
+func certString(cert *x509.Certificate) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "subject: %s; issuer: %s; valid %s to %s", cert.Subject, cert.Issuer,
+		cert.NotBefore.UTC().Format(time.DateOnly), cert.NotAfter.UTC().Format(time.DateOnly))
+	if left := time.Until(cert.NotAfter); left > 0 {
+		fmt.Fprintf(&b, " (%d days left)", int(left.Hours()/24))
+	} else {
+		b.WriteString(" (EXPIRED)")
+	}
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	if len(sans) != 0 {
+		fmt.Fprintf(&b, "; SANs: %s", strings.Join(sans, ", "))
+	}
+	return b.String()
+}
+
+func rdataString(body dnsmessage.ResourceBody) string {
+	switch b := body.(type) {
+	case *dnsmessage.AResource:
+		return net.IP(b.A[:]).String()
+	case *dnsmessage.AAAAResource:
+		return net.IP(b.AAAA[:]).String()
+	case *dnsmessage.CNAMEResource:
+		return b.CNAME.String()
+	case *dnsmessage.NSResource:
+		return b.NS.String()
+	case *dnsmessage.PTRResource:
+		return b.PTR.String()
+	case *dnsmessage.MXResource:
+		return fmt.Sprintf("%d %s", b.Pref, b.MX)
+	case *dnsmessage.SRVResource:
+		return fmt.Sprintf("%d %d %d %s", b.Priority, b.Weight, b.Port, b.Target)
+	case *dnsmessage.SOAResource:
+		return fmt.Sprintf("%s %s %d %d %d %d %d", b.NS, b.MBox, b.Serial, b.Refresh, b.Retry, b.Expire, b.MinTTL)
+	case *dnsmessage.TXTResource:
+		quoted := make([]string, len(b.TXT))
+		for i, s := range b.TXT {
+			quoted[i] = fmt.Sprintf("%q", s)
+		}
+		return strings.Join(quoted, " ")
+	case *dnsmessage.UnknownResource:
+		return fmt.Sprintf(`\# %d %s`, len(b.Data), hex.EncodeToString(b.Data))
+	}
+	return fmt.Sprintf("%v", body)
+}
+
+var types = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"NS":    dnsmessage.TypeNS,
+	"CNAME": dnsmessage.TypeCNAME,
+	"SOA":   dnsmessage.TypeSOA,
+	"PTR":   dnsmessage.TypePTR,
+	"MX":    dnsmessage.TypeMX,
+	"TXT":   dnsmessage.TypeTXT,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"SRV":   dnsmessage.TypeSRV,
+	"HTTPS": 65,
+	"SVCB":  64,
+	"CAA":   257,
+	"ANY":   dnsmessage.TypeALL,
+}
+
+// parseType parses query type given either as a mnemonic (e.g. "AAAA") or
+// in the generic TYPEnnn form from RFC 3597.
+func parseType(s string) (dnsmessage.Type, error) {
+	s = strings.ToUpper(s)
+	if t, ok := types[s]; ok {
+		return t, nil
+	}
+	var n uint16
+	if _, err := fmt.Sscanf(s, "TYPE%d", &n); err == nil {
+		return dnsmessage.Type(n), nil
+	}
+	return 0, fmt.Errorf("unsupported query type %q", s)
+}
+
+func typeString(t dnsmessage.Type) string {
+	for name, v := range types {
+		if v == t {
+			return name
+		}
+	}
+	return fmt.Sprintf("TYPE%d", t)
+}
+
+func classString(c dnsmessage.Class) string {
+	if c == dnsmessage.ClassINET {
+		return "IN"
+	}
+	return fmt.Sprintf("CLASS%d", c)
+}
+
+func rcodeString(rc dnsmessage.RCode) string {
+	switch rc {
+	case dnsmessage.RCodeSuccess:
+		return "NOERROR"
+	case dnsmessage.RCodeFormatError:
+		return "FORMERR"
+	case dnsmessage.RCodeServerFailure:
+		return "SERVFAIL"
+	case dnsmessage.RCodeNameError:
+		return "NXDOMAIN"
+	case dnsmessage.RCodeNotImplemented:
+		return "NOTIMP"
+	case dnsmessage.RCodeRefused:
+		return "REFUSED"
+	}
+	return fmt.Sprintf("RCODE%d", rc)
+}
+
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
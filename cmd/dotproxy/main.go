@@ -0,0 +1,412 @@
+// Command dotproxy is a local DNS forwarder: it accepts plain DNS queries
+// over UDP and TCP and forwards them upstream over DNS-over-TLS, optionally
+// caching responses.
+//
+// Usage:
+//
+//	dotproxy [flags]
+//
+// By default it listens on 127.0.0.1:53 and forwards to Cloudflare; use
+// -provider to pick another built-in provider, or -server with -tls-name to
+// use a custom endpoint; -fallback names a provider to retry queries with
+// when upstream answers with SERVFAIL or REFUSED; -shard lists more providers
+// to spread queries among by domain, so that none of them sees all lookups.
+// It shuts down gracefully on SIGINT or SIGTERM; -hosts file is reloaded
+// automatically once it changes. On Unix systems, it re-reads -blocklist,
+// -allowlist and -rpz zones on SIGHUP, with -top it logs the most queried
+// names of the last hour on SIGUSR1, and on SIGUSR2 it flushes the cache, e.g.
+// to get rid of stale answers after upstream change. With -dnstap, it logs
+// queries it receives and forwards upstream in dnstap format.
+//
+// Queries for special-use names, such as localhost, .onion or .home.arpa,
+// are answered locally and never forwarded upstream; private ones are
+// forwarded to -local-dns server instead, if given.
+//
+// When started by systemd socket activation, it serves sockets systemd
+// passes instead of listening on -addr, so it does not need to run as root
+// to use port 53.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/artyom/dot"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func main() {
+	log.SetFlags(0)
+	args := runArgs{
+		addr:       "127.0.0.1:53",
+		provider:   "cloudflare",
+		cacheSize:  1000,
+		maxQueries: 100,
+		timeout:    5 * time.Second,
+	}
+	flag.StringVar(&args.addr, "addr", args.addr, "UDP and TCP `address` to listen on")
+	flag.StringVar(&args.provider, "provider", args.provider, "built-in upstream provider: "+strings.Join(providerNames(), ", "))
+	flag.StringVar(&args.server, "server", args.server, "custom upstream `host:port`, overrides -provider")
+	flag.StringVar(&args.tlsName, "tls-name", args.tlsName, "TLS server name of custom -server, defaults to its host")
+	flag.StringVar(&args.shard, "shard", args.shard, "comma-separated built-in `providers` to spread queries among together with upstream, by domain")
+	flag.StringVar(&args.fallback, "fallback", args.fallback, "built-in `provider` to retry queries with on upstream SERVFAIL or REFUSED")
+	flag.StringVar(&args.localDNS, "local-dns", args.localDNS, "plain DNS server `host:port` to resolve private names like .home.arpa with, instead of answering NXDOMAIN")
+	flag.StringVar(&args.hosts, "hosts", args.hosts, "answer names listed in hosts `file` locally, reloading it on change")
+	flag.StringVar(&args.blocklist, "blocklist", args.blocklist, "answer names listed in blocklist `file` locally, never forwarding them")
+	flag.StringVar(&args.allowlist, "allowlist", args.allowlist, "never block names listed in allowlist `file`")
+	flag.StringVar(&args.rpz, "rpz", args.rpz, "comma-separated response policy zone `files` or http(s) URLs to apply, in order of precedence")
+	flag.BoolVar(&args.blockNull, "block-null", args.blockNull, "answer blocked names with 0.0.0.0 or :: instead of NXDOMAIN")
+	flag.IntVar(&args.cacheSize, "cache", args.cacheSize, "number of responses to cache, 0 disables caching")
+	flag.DurationVar(&args.minTTL, "min-ttl", args.minTTL, "cache records for at least this long, regardless of their TTL")
+	flag.DurationVar(&args.maxTTL, "max-ttl", args.maxTTL, "cache records for at most this long, regardless of their TTL")
+	flag.DurationVar(&args.serveStale, "serve-stale", args.serveStale, "answer with cached responses expired no longer than this ago if upstream fails")
+	flag.IntVar(&args.maxQueries, "max-queries", args.maxQueries, "maximum number of queries forwarded concurrently")
+	flag.DurationVar(&args.timeout, "timeout", args.timeout, "upstream query timeout")
+	flag.BoolVar(&args.logErrors, "v", args.logErrors, "log upstream and connection errors")
+	flag.BoolVar(&args.logQueries, "log-queries", args.logQueries, "log every query")
+	flag.StringVar(&args.dnstap, "dnstap", args.dnstap, "write dnstap messages to `file`, or to collector socket given as unix:path or tcp:host:port")
+	flag.IntVar(&args.top, "top", args.top, "on SIGUSR1, log `N` most queried names of the last hour")
+	flag.Parse()
+	if err := run(args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+type runArgs struct {
+	addr       string
+	provider   string
+	server     string
+	tlsName    string
+	fallback   string
+	shard      string
+	localDNS   string
+	hosts      string
+	blocklist  string
+	allowlist  string
+	rpz        string
+	blockNull  bool
+	cacheSize  int
+	minTTL     time.Duration
+	maxTTL     time.Duration
+	serveStale time.Duration
+	maxQueries int
+	timeout    time.Duration
+	logErrors  bool
+	logQueries bool
+	top        int
+	dnstap     string
+}
+
+func run(args runArgs) error {
+	client, err := args.client()
+	if err != nil {
+		return err
+	}
+	clients := []*dot.Client{client}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), args.timeout)
+		defer cancel()
+		for _, c := range clients {
+			c.Shutdown(ctx)
+		}
+	}()
+	r := client.Resolver()
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	tap, err := args.openDnstap()
+	if err != nil {
+		return err
+	}
+	if tap != nil {
+		defer func() {
+			if err := tap.Close(); err != nil {
+				logger.Printf("dnstap: %v", err)
+			}
+		}()
+	}
+	var upstream dot.Handler = client
+	if args.shard != "" {
+		hs := []dot.Handler{upstream}
+		for _, name := range strings.Split(args.shard, ",") {
+			p, ok := dot.LookupProvider(strings.TrimSpace(name))
+			if !ok {
+				return fmt.Errorf("unknown -shard provider %q, known are: %s", name, strings.Join(providerNames(), ", "))
+			}
+			c := p.Client()
+			clients = append(clients, c)
+			hs = append(hs, c)
+		}
+		upstream = dot.Shard(hs...)
+	}
+	if args.fallback != "" {
+		p, ok := dot.LookupProvider(args.fallback)
+		if !ok {
+			return fmt.Errorf("unknown -fallback provider %q, known are: %s", args.fallback, strings.Join(providerNames(), ", "))
+		}
+		c := p.Client()
+		clients = append(clients, c)
+		upstream = dot.Fallback(upstream, c)
+	}
+	if tap != nil {
+		upstream = tap.Handler(dot.DnstapForwarder, upstream)
+	}
+	// special-use names never reach public upstream
+	var local dot.Handler
+	if args.localDNS != "" {
+		if _, _, err := net.SplitHostPort(args.localDNS); err != nil {
+			return fmt.Errorf("invalid -local-dns value: %w", err)
+		}
+		var d net.Dialer
+		local = dot.Forward(&net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return d.DialContext(ctx, "tcp", args.localDNS)
+			},
+		})
+	}
+	upstream = dot.SpecialUse(upstream, local)
+	var cache *dot.Cache
+	if args.cacheSize > 0 {
+		cache = dot.NewCache(upstream, args.cacheSize)
+		cache.MinTTL, cache.MaxTTL = args.minTTL, args.maxTTL
+		cache.ServeStale = args.serveStale
+		upstream = cache
+	}
+	// block and allow lists are re-read on SIGHUP, cache survives reloads
+	lists, err := dot.NewReloadable(func() (dot.Handler, error) {
+		if args.blocklist == "" && args.rpz == "" {
+			return upstream, nil
+		}
+		return newFilter(upstream, r, args)
+	})
+	if err != nil {
+		return err
+	}
+	var h dot.Handler = lists
+	if args.hosts != "" {
+		h = dot.NewHosts(h, args.hosts)
+	}
+	if args.logQueries {
+		h = logQueries(h, logger)
+	}
+	if tap != nil {
+		h = tap.Handler(dot.DnstapClient, h)
+	}
+	var counter *dot.QueryCounter
+	if args.top > 0 {
+		counter = dot.NewQueryCounter(time.Hour)
+		h = counter.Handler(h)
+	}
+	p := &dot.Proxy{
+		Addr:       args.addr,
+		Handler:    h,
+		MaxQueries: args.maxQueries,
+		Timeout:    args.timeout,
+	}
+	if args.logErrors {
+		p.ErrorLog = logger
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	hup := make(chan os.Signal, 1)
+	notifyReload(hup)
+	defer signal.Stop(hup)
+	usr1 := make(chan os.Signal, 1)
+	if counter != nil {
+		notifyTop(usr1)
+		defer signal.Stop(usr1)
+	}
+	usr2 := make(chan os.Signal, 1)
+	if cache != nil {
+		notifyFlush(usr2)
+		defer signal.Stop(usr2)
+	}
+	pc, l, err := dot.ActivationListeners()
+	if err != nil {
+		return err
+	}
+	serve := p.ListenAndServe
+	if pc != nil || l != nil {
+		serve = func() error { return p.Serve(pc, l) }
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- serve() }()
+wait:
+	for {
+		select {
+		case err := <-errc:
+			return err
+		case <-hup:
+			if err := lists.Reload(); err != nil {
+				logger.Printf("reloading lists: %v", err)
+			}
+		case <-usr1:
+			for _, v := range counter.Top(args.top, 0) {
+				logger.Printf("top: %6d %s %s", v.Count, strings.TrimPrefix(v.Type.String(), "Type"), v.Name)
+			}
+		case <-usr2:
+			logger.Printf("cache flushed, %d responses removed", cache.FlushAll())
+		case <-ctx.Done():
+			break wait
+		}
+	}
+	stop()
+	ctx, cancel := context.WithTimeout(context.Background(), args.timeout)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != nil {
+		return err
+	}
+	if err := <-errc; !errors.Is(err, dot.ErrProxyClosed) {
+		return err
+	}
+	return nil
+}
+
+// openDnstap returns Dnstap for -dnstap destination, or nil if it is not
+// set.
+func (args runArgs) openDnstap() (*dot.Dnstap, error) {
+	if args.dnstap == "" {
+		return nil, nil
+	}
+	for _, network := range []string{"unix", "tcp"} {
+		if addr, ok := strings.CutPrefix(args.dnstap, network+":"); ok {
+			return dot.DialDnstap(network, addr)
+		}
+	}
+	f, err := os.Create(args.dnstap)
+	if err != nil {
+		return nil, err
+	}
+	tap, err := dot.NewDnstap(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return tap, nil
+}
+
+func (args runArgs) client() (*dot.Client, error) {
+	if args.server != "" {
+		host, _, err := net.SplitHostPort(args.server)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -server value: %w", err)
+		}
+		name := args.tlsName
+		if name == "" {
+			name = host
+		}
+		return dot.NewClientChecked(name, []string{args.server})
+	}
+	p, ok := dot.LookupProvider(args.provider)
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q, known are: %s", args.provider, strings.Join(providerNames(), ", "))
+	}
+	return p.Client(), nil
+}
+
+func newFilter(h dot.Handler, r *net.Resolver, args runArgs) (*dot.Filter, error) {
+	mode := dot.BlockNXDomain
+	if args.blockNull {
+		mode = dot.BlockNullIP
+	}
+	f := dot.NewFilter(h, mode)
+	load := func(name string, fn func(io.Reader) error) error {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		return fn(file)
+	}
+	if args.blocklist != "" {
+		if err := load(args.blocklist, f.Block); err != nil {
+			return nil, err
+		}
+	}
+	if args.allowlist != "" {
+		if err := load(args.allowlist, f.Allow); err != nil {
+			return nil, err
+		}
+	}
+	if args.rpz != "" {
+		for _, name := range strings.Split(args.rpz, ",") {
+			if err := loadRPZ(f, r, strings.TrimSpace(name)); err != nil {
+				return nil, fmt.Errorf("loading %s: %w", name, err)
+			}
+		}
+	}
+	return f, nil
+}
+
+// loadRPZ loads response policy zone into f from local file or http(s)
+// URL. URL host is resolved with r, as system resolver may be this very
+// proxy.
+func loadRPZ(f *dot.Filter, r *net.Resolver, name string) error {
+	if !strings.HasPrefix(name, "http://") && !strings.HasPrefix(name, "https://") {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		return f.LoadRPZ(file)
+	}
+	d := &net.Dialer{Resolver: r}
+	client := &http.Client{
+		Transport: &http.Transport{DialContext: d.DialContext, Proxy: http.ProxyFromEnvironment},
+		Timeout:   time.Minute,
+	}
+	resp, err := client.Get(name)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+	return f.LoadRPZ(resp.Body)
+}
+
+func providerNames() []string {
+	var names []string
+	for _, p := range dot.Providers() {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+// logQueries wraps h so that every query is logged along with its outcome
+// and duration.
+func logQueries(h dot.Handler, logger *log.Logger) dot.Handler {
+	return dot.HandlerFunc(func(ctx context.Context, query []byte) ([]byte, error) {
+		begin := time.Now()
+		resp, err := h.ServeDNS(ctx, query)
+		var q string
+		var p dnsmessage.Parser
+		if _, perr := p.Start(query); perr == nil {
+			if qq, perr := p.Question(); perr == nil {
+				q = qq.Name.String() + " " + strings.TrimPrefix(qq.Type.String(), "Type")
+			}
+		}
+		switch {
+		case err != nil:
+			logger.Printf("%s: %v (%v)", q, err, time.Since(begin).Round(time.Millisecond))
+		case resp != nil:
+			var status string
+			if hdr, perr := p.Start(resp); perr == nil {
+				status = strings.TrimPrefix(hdr.RCode.String(), "RCode")
+			}
+			logger.Printf("%s: %s (%v)", q, status, time.Since(begin).Round(time.Millisecond))
+		}
+		return resp, err
+	})
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// notifyReload does nothing: there is no SIGHUP on this system, so lists
+// are only read on start.
+func notifyReload(chan<- os.Signal) {}
+
+// notifyTop does nothing: there is no SIGUSR1 on this system, so the most
+// queried names report cannot be requested.
+func notifyTop(chan<- os.Signal) {}
+
+// notifyFlush does nothing: there is no SIGUSR2 on this system, so cache
+// cannot be flushed on request.
+func notifyFlush(chan<- os.Signal) {}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyReload relays SIGHUP, which requests lists reload, to c.
+func notifyReload(c chan<- os.Signal) { signal.Notify(c, syscall.SIGHUP) }
+
+// notifyTop relays SIGUSR1, which requests the most queried names report,
+// to c.
+func notifyTop(c chan<- os.Signal) { signal.Notify(c, syscall.SIGUSR1) }
+
+// notifyFlush relays SIGUSR2, which requests cache flush, to c.
+func notifyFlush(c chan<- os.Signal) { signal.Notify(c, syscall.SIGUSR2) }
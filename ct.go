@@ -0,0 +1,242 @@
+package dot
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	cbasn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// CTLog is a Certificate Transparency log trusted by
+// WithCertificateTransparency.
+type CTLog struct {
+	Name string // used in error messages
+
+	// PublicKey is the DER-encoded SubjectPublicKeyInfo of the log key,
+	// as published in log lists, e.g. the "key" field of
+	// https://www.gstatic.com/ct/log_list/v3/log_list.json after base64
+	// decoding.
+	PublicKey []byte
+}
+
+// WithCertificateTransparency returns Option that makes TLS handshake fail
+// unless upstream certificate has valid signed certificate timestamps (SCTs)
+// from at least minSCTs distinct logs out of logs, defending against
+// mis-issued certificates that were not publicly logged. SCTs embedded into
+// certificate and sent in the TLS extension are both accepted; OCSP-stapled
+// ones are not. If minSCTs is not positive, 2 are required, as browsers do.
+//
+// Log lists change over time, keeping logs up to date is up to the caller.
+// Logs whose keys cannot be parsed are ignored.
+func WithCertificateTransparency(logs []CTLog, minSCTs int) Option {
+	if minSCTs <= 0 {
+		minSCTs = 2
+	}
+	known := make(map[[sha256.Size]byte]ctLog, len(logs))
+	for _, l := range logs {
+		key, err := x509.ParsePKIXPublicKey(l.PublicKey)
+		if err != nil {
+			continue
+		}
+		known[sha256.Sum256(l.PublicKey)] = ctLog{name: l.Name, key: key}
+	}
+	return func(c *config) {
+		c.verifiers = append(c.verifiers, func(cs tls.ConnectionState) error {
+			return verifySCTs(cs, known, minSCTs)
+		})
+	}
+}
+
+type ctLog struct {
+	name string
+	key  crypto.PublicKey
+}
+
+// oidSCTList is the OID of certificate extension carrying embedded SCTs, see
+// RFC 6962, section 3.3.
+var oidSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// verifySCTs checks that leaf certificate of connection has valid SCTs from
+// at least min distinct logs.
+func verifySCTs(cs tls.ConnectionState, logs map[[sha256.Size]byte]ctLog, min int) error {
+	if len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) < 2 {
+		return errors.New("dot: certificate transparency: no verified chain with issuer")
+	}
+	leaf, issuer := cs.VerifiedChains[0][0], cs.VerifiedChains[0][1]
+	seen := make(map[[sha256.Size]byte]string) // log IDs to names
+	check := func(sct []byte, entry []byte) {
+		if id, ok := verifySCT(sct, entry, logs); ok {
+			seen[id] = logs[id].name
+		}
+	}
+	// SCTs delivered in TLS extension are over the certificate itself
+	if len(cs.SignedCertificateTimestamps) != 0 {
+		entry := ctEntry(0, func(b *cryptobyte.Builder) {
+			b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(leaf.Raw) })
+		})
+		for _, sct := range cs.SignedCertificateTimestamps {
+			check(sct, entry)
+		}
+	}
+	// embedded SCTs are over the precertificate: TBS certificate without
+	// SCT list extension, bound to the issuer key
+	for _, ext := range leaf.Extensions {
+		if !ext.Id.Equal(oidSCTList) {
+			continue
+		}
+		var list []byte
+		if _, err := asn1.Unmarshal(ext.Value, &list); err != nil {
+			break
+		}
+		tbs, err := removeSCTList(leaf.RawTBSCertificate)
+		if err != nil {
+			break
+		}
+		keyHash := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+		entry := ctEntry(1, func(b *cryptobyte.Builder) {
+			b.AddBytes(keyHash[:])
+			b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(tbs) })
+		})
+		s := cryptobyte.String(list)
+		var scts cryptobyte.String
+		if !s.ReadUint16LengthPrefixed(&scts) {
+			break
+		}
+		for !scts.Empty() {
+			var sct cryptobyte.String
+			if !scts.ReadUint16LengthPrefixed(&sct) {
+				break
+			}
+			check(sct, entry)
+		}
+	}
+	if len(seen) >= min {
+		return nil
+	}
+	names := make([]string, 0, len(seen))
+	for _, name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("dot: certificate transparency: certificate has valid SCTs from %d trusted logs %q, %d required", len(seen), names, min)
+}
+
+// ctEntry returns log entry type and entry itself, the part of signed SCT
+// data following timestamp, without extensions.
+func ctEntry(typ uint16, add cryptobyte.BuilderContinuation) []byte {
+	var b cryptobyte.Builder
+	b.AddUint16(typ)
+	add(&b)
+	return b.BytesOrPanic()
+}
+
+// verifySCT verifies a single serialized SCT over log entry, returning ID
+// of the log that issued it.
+func verifySCT(sct, entry []byte, logs map[[sha256.Size]byte]ctLog) (id [sha256.Size]byte, ok bool) {
+	s := cryptobyte.String(sct)
+	var (
+		version      uint8
+		logID        []byte
+		timestamp    uint64
+		extensions   cryptobyte.String
+		hashAlg, alg uint8
+		sig          cryptobyte.String
+	)
+	if !s.ReadUint8(&version) || version != 0 ||
+		!s.ReadBytes(&logID, sha256.Size) ||
+		!s.ReadUint64(&timestamp) ||
+		!s.ReadUint16LengthPrefixed(&extensions) ||
+		!s.ReadUint8(&hashAlg) || !s.ReadUint8(&alg) ||
+		!s.ReadUint16LengthPrefixed(&sig) || !s.Empty() {
+		return id, false
+	}
+	copy(id[:], logID)
+	log, known := logs[id]
+	if !known || hashAlg != 4 { // SHA-256
+		return id, false
+	}
+	if time.UnixMilli(int64(timestamp)).After(time.Now()) {
+		return id, false
+	}
+	var b cryptobyte.Builder
+	b.AddUint8(0) // version
+	b.AddUint8(0) // signature type: certificate timestamp
+	b.AddUint64(timestamp)
+	b.AddBytes(entry)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(extensions) })
+	digest := sha256.Sum256(b.BytesOrPanic())
+	switch key := log.key.(type) {
+	case *ecdsa.PublicKey:
+		ok = alg == 3 && ecdsa.VerifyASN1(key, digest[:], sig)
+	case *rsa.PublicKey:
+		ok = alg == 1 && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+	}
+	return id, ok
+}
+
+// removeSCTList returns DER-encoded TBS certificate with SCT list extension
+// removed, as it was in the precertificate logs signed.
+func removeSCTList(tbs []byte) ([]byte, error) {
+	errMalformed := errors.New("malformed TBS certificate")
+	in := cryptobyte.String(tbs)
+	var fields cryptobyte.String
+	if !in.ReadASN1(&fields, cbasn1.SEQUENCE) {
+		return nil, errMalformed
+	}
+	var b cryptobyte.Builder
+	var failed bool
+	b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		for !fields.Empty() {
+			var field cryptobyte.String
+			var tag cbasn1.Tag
+			if !fields.ReadAnyASN1Element(&field, &tag) {
+				failed = true
+				return
+			}
+			if tag != cbasn1.Tag(3).Constructed().ContextSpecific() {
+				b.AddBytes(field)
+				continue
+			}
+			var exts cryptobyte.String
+			if !field.ReadASN1(&field, tag) || !field.ReadASN1(&exts, cbasn1.SEQUENCE) {
+				failed = true
+				return
+			}
+			b.AddASN1(tag, func(b *cryptobyte.Builder) {
+				b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					for !exts.Empty() {
+						var ext, body cryptobyte.String
+						var oid asn1.ObjectIdentifier
+						if !exts.ReadASN1Element(&ext, cbasn1.SEQUENCE) {
+							failed = true
+							return
+						}
+						body = ext
+						if !body.ReadASN1(&body, cbasn1.SEQUENCE) || !body.ReadASN1ObjectIdentifier(&oid) {
+							failed = true
+							return
+						}
+						if !oid.Equal(oidSCTList) {
+							b.AddBytes(ext)
+						}
+					}
+				})
+			})
+		}
+	})
+	out, err := b.Bytes()
+	if err != nil || failed {
+		return nil, errMalformed
+	}
+	return out, nil
+}
@@ -0,0 +1,300 @@
+package dot
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// testCTLog is a Certificate Transparency log issuing SCTs for tests.
+type testCTLog struct {
+	signer crypto.Signer
+	spki   []byte
+}
+
+func newTestCTLog(t *testing.T, rsaKey bool) *testCTLog {
+	t.Helper()
+	var signer crypto.Signer
+	var err error
+	if rsaKey {
+		signer, err = rsa.GenerateKey(crand.Reader, 2048)
+	} else {
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	spki, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCTLog{signer: signer, spki: spki}
+}
+
+// sct returns serialized SCT over log entry of given type, RFC 6962,
+// section 3.2.
+func (l *testCTLog) sct(t *testing.T, entryType uint16, entry []byte, ts time.Time) []byte {
+	t.Helper()
+	var signed cryptobyte.Builder
+	signed.AddUint8(0) // v1
+	signed.AddUint8(0) // certificate_timestamp
+	signed.AddUint64(uint64(ts.UnixMilli()))
+	signed.AddUint16(entryType)
+	signed.AddBytes(entry)
+	signed.AddUint16(0) // no extensions
+	digest := sha256.Sum256(signed.BytesOrPanic())
+	sig, err := l.signer.Sign(crand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigAlg := uint8(3) // ECDSA
+	if _, ok := l.signer.(*rsa.PrivateKey); ok {
+		sigAlg = 1
+	}
+	id := sha256.Sum256(l.spki)
+	var b cryptobyte.Builder
+	b.AddUint8(0)
+	b.AddBytes(id[:])
+	b.AddUint64(uint64(ts.UnixMilli()))
+	b.AddUint16(0)
+	b.AddUint8(4) // SHA-256
+	b.AddUint8(sigAlg)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(sig) })
+	return b.BytesOrPanic()
+}
+
+// ctTestPKI issues certificates with embedded SCTs.
+type ctTestPKI struct {
+	ca    *x509.Certificate
+	caKey crypto.Signer
+}
+
+func newCTTestPKI(t *testing.T) *ctTestPKI {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(crand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &ctTestPKI{ca: ca, caKey: key}
+}
+
+// issue returns leaf certificate with embedded SCTs that sign returns for
+// its precertificate log entry, or without SCT list extension if there are
+// none.
+func (p *ctTestPKI) issue(t *testing.T, sign func(precert []byte) [][]byte) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "dot.test"},
+		DNSNames:     []string{"dot.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(crand.Reader, tmpl, p.ca, key.Public(), p.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	precert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyHash := sha256.Sum256(p.ca.RawSubjectPublicKeyInfo)
+	var entry cryptobyte.Builder
+	entry.AddBytes(keyHash[:])
+	entry.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(precert.RawTBSCertificate) })
+	scts := sign(entry.BytesOrPanic())
+	if len(scts) == 0 {
+		return precert
+	}
+	var list cryptobyte.Builder
+	list.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		for _, sct := range scts {
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(sct) })
+		}
+	})
+	value, err := asn1.Marshal(list.BytesOrPanic())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.ExtraExtensions = []pkix.Extension{{Id: oidSCTList, Value: value}}
+	if der, err = x509.CreateCertificate(crand.Reader, tmpl, p.ca, key.Public(), p.caKey); err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf
+}
+
+// certEntry returns log entry of certificate, as SCTs sent in the TLS
+// extension sign.
+func certEntry(cert *x509.Certificate) []byte {
+	var b cryptobyte.Builder
+	b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(cert.Raw) })
+	return b.BytesOrPanic()
+}
+
+func TestVerifySCTs(t *testing.T) {
+	ecLog, rsaLog, untrusted := newTestCTLog(t, false), newTestCTLog(t, true), newTestCTLog(t, false)
+	logs := make(map[[sha256.Size]byte]ctLog)
+	for name, l := range map[string]*testCTLog{"ec": ecLog, "rsa": rsaLog} {
+		key, err := x509.ParsePKIXPublicKey(l.spki)
+		if err != nil {
+			t.Fatal(err)
+		}
+		logs[sha256.Sum256(l.spki)] = ctLog{name: name, key: key}
+	}
+	pki := newCTTestPKI(t)
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+
+	for _, tc := range []struct {
+		name     string
+		embedded func(precert []byte) [][]byte
+		tls      func(leaf *x509.Certificate) [][]byte // SCTs sent in TLS extension
+		min      int
+		ok       bool
+	}{
+		{
+			name: "embedded",
+			embedded: func(e []byte) [][]byte {
+				return [][]byte{ecLog.sct(t, 1, e, past), rsaLog.sct(t, 1, e, past)}
+			},
+			min: 2, ok: true,
+		},
+		{
+			name:     "embedded too few",
+			embedded: func(e []byte) [][]byte { return [][]byte{ecLog.sct(t, 1, e, past)} },
+			min:      2,
+		},
+		{
+			name:     "embedded one required",
+			embedded: func(e []byte) [][]byte { return [][]byte{rsaLog.sct(t, 1, e, past)} },
+			min:      1, ok: true,
+		},
+		{
+			name: "same log twice",
+			embedded: func(e []byte) [][]byte {
+				return [][]byte{ecLog.sct(t, 1, e, past), ecLog.sct(t, 1, e, past.Add(-time.Second))}
+			},
+			min: 2,
+		},
+		{
+			name: "untrusted log",
+			embedded: func(e []byte) [][]byte {
+				return [][]byte{ecLog.sct(t, 1, e, past), untrusted.sct(t, 1, e, past)}
+			},
+			min: 2,
+		},
+		{
+			name: "future timestamp",
+			embedded: func(e []byte) [][]byte {
+				return [][]byte{ecLog.sct(t, 1, e, past), rsaLog.sct(t, 1, e, future)}
+			},
+			min: 2,
+		},
+		{
+			name: "wrong entry type",
+			embedded: func(e []byte) [][]byte {
+				return [][]byte{ecLog.sct(t, 1, e, past), rsaLog.sct(t, 0, e, past)}
+			},
+			min: 2,
+		},
+		{
+			name:     "tls extension",
+			embedded: func([]byte) [][]byte { return nil },
+			tls: func(leaf *x509.Certificate) [][]byte {
+				return [][]byte{ecLog.sct(t, 0, certEntry(leaf), past), rsaLog.sct(t, 0, certEntry(leaf), past)}
+			},
+			min: 2, ok: true,
+		},
+		{
+			name:     "embedded and tls extension",
+			embedded: func(e []byte) [][]byte { return [][]byte{ecLog.sct(t, 1, e, past)} },
+			tls: func(leaf *x509.Certificate) [][]byte {
+				return [][]byte{rsaLog.sct(t, 0, certEntry(leaf), past)}
+			},
+			min: 2, ok: true,
+		},
+		{
+			name:     "tls extension over precertificate",
+			embedded: func([]byte) [][]byte { return nil },
+			tls: func(leaf *x509.Certificate) [][]byte {
+				return [][]byte{ecLog.sct(t, 1, certEntry(leaf), past), rsaLog.sct(t, 0, certEntry(leaf), past)}
+			},
+			min: 2,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			leaf := pki.issue(t, tc.embedded)
+			cs := tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf, pki.ca}}}
+			if tc.tls != nil {
+				cs.SignedCertificateTimestamps = tc.tls(leaf)
+			}
+			err := verifySCTs(cs, logs, tc.min)
+			if (err == nil) != tc.ok {
+				t.Fatalf("got error %v, want ok=%t", err, tc.ok)
+			}
+			if err != nil && !strings.HasPrefix(err.Error(), "dot: certificate transparency:") {
+				t.Errorf("unexpected error %q", err)
+			}
+		})
+	}
+
+	t.Run("no chain", func(t *testing.T) {
+		if err := verifySCTs(tls.ConnectionState{}, logs, 1); err == nil {
+			t.Error("connection without verified chain passed")
+		}
+	})
+}
+
+func TestWithCertificateTransparencyLogs(t *testing.T) {
+	ecLog := newTestCTLog(t, false)
+	var c config
+	WithCertificateTransparency([]CTLog{{Name: "broken", PublicKey: []byte("junk")}, {Name: "ec", PublicKey: ecLog.spki}}, 0)(&c)
+	if len(c.verifiers) != 1 {
+		t.Fatalf("got %d verifiers, want 1", len(c.verifiers))
+	}
+	pki := newCTTestPKI(t)
+	leaf := pki.issue(t, func(e []byte) [][]byte { return [][]byte{ecLog.sct(t, 1, e, time.Now())} })
+	// two SCTs are required by default
+	err := c.verifiers[0](tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf, pki.ca}}})
+	if err == nil || !strings.Contains(err.Error(), `["ec"]`) {
+		t.Errorf("got error %v, want one naming the only valid log", err)
+	}
+}
@@ -0,0 +1,19 @@
+package dot
+
+import "net"
+
+// SetDefault replaces net.DefaultResolver with r, so that every lookup done
+// through the standard library without explicitly configured resolver, such
+// as net.Dial or http.Get, uses r. It returns function restoring the previous
+// default resolver.
+//
+// SetDefault is not safe to call concurrently with lookups, call it early in
+// the program, e.g. at the beginning of main.
+func SetDefault(r *net.Resolver) (restore func()) {
+	if r == nil {
+		panic("dot: nil Resolver")
+	}
+	prev := net.DefaultResolver
+	net.DefaultResolver = r
+	return func() { net.DefaultResolver = prev }
+}
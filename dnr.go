@@ -0,0 +1,434 @@
+package dot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+// ErrNoDNR is returned by SolicitDNR and FromDNR when network designates no
+// encrypted resolver usable by this package.
+var ErrNoDNR = errors.New("dot: no network-designated DNS-over-TLS resolver")
+
+// DNR is an encrypted resolver designated by the local network, learned from
+// DHCP or IPv6 Router Advertisement options, as described in RFC 9463. Use
+// FromDNR to get Resolver using it.
+type DNR struct {
+	Priority uint16 // lower values are preferred
+	Name     string // authentication domain name, which certificate must be valid for
+
+	// Addrs lists resolver addresses. If empty, designation is in the
+	// ADN-only mode, and resolver addresses are to be found by resolving
+	// Name with local network resolver.
+	Addrs []netip.Addr
+
+	ALPN    []string // protocols resolver supports, "dot" for DNS-over-TLS
+	Port    uint16   // port of encrypted DNS service, zero if default
+	DoHPath string   // URI template of DNS-over-HTTPS service, if any
+
+	// Lifetime is how long designation learned from Router Advertisement
+	// is valid, zero if it is not limited, as with DHCP ones.
+	Lifetime time.Duration
+}
+
+const (
+	raDNR      = 144 // Router Advertisement option type of RFC 9463
+	raTimeout  = 3 * time.Second
+	raHopLimit = 255
+)
+
+// SvcParamKeys of DNR options, see RFC 9460 and RFC 9461.
+const (
+	svcParamMandatory = 0
+	svcParamALPN      = 1
+	svcParamPort      = 3
+	svcParamDoHPath   = 7
+)
+
+// ParseDHCPv6DNR parses data of DHCPv6 OPTION_V6_DNR option (code 144), not
+// including option code and length, as passed to client hooks by DHCP
+// clients.
+func ParseDHCPv6DNR(data []byte) (DNR, error) {
+	var d DNR
+	s := cryptobyte.String(data)
+	var adn cryptobyte.String
+	if !s.ReadUint16(&d.Priority) || !s.ReadUint16LengthPrefixed(&adn) {
+		return d, errDNRMalformed
+	}
+	if err := d.setName(adn); err != nil {
+		return d, err
+	}
+	if s.Empty() {
+		return d, nil // ADN-only mode
+	}
+	var addrs cryptobyte.String
+	if !s.ReadUint16LengthPrefixed(&addrs) {
+		return d, errDNRMalformed
+	}
+	if err := d.setAddrs(addrs, 16); err != nil {
+		return d, err
+	}
+	return d, d.setParams(s)
+}
+
+// ParseDHCPv4DNR parses data of DHCPv4 OPTION_V4_DNR option (code 162),
+// not including option code and length, which may carry several
+// designations. If option is split into several ones, as described in RFC
+// 3396, their data must be concatenated. Designations that are not valid
+// are skipped; if there are no valid ones, the first error is returned.
+func ParseDHCPv4DNR(data []byte) ([]DNR, error) {
+	var out []DNR
+	var firstErr error
+	s := cryptobyte.String(data)
+	for !s.Empty() {
+		var inst cryptobyte.String
+		if !s.ReadUint16LengthPrefixed(&inst) {
+			return out, errDNRMalformed
+		}
+		d, err := parseDHCPv4Instance(inst)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		out = append(out, d)
+	}
+	if len(out) == 0 {
+		return nil, firstErr
+	}
+	return out, nil
+}
+
+func parseDHCPv4Instance(s cryptobyte.String) (DNR, error) {
+	var d DNR
+	var adn cryptobyte.String
+	if !s.ReadUint16(&d.Priority) || !s.ReadUint8LengthPrefixed(&adn) {
+		return d, errDNRMalformed
+	}
+	if err := d.setName(adn); err != nil {
+		return d, err
+	}
+	if s.Empty() {
+		return d, nil // ADN-only mode
+	}
+	var addrs cryptobyte.String
+	if !s.ReadUint8LengthPrefixed(&addrs) {
+		return d, errDNRMalformed
+	}
+	if err := d.setAddrs(addrs, 4); err != nil {
+		return d, err
+	}
+	return d, d.setParams(s)
+}
+
+// ParseRADNR parses DNR option (type 144) of IPv6 Router Advertisement,
+// including its type and length fields. Designation with zero lifetime, by
+// which router withdraws it, is reported as an error.
+func ParseRADNR(option []byte) (DNR, error) {
+	var d DNR
+	s := cryptobyte.String(option)
+	var typ, length uint8
+	var lifetime uint32
+	var adn cryptobyte.String
+	if !s.ReadUint8(&typ) || !s.ReadUint8(&length) || typ != raDNR || int(length)*8 != len(option) ||
+		!s.ReadUint16(&d.Priority) || !s.ReadUint32(&lifetime) || !s.ReadUint16LengthPrefixed(&adn) {
+		return d, errDNRMalformed
+	}
+	if lifetime == 0 {
+		return d, errors.New("dot: DNR: designation withdrawn")
+	}
+	if lifetime != math.MaxUint32 {
+		d.Lifetime = time.Duration(lifetime) * time.Second
+	}
+	if err := d.setName(adn); err != nil {
+		return d, err
+	}
+	if isPadding(s) {
+		return d, nil // ADN-only mode
+	}
+	var addrs, params cryptobyte.String
+	if !s.ReadUint16LengthPrefixed(&addrs) || !s.ReadUint16LengthPrefixed(&params) || !isPadding(s) {
+		return d, errDNRMalformed
+	}
+	if err := d.setAddrs(addrs, 16); err != nil {
+		return d, err
+	}
+	return d, d.setParams(params)
+}
+
+var errDNRMalformed = errors.New("dot: DNR: malformed option")
+
+func isPadding(s cryptobyte.String) bool {
+	for _, b := range s {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// setName sets d.Name to authentication domain name encoded in the DNS wire
+// format, without compression.
+func (d *DNR) setName(s cryptobyte.String) error {
+	var labels []string
+	for {
+		var label cryptobyte.String
+		if !s.ReadUint8LengthPrefixed(&label) {
+			return errDNRMalformed
+		}
+		if len(label) == 0 {
+			break
+		}
+		labels = append(labels, string(label))
+	}
+	name := strings.Join(labels, ".")
+	if !s.Empty() || len(labels) == 0 || !validHostname(name) {
+		return fmt.Errorf("dot: DNR: invalid authentication domain name %q", name)
+	}
+	d.Name = hostsKey(name)
+	return nil
+}
+
+// setAddrs sets d.Addrs to addresses of given size listed in s, skipping
+// ones that cannot designate a resolver, as RFC 9463, section 3.1.9
+// requires.
+func (d *DNR) setAddrs(s cryptobyte.String, size int) error {
+	if len(s) == 0 || len(s)%size != 0 {
+		return errDNRMalformed
+	}
+	for ; !s.Empty(); s = s[size:] {
+		ip, _ := netip.AddrFromSlice(s[:size])
+		if ip.IsUnspecified() || ip.IsMulticast() || ip.IsLoopback() || ip == netip.AddrFrom4([4]byte{255, 255, 255, 255}) {
+			continue
+		}
+		d.Addrs = append(d.Addrs, ip)
+	}
+	if len(d.Addrs) == 0 {
+		return errors.New("dot: DNR: no usable addresses")
+	}
+	return nil
+}
+
+// setParams sets d fields from service parameters encoded as in SVCB
+// records, see RFC 9460, section 2.2.
+func (d *DNR) setParams(s cryptobyte.String) error {
+	last := -1
+	var mandatory []uint16
+	for !s.Empty() {
+		var key uint16
+		var val cryptobyte.String
+		if !s.ReadUint16(&key) || !s.ReadUint16LengthPrefixed(&val) {
+			return errDNRMalformed
+		}
+		if int(key) <= last {
+			return errors.New("dot: DNR: service parameters out of order")
+		}
+		last = int(key)
+		switch key {
+		case svcParamMandatory:
+			for !val.Empty() {
+				var k uint16
+				if !val.ReadUint16(&k) {
+					return errDNRMalformed
+				}
+				mandatory = append(mandatory, k)
+			}
+		case svcParamALPN:
+			for !val.Empty() {
+				var id cryptobyte.String
+				if !val.ReadUint8LengthPrefixed(&id) || len(id) == 0 {
+					return errDNRMalformed
+				}
+				d.ALPN = append(d.ALPN, string(id))
+			}
+		case svcParamPort:
+			if !val.ReadUint16(&d.Port) || !val.Empty() {
+				return errDNRMalformed
+			}
+		case svcParamDoHPath:
+			d.DoHPath = string(val)
+		}
+	}
+	for _, k := range mandatory {
+		if k != svcParamALPN && k != svcParamPort && k != svcParamDoHPath {
+			return fmt.Errorf("dot: DNR: unsupported mandatory service parameter %d", k)
+		}
+	}
+	if len(d.ALPN) == 0 {
+		return errors.New("dot: DNR: no alpn service parameter")
+	}
+	return nil
+}
+
+// FromDNR returns Resolver using network-designated resolvers that support
+// DNS-over-TLS, verifying that their certificates are valid for their
+// authentication domain names. Designations are tried in the order of their
+// priority, falling back to the next ones on failures as with Fallback.
+// Names of ADN-only designations are resolved with the system resolver
+// when connecting. If dnrs has no DNS-over-TLS designations, FromDNR
+// returns ErrNoDNR.
+//
+// Together with SolicitDNR it makes the network-provided encrypted resolver
+// usable without any configuration:
+//
+//	dnrs, err := dot.SolicitDNR(ctx, "")
+//	if err != nil {
+//		return err
+//	}
+//	r, err := dot.FromDNR(dnrs)
+func FromDNR(dnrs []DNR, opts ...Option) (*net.Resolver, error) {
+	dnrs = append([]DNR(nil), dnrs...)
+	sort.SliceStable(dnrs, func(i, j int) bool { return dnrs[i].Priority < dnrs[j].Priority })
+	var hs []Handler
+	for _, d := range dnrs {
+		if !hasProto(d.ALPN, alpnProto) && len(d.Addrs) != 0 {
+			continue
+		}
+		port := "853"
+		if d.Port != 0 {
+			port = strconv.Itoa(int(d.Port))
+		}
+		var addrs []string
+		for _, ip := range d.Addrs {
+			addrs = append(addrs, net.JoinHostPort(ip.String(), port))
+		}
+		if len(addrs) == 0 {
+			addrs = []string{net.JoinHostPort(d.Name, port)}
+		}
+		c, err := NewClientChecked(d.Name, addrs, opts...)
+		if err != nil {
+			return nil, err
+		}
+		hs = append(hs, c)
+	}
+	if len(hs) == 0 {
+		return nil, ErrNoDNR
+	}
+	return chainResolvers(hs), nil
+}
+
+// SolicitDNR sends IPv6 Router Solicitation on interface with given name,
+// or on all multicast-capable interfaces if ifname is empty, and returns
+// resolvers designated by DNR options of the first Router Advertisement
+// having valid ones. If no such advertisement arrives within 3 seconds, or
+// before ctx is done, it returns ErrNoDNR. Invalid designations are
+// skipped.
+//
+// It needs privileges to open raw ICMPv6 socket, such as CAP_NET_RAW
+// capability on Linux.
+func SolicitDNR(ctx context.Context, ifname string) ([]DNR, error) {
+	var ifs []net.Interface
+	if ifname != "" {
+		ifi, err := net.InterfaceByName(ifname)
+		if err != nil {
+			return nil, err
+		}
+		ifs = append(ifs, *ifi)
+	} else {
+		all, err := net.Interfaces()
+		if err != nil {
+			return nil, err
+		}
+		for _, ifi := range all {
+			if ifi.Flags&net.FlagUp != 0 && ifi.Flags&net.FlagMulticast != 0 && ifi.Flags&net.FlagLoopback == 0 {
+				ifs = append(ifs, ifi)
+			}
+		}
+	}
+	if len(ifs) == 0 {
+		return nil, errors.New("dot: no interfaces to solicit router advertisements on")
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, raTimeout)
+		defer cancel()
+	}
+	c, err := icmp.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	pc := c.IPv6PacketConn()
+	if err := pc.SetControlMessage(ipv6.FlagHopLimit|ipv6.FlagInterface, true); err != nil {
+		return nil, err
+	}
+	var filter ipv6.ICMPFilter
+	filter.SetAll(true)
+	filter.Accept(ipv6.ICMPTypeRouterAdvertisement)
+	pc.SetICMPFilter(&filter)
+	d, _ := ctx.Deadline()
+	pc.SetReadDeadline(d)
+	stop := context.AfterFunc(ctx, func() { c.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	rs := []byte{byte(ipv6.ICMPTypeRouterSolicitation), 0, 0, 0, 0, 0, 0, 0}
+	dst := &net.IPAddr{IP: net.ParseIP("ff02::2")}
+	var sent bool
+	for _, ifi := range ifs {
+		if _, err = pc.WriteTo(rs, &ipv6.ControlMessage{HopLimit: raHopLimit, IfIndex: ifi.Index}, dst); err == nil {
+			sent = true
+		}
+	}
+	if !sent {
+		return nil, err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, cm, src, err := pc.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ErrNoDNR
+			}
+			return nil, err
+		}
+		// RFC 4861, section 6.1.2: advertisements must come from
+		// link-local address with hop limit untouched by forwarding
+		if cm == nil || cm.HopLimit != raHopLimit {
+			continue
+		}
+		if a, ok := src.(*net.IPAddr); !ok || !a.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ifname != "" && cm.IfIndex != ifs[0].Index {
+			continue
+		}
+		if dnrs := parseRA(buf[:n]); len(dnrs) != 0 {
+			return dnrs, nil
+		}
+	}
+}
+
+// parseRA returns valid designations of DNR options in Router Advertisement
+// message.
+func parseRA(msg []byte) []DNR {
+	const headerLen = 16 // type, code, checksum, and RA fields
+	if len(msg) < headerLen || msg[0] != byte(ipv6.ICMPTypeRouterAdvertisement) {
+		return nil
+	}
+	var out []DNR
+	for opts := msg[headerLen:]; len(opts) >= 2; {
+		n := int(opts[1]) * 8
+		if n == 0 || n > len(opts) {
+			break
+		}
+		if opts[0] == raDNR {
+			if d, err := ParseRADNR(opts[:n]); err == nil {
+				out = append(out, d)
+			}
+		}
+		opts = opts[n:]
+	}
+	return out
+}
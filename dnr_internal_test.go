@@ -0,0 +1,45 @@
+package dot
+
+import (
+	"testing"
+
+	"golang.org/x/net/ipv6"
+)
+
+func TestParseRA(t *testing.T) {
+	adnOnly := []byte{
+		144, 4, 0, 10, 0, 0, 0x02, 0x58, // type, length, priority, lifetime
+		0, 15, 8, 'r', 'e', 's', 'o', 'l', 'v', 'e', 'r', 4, 't', 'e', 's', 't', 0,
+		0, 0, 0, 0, 0, 0, 0, // padding
+	}
+	withdrawn := append([]byte(nil), adnOnly...)
+	copy(withdrawn[4:8], []byte{0, 0, 0, 0})
+	mtu := []byte{5, 1, 0, 0, 0, 0, 0x05, 0xdc}
+	header := make([]byte, 16)
+	header[0] = byte(ipv6.ICMPTypeRouterAdvertisement)
+	for _, tc := range []struct {
+		name string
+		msg  []byte
+		want int
+	}{
+		{"dnr among other options", append(append(append([]byte(nil), header...), mtu...), adnOnly...), 1},
+		{"two dnr options", append(append(append([]byte(nil), header...), adnOnly...), adnOnly...), 2},
+		{"withdrawn skipped", append(append(append([]byte(nil), header...), withdrawn...), adnOnly...), 1},
+		{"zero length option", append(append(append([]byte(nil), header...), 5, 0), adnOnly...), 0},
+		{"truncated option", append(append([]byte(nil), header...), adnOnly[:16]...), 0},
+		{"not an advertisement", append([]byte{byte(ipv6.ICMPTypeRouterSolicitation)}, adnOnly[1:]...), 0},
+		{"short", header[:8], 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dnrs := parseRA(tc.msg)
+			if len(dnrs) != tc.want {
+				t.Fatalf("got %d designations, want %d: %+v", len(dnrs), tc.want, dnrs)
+			}
+			for _, d := range dnrs {
+				if d.Name != "resolver.test" || d.Priority != 10 || d.Lifetime.Seconds() != 600 {
+					t.Errorf("got %+v", d)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package dot_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/artyom/dot"
+	"github.com/artyom/dot/dottest"
+)
+
+// wireName returns name in the DNS wire format.
+func wireName(name string) []byte {
+	var b []byte
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// svcParam returns SVCB service parameter with given key and value.
+func svcParam(key uint16, val ...byte) []byte {
+	b := binary.BigEndian.AppendUint16(nil, key)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(val)))
+	return append(b, val...)
+}
+
+func u16(v int) []byte { return binary.BigEndian.AppendUint16(nil, uint16(v)) }
+
+func join(parts ...[]byte) []byte { return slices.Concat(parts...) }
+
+// dotParams are service parameters of DNS-over-TLS and DNS-over-HTTPS
+// resolver on port 8853.
+var dotParams = join(
+	svcParam(1, join([]byte{3}, []byte("dot"), []byte{2}, []byte("h2"))...),
+	svcParam(3, u16(8853)...),
+	svcParam(7, []byte("/dns-query{?dns}")...),
+)
+
+var dotDNR = dot.DNR{
+	Priority: 10,
+	Name:     "resolver.example",
+	ALPN:     []string{"dot", "h2"},
+	Port:     8853,
+	DoHPath:  "/dns-query{?dns}",
+}
+
+func equalDNR(a, b dot.DNR) bool {
+	return a.Priority == b.Priority && a.Name == b.Name && slices.Equal(a.Addrs, b.Addrs) &&
+		slices.Equal(a.ALPN, b.ALPN) && a.Port == b.Port && a.DoHPath == b.DoHPath && a.Lifetime == b.Lifetime
+}
+
+func withAddrs(d dot.DNR, addrs ...string) dot.DNR {
+	for _, s := range addrs {
+		d.Addrs = append(d.Addrs, netip.MustParseAddr(s))
+	}
+	return d
+}
+
+func TestParseDHCPv6DNR(t *testing.T) {
+	adn := wireName("Resolver.Example.")
+	addrs := join(netip.MustParseAddr("2001:db8::1").AsSlice(), net.IPv6loopback, netip.MustParseAddr("2001:db8::2").AsSlice())
+	option := func(params ...[]byte) []byte {
+		return join(u16(10), u16(len(adn)), adn, u16(len(addrs)), addrs, join(params...))
+	}
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want dot.DNR
+		ok   bool
+	}{
+		{"full", option(dotParams), withAddrs(dotDNR, "2001:db8::1", "2001:db8::2"), true},
+		{"adn only", join(u16(10), u16(len(adn)), adn), dot.DNR{Priority: 10, Name: "resolver.example"}, true},
+		{"mandatory known", option(svcParam(0, u16(3)...), dotParams), withAddrs(dotDNR, "2001:db8::1", "2001:db8::2"), true},
+		{"mandatory unknown", option(svcParam(0, u16(5)...), dotParams), dot.DNR{}, false},
+		{"params out of order", option(svcParam(3, u16(853)...), svcParam(1, 3, 'd', 'o', 't')), dot.DNR{}, false},
+		{"no alpn", option(svcParam(3, u16(853)...)), dot.DNR{}, false},
+		{"empty alpn id", option(svcParam(1, 0)), dot.DNR{}, false},
+		{"long port", option(svcParam(1, 3, 'd', 'o', 't'), svcParam(3, 0, 0, 0)), dot.DNR{}, false},
+		{"truncated params", option(svcParam(1, 3, 'd', 'o', 't'))[:len(option(svcParam(1, 3, 'd', 'o', 't')))-1], dot.DNR{}, false},
+		{"loopback only", join(u16(10), u16(len(adn)), adn, u16(16), net.IPv6loopback, dotParams), dot.DNR{}, false},
+		{"partial address", join(u16(10), u16(len(adn)), adn, u16(4), []byte{1, 2, 3, 4}, dotParams), dot.DNR{}, false},
+		{"no addresses", join(u16(10), u16(len(adn)), adn, u16(0), dotParams), dot.DNR{}, false},
+		{"root name", join(u16(10), u16(1), []byte{0}), dot.DNR{}, false},
+		{"invalid name", join(u16(10), u16(6), []byte{4, 'a', ' ', 'b', 'c', 0}), dot.DNR{}, false},
+		{"name with trailing data", join(u16(10), u16(len(adn)+1), adn, []byte{0}), dot.DNR{}, false},
+		{"truncated name", join(u16(10), u16(len(adn)), adn[:5]), dot.DNR{}, false},
+		{"empty", nil, dot.DNR{}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := dot.ParseDHCPv6DNR(tc.data)
+			if (err == nil) != tc.ok {
+				t.Fatalf("got error %v, want ok=%t", err, tc.ok)
+			}
+			if tc.ok && !equalDNR(d, tc.want) {
+				t.Errorf("got %+v\nwant %+v", d, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseDHCPv4DNR(t *testing.T) {
+	adn := wireName("resolver.example")
+	instance := func(addrs ...byte) []byte {
+		b := join(u16(10), []byte{byte(len(adn))}, adn, []byte{byte(len(addrs))}, addrs, dotParams)
+		return join(u16(len(b)), b)
+	}
+	adnOnly := join(u16(2+1+len(adn)), u16(20), []byte{byte(len(adn))}, adn)
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want []dot.DNR
+		ok   bool
+	}{
+		{
+			name: "two instances",
+			data: join(instance(192, 0, 2, 1, 127, 0, 0, 1, 192, 0, 2, 2), adnOnly),
+			want: []dot.DNR{withAddrs(dotDNR, "192.0.2.1", "192.0.2.2"), {Priority: 20, Name: "resolver.example"}},
+			ok:   true,
+		},
+		{
+			name: "invalid instance skipped",
+			data: join(instance(255, 255, 255, 255), instance(192, 0, 2, 1)),
+			want: []dot.DNR{withAddrs(dotDNR, "192.0.2.1")},
+			ok:   true,
+		},
+		{name: "no valid instances", data: join(instance(0, 0, 0, 0), instance(224, 0, 0, 1))},
+		{name: "truncated", data: instance(192, 0, 2, 1)[:10]},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dnrs, err := dot.ParseDHCPv4DNR(tc.data)
+			if (err == nil) != tc.ok {
+				t.Fatalf("got error %v, want ok=%t", err, tc.ok)
+			}
+			if tc.ok && !slices.EqualFunc(dnrs, tc.want, equalDNR) {
+				t.Errorf("got %+v\nwant %+v", dnrs, tc.want)
+			}
+		})
+	}
+}
+
+// raOption returns Router Advertisement DNR option with given lifetime and
+// body following ADN, padded to a multiple of 8 bytes with pad.
+func raOption(lifetime uint32, body []byte, pad byte) []byte {
+	adn := wireName("resolver.example")
+	b := join([]byte{144, 0}, u16(10), binary.BigEndian.AppendUint32(nil, lifetime), u16(len(adn)), adn, body)
+	for len(b)%8 != 0 {
+		b = append(b, pad)
+	}
+	b[1] = byte(len(b) / 8)
+	return b
+}
+
+func TestParseRADNR(t *testing.T) {
+	addrs := netip.MustParseAddr("2001:db8::1").AsSlice()
+	body := join(u16(len(addrs)), addrs, u16(len(dotParams)), dotParams)
+	withLifetime := func(d dot.DNR, lifetime time.Duration) dot.DNR {
+		d.Lifetime = lifetime
+		return d
+	}
+	wrongLength := raOption(600, body, 0)
+	wrongLength[1]--
+	for _, tc := range []struct {
+		name   string
+		option []byte
+		want   dot.DNR
+		ok     bool
+	}{
+		{"full", raOption(600, body, 0), withLifetime(withAddrs(dotDNR, "2001:db8::1"), 600*time.Second), true},
+		{"infinite lifetime", raOption(0xffffffff, body, 0), withAddrs(dotDNR, "2001:db8::1"), true},
+		{"adn only", raOption(600, nil, 0), dot.DNR{Priority: 10, Name: "resolver.example", Lifetime: 600 * time.Second}, true},
+		{"withdrawn", raOption(0, body, 0), dot.DNR{}, false},
+		{"nonzero padding", raOption(600, body, 1), dot.DNR{}, false},
+		{"wrong length", wrongLength, dot.DNR{}, false},
+		{"wrong type", append([]byte{3}, raOption(600, body, 0)[1:]...), dot.DNR{}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := dot.ParseRADNR(tc.option)
+			if (err == nil) != tc.ok {
+				t.Fatalf("got error %v, want ok=%t", err, tc.ok)
+			}
+			if tc.ok && !equalDNR(d, tc.want) {
+				t.Errorf("got %+v\nwant %+v", d, tc.want)
+			}
+		})
+	}
+}
+
+func TestFromDNR(t *testing.T) {
+	srv := dottest.NewServer(dottest.Zone{"example.com": {"192.0.2.1"}})
+	defer srv.Close()
+	_, portStr, err := net.SplitHostPort(srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// closed port for designation that does not work
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadPort := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	loopback := []netip.Addr{netip.MustParseAddr("127.0.0.1")}
+	working := dot.DNR{Priority: 20, Name: dottest.ServerName, Addrs: loopback, ALPN: []string{"dot"}, Port: uint16(port)}
+	broken := dot.DNR{Priority: 10, Name: dottest.ServerName, Addrs: loopback, ALPN: []string{"dot"}, Port: uint16(deadPort)}
+	dohOnly := dot.DNR{Priority: 1, Name: dottest.ServerName, Addrs: loopback, ALPN: []string{"h2"}, Port: uint16(port)}
+	wrongName := working
+	wrongName.Name = "other.test"
+	for _, tc := range []struct {
+		name    string
+		dnrs    []dot.DNR
+		noDNR   bool
+		wantErr bool
+	}{
+		{name: "single", dnrs: []dot.DNR{working}},
+		{name: "fallback by priority", dnrs: []dot.DNR{working, dohOnly, broken}},
+		{name: "only broken", dnrs: []dot.DNR{broken}, wantErr: true},
+		{name: "wrong name", dnrs: []dot.DNR{wrongName}, wantErr: true},
+		{name: "doh only", dnrs: []dot.DNR{dohOnly}, noDNR: true},
+		{name: "none", noDNR: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := dot.FromDNR(tc.dnrs, dot.WithRootCAs(srv.RootCAs()))
+			if tc.noDNR {
+				if !errors.Is(err, dot.ErrNoDNR) {
+					t.Fatalf("got error %v, want ErrNoDNR", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			addrs, err := r.LookupHost(ctx, "example.com")
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("lookup succeeded with %v", addrs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(addrs) != 1 || addrs[0] != "192.0.2.1" {
+				t.Errorf("got %v, want 192.0.2.1", addrs)
+			}
+		})
+	}
+}
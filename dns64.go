@@ -0,0 +1,106 @@
+package dot
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// wellKnownPrefix is the NAT64 Well-Known Prefix, RFC 6052, section 2.1.
+var wellKnownPrefix = netip.MustParsePrefix("64:ff9b::/96")
+
+// DNS64 returns Handler that passes queries to next and synthesizes AAAA
+// records from A records for names with no AAAA records of their own, as
+// described in RFC 6147. Use it to let IPv6-only clients behind NAT64 reach
+// IPv4-only hosts through upstreams that don't do DNS64.
+//
+// Synthesized addresses embed IPv4 address into prefix as described in RFC
+// 6052, section 2.2. If prefix is the zero value, the Well-Known Prefix
+// 64:ff9b::/96 is used. DNS64 panics if prefix is not an IPv6 one of 32, 40,
+// 48, 56, 64 or 96 bits long.
+func DNS64(next Handler, prefix netip.Prefix) Handler {
+	if next == nil {
+		panic("dot: nil Handler")
+	}
+	if !prefix.IsValid() {
+		prefix = wellKnownPrefix
+	}
+	switch prefix.Bits() {
+	case 32, 40, 48, 56, 64, 96:
+	default:
+		panic("dot: invalid NAT64 prefix length")
+	}
+	if !prefix.Addr().Is6() || prefix.Addr().Is4In6() {
+		panic("dot: NAT64 prefix must be an IPv6 one")
+	}
+	prefix = prefix.Masked()
+	return HandlerFunc(func(ctx context.Context, query []byte) ([]byte, error) {
+		resp, err := next.ServeDNS(ctx, query)
+		if err != nil || resp == nil {
+			return resp, err
+		}
+		var q dnsmessage.Message
+		if err := q.Unpack(query); err != nil || len(q.Questions) != 1 ||
+			q.Questions[0].Type != dnsmessage.TypeAAAA || q.Questions[0].Class != dnsmessage.ClassINET {
+			return resp, nil
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(resp); err != nil {
+			return nil, fmt.Errorf("dot: parsing response: %w", err)
+		}
+		if msg.Header.RCode != dnsmessage.RCodeSuccess || msg.Header.Truncated {
+			return resp, nil
+		}
+		for _, rr := range msg.Answers {
+			if rr.Header.Type == dnsmessage.TypeAAAA {
+				return resp, nil
+			}
+		}
+		q.Questions[0].Type = dnsmessage.TypeA
+		aquery, err := q.Pack()
+		if err != nil {
+			return nil, err
+		}
+		aresp, err := next.ServeDNS(ctx, aquery)
+		if err != nil || aresp == nil || checkResponse(aquery, aresp, false) != nil {
+			return resp, nil // keep the native negative answer
+		}
+		var amsg dnsmessage.Message
+		if err := amsg.Unpack(aresp); err != nil || amsg.Header.RCode != dnsmessage.RCodeSuccess {
+			return resp, nil
+		}
+		var answers []dnsmessage.Resource
+		var synthesized bool
+		for _, rr := range amsg.Answers {
+			if a, ok := rr.Body.(*dnsmessage.AResource); ok {
+				rr.Header.Type = dnsmessage.TypeAAAA
+				rr.Body = &dnsmessage.AAAAResource{AAAA: embed4(prefix, a.A).As16()}
+				synthesized = true
+			}
+			answers = append(answers, rr)
+		}
+		if !synthesized {
+			return resp, nil
+		}
+		msg.Answers = answers
+		msg.Authorities = nil
+		return msg.Pack()
+	})
+}
+
+// embed4 returns IPv4-embedded IPv6 address as described in RFC 6052,
+// section 2.2. Prefix must be masked and have one of the allowed lengths.
+func embed4(prefix netip.Prefix, ip [4]byte) netip.Addr {
+	b := prefix.Addr().As16()
+	n := prefix.Bits() / 8
+	for _, c := range ip {
+		if n == 8 {
+			n++ // bits 64 to 71 must be zero
+		}
+		b[n] = c
+		n++
+	}
+	return netip.AddrFrom16(b)
+}
@@ -0,0 +1,323 @@
+package dot
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	crand "crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/poly1305"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// dnscryptMinQuerySize is the minimal size of padded UDP query.
+	dnscryptMinQuerySize = 256
+
+	// dnscryptCertTTL is how long server certificate is used before it is
+	// fetched again, unless it expires earlier.
+	dnscryptCertTTL = time.Hour
+
+	dnscryptESXSalsa20  = 1 // X25519-XSalsa20Poly1305
+	dnscryptESXChacha20 = 2 // X25519-XChacha20Poly1305
+)
+
+var (
+	dnscryptCertMagic     = []byte("DNSC")
+	dnscryptResolverMagic = []byte{0x72, 0x36, 0x66, 0x6e, 0x76, 0x57, 0x6a, 0x38}
+)
+
+// DNSCryptClient is a Handler sending queries to DNSCrypt server using the
+// version 2 of the protocol, as described at https://dnscrypt.info/protocol.
+// Servers are described by DNS stamps:
+//
+//	server, err := dot.ParseStamp("sdns://AQcAAAAAAAAA...")
+//	if err != nil {
+//		return err
+//	}
+//	r := dot.NewResolver(&dot.DNSCryptClient{Server: server})
+//
+// If Relay is set, queries are sent through that anonymization relay, so
+// that server never sees client address, and relay cannot see queries.
+//
+// Every query is encrypted with a new ephemeral key, so that server cannot
+// link queries to each other. Queries are sent over UDP, and retried over
+// TCP if response is truncated. Server certificate is fetched on the first
+// query, and refreshed every hour, or once it expires.
+//
+// DNSCryptClient is safe for concurrent use, its fields must not be changed
+// once it is used.
+type DNSCryptClient struct {
+	// Server is the stamp of the server, it must have StampDNSCrypt
+	// protocol.
+	Server *Stamp
+
+	// Relay, if set, is the stamp of relay to send queries through, it
+	// must have StampDNSCryptRelay protocol.
+	Relay *Stamp
+
+	mu      sync.Mutex
+	cert    *dnscryptCert
+	fetched time.Time
+}
+
+// dnscryptCert is the resolver certificate.
+type dnscryptCert struct {
+	esVersion   uint16
+	resolverPK  [32]byte
+	clientMagic [8]byte
+	serial      uint32
+	notBefore   time.Time
+	notAfter    time.Time
+}
+
+// ServeDNS implements Handler interface.
+func (c *DNSCryptClient) ServeDNS(ctx context.Context, query []byte) ([]byte, error) {
+	if len(query) < 12 {
+		return nil, errors.New("dot: query too short")
+	}
+	if c.Server == nil || c.Server.Proto != StampDNSCrypt {
+		return nil, errors.New("dot: DNSCryptClient needs DNSCrypt server stamp")
+	}
+	cert, err := c.getCert(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.exchange(ctx, cert, query, "udp")
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) >= 3 && resp[2]&0x02 != 0 { // TC bit
+		return c.exchange(ctx, cert, query, "tcp")
+	}
+	return resp, nil
+}
+
+// exchange sends encrypted query over network and returns decrypted
+// response.
+func (c *DNSCryptClient) exchange(ctx context.Context, cert *dnscryptCert, query []byte, network string) ([]byte, error) {
+	var priv [32]byte
+	if _, err := crand.Read(priv[:]); err != nil {
+		return nil, err
+	}
+	pub, err := curve25519.X25519(priv[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	key, err := cert.sharedKey(&priv)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [24]byte
+	if _, err := crand.Read(nonce[:12]); err != nil {
+		return nil, err
+	}
+	minSize := 0
+	if network == "udp" {
+		minSize = dnscryptMinQuerySize
+	}
+	msg := make([]byte, 0, 8+32+12+16+len(query)+64)
+	msg = append(msg, cert.clientMagic[:]...)
+	msg = append(msg, pub...)
+	msg = append(msg, nonce[:12]...)
+	msg = cert.seal(msg, dnscryptPad(query, minSize), &nonce, key)
+
+	resp, err := c.roundTrip(ctx, network, msg)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 8+24+16 || !bytes.Equal(resp[:8], dnscryptResolverMagic) || !bytes.Equal(resp[8:20], nonce[:12]) {
+		return nil, errors.New("dot: malformed DNSCrypt response")
+	}
+	copy(nonce[:], resp[8:32])
+	plain, ok := cert.open(resp[32:], &nonce, key)
+	if !ok {
+		return nil, errors.New("dot: failed to decrypt DNSCrypt response")
+	}
+	if plain, ok = dnscryptUnpad(plain); !ok || len(plain) < 12 {
+		return nil, errors.New("dot: malformed DNSCrypt response")
+	}
+	return plain, nil
+}
+
+// roundTrip sends msg to server, through relay if there is one, and reads
+// response.
+func (c *DNSCryptClient) roundTrip(ctx context.Context, network string, msg []byte) ([]byte, error) {
+	return dnscryptRoundTrip(ctx, network, c.Server, c.Relay, msg)
+}
+
+// getCert returns the valid server certificate, fetching it if needed.
+func (c *DNSCryptClient) getCert(ctx context.Context) (*dnscryptCert, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.cert != nil && now.Sub(c.fetched) < dnscryptCertTTL && now.Before(c.cert.notAfter) {
+		return c.cert, nil
+	}
+	query, err := newQuery(c.Server.ProviderName, dnsmessage.TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.roundTrip(ctx, "udp", query)
+	if err != nil {
+		return nil, fmt.Errorf("dot: fetching DNSCrypt certificate: %w", err)
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(resp); err != nil {
+		return nil, fmt.Errorf("dot: fetching DNSCrypt certificate: %w", err)
+	}
+	if msg.Header.Truncated {
+		if resp, err = c.roundTrip(ctx, "tcp", query); err != nil {
+			return nil, fmt.Errorf("dot: fetching DNSCrypt certificate: %w", err)
+		}
+		if err := msg.Unpack(resp); err != nil {
+			return nil, fmt.Errorf("dot: fetching DNSCrypt certificate: %w", err)
+		}
+	}
+	var best *dnscryptCert
+	for _, rr := range msg.Answers {
+		txt, ok := rr.Body.(*dnsmessage.TXTResource)
+		if !ok {
+			continue
+		}
+		cert, err := parseDNSCryptCert([]byte(strings.Join(txt.TXT, "")), c.Server.PublicKey, now)
+		if err != nil {
+			continue
+		}
+		if best == nil || cert.esVersion > best.esVersion ||
+			(cert.esVersion == best.esVersion && cert.serial > best.serial) {
+			best = cert
+		}
+	}
+	if best == nil {
+		return nil, errors.New("dot: no valid DNSCrypt certificate found")
+	}
+	c.cert, c.fetched = best, now
+	return best, nil
+}
+
+// parseDNSCryptCert parses and verifies resolver certificate signed by
+// provider key pk.
+func parseDNSCryptCert(b []byte, pk []byte, now time.Time) (*dnscryptCert, error) {
+	if len(b) < 124 || !bytes.Equal(b[:4], dnscryptCertMagic) {
+		return nil, errors.New("dot: malformed DNSCrypt certificate")
+	}
+	cert := &dnscryptCert{esVersion: binary.BigEndian.Uint16(b[4:])}
+	if cert.esVersion != dnscryptESXSalsa20 && cert.esVersion != dnscryptESXChacha20 {
+		return nil, errors.New("dot: unsupported DNSCrypt certificate version")
+	}
+	if len(pk) != ed25519.PublicKeySize || !ed25519.Verify(pk, b[72:], b[8:72]) {
+		return nil, errors.New("dot: invalid DNSCrypt certificate signature")
+	}
+	copy(cert.resolverPK[:], b[72:104])
+	copy(cert.clientMagic[:], b[104:112])
+	cert.serial = binary.BigEndian.Uint32(b[112:])
+	cert.notBefore = time.Unix(int64(binary.BigEndian.Uint32(b[116:])), 0)
+	cert.notAfter = time.Unix(int64(binary.BigEndian.Uint32(b[120:])), 0)
+	if now.Before(cert.notBefore) || !now.Before(cert.notAfter) {
+		return nil, errors.New("dot: DNSCrypt certificate is not valid now")
+	}
+	return cert, nil
+}
+
+// sharedKey computes key shared between client private key and resolver.
+func (cert *dnscryptCert) sharedKey(priv *[32]byte) (*[32]byte, error) {
+	var key [32]byte
+	if cert.esVersion == dnscryptESXSalsa20 {
+		box.Precompute(&key, &cert.resolverPK, priv)
+		return &key, nil
+	}
+	dh, err := curve25519.X25519(priv[:], cert.resolverPK[:])
+	if err != nil {
+		return nil, err
+	}
+	sub, err := chacha20.HChaCha20(dh, make([]byte, 16))
+	if err != nil {
+		return nil, err
+	}
+	copy(key[:], sub)
+	return &key, nil
+}
+
+// seal appends message encrypted with the secretbox construction, using
+// either XSalsa20 or XChacha20 as the certificate dictates.
+func (cert *dnscryptCert) seal(out, message []byte, nonce *[24]byte, key *[32]byte) []byte {
+	if cert.esVersion == dnscryptESXSalsa20 {
+		return secretbox.Seal(out, message, nonce, key)
+	}
+	s, polyKey := xchachaStream(nonce, key)
+	ct := make([]byte, len(message))
+	s.XORKeyStream(ct, message)
+	var tag [poly1305.TagSize]byte
+	poly1305.Sum(&tag, ct, polyKey)
+	out = append(out, tag[:]...)
+	return append(out, ct...)
+}
+
+// open decrypts box made by seal.
+func (cert *dnscryptCert) open(box []byte, nonce *[24]byte, key *[32]byte) ([]byte, bool) {
+	if cert.esVersion == dnscryptESXSalsa20 {
+		return secretbox.Open(nil, box, nonce, key)
+	}
+	if len(box) < poly1305.TagSize {
+		return nil, false
+	}
+	s, polyKey := xchachaStream(nonce, key)
+	var tag [poly1305.TagSize]byte
+	copy(tag[:], box)
+	ct := box[poly1305.TagSize:]
+	if !poly1305.Verify(&tag, ct, polyKey) {
+		return nil, false
+	}
+	plain := make([]byte, len(ct))
+	s.XORKeyStream(plain, ct)
+	return plain, true
+}
+
+// xchachaStream returns XChacha20 stream positioned past the first 32 bytes
+// of the key stream, which are returned as one-time Poly1305 key, mirroring
+// what secretbox does with XSalsa20.
+func xchachaStream(nonce *[24]byte, key *[32]byte) (*chacha20.Cipher, *[32]byte) {
+	s, err := chacha20.NewUnauthenticatedCipher(key[:], nonce[:])
+	if err != nil {
+		panic(err) // only fails on wrong key or nonce sizes
+	}
+	var block [32]byte
+	s.XORKeyStream(block[:], block[:])
+	return s, &block
+}
+
+// dnscryptPad pads query with 0x80 followed by zeros to a multiple of 64
+// bytes, and at least to minSize.
+func dnscryptPad(query []byte, minSize int) []byte {
+	size := (len(query) + 1 + 63) &^ 63
+	if size < minSize {
+		size = minSize
+	}
+	out := make([]byte, size)
+	copy(out, query)
+	out[len(query)] = 0x80
+	return out
+}
+
+// dnscryptUnpad strips padding added by dnscryptPad.
+func dnscryptUnpad(b []byte) ([]byte, bool) {
+	i := len(b) - 1
+	for i >= 0 && b[i] == 0 {
+		i--
+	}
+	if i < 0 || b[i] != 0x80 {
+		return nil, false
+	}
+	return b[:i], true
+}
@@ -0,0 +1,143 @@
+package dot
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	crand "crypto/rand"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// testDNSCryptCert returns resolver certificate signed by provider key priv.
+func testDNSCryptCert(priv ed25519.PrivateKey, esVersion uint16, resolverPK []byte, serial uint32, notBefore, notAfter time.Time) []byte {
+	signed := append([]byte(nil), resolverPK...)
+	signed = append(signed, "magic123"...)
+	signed = binary.BigEndian.AppendUint32(signed, serial)
+	signed = binary.BigEndian.AppendUint32(signed, uint32(notBefore.Unix()))
+	signed = binary.BigEndian.AppendUint32(signed, uint32(notAfter.Unix()))
+	b := append([]byte("DNSC"), 0, byte(esVersion), 0, 0)
+	b = append(b, ed25519.Sign(priv, signed)...)
+	return append(b, signed...)
+}
+
+func TestParseDNSCryptCert(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolverPK := bytes.Repeat([]byte{7}, 32)
+	now := time.Now()
+	valid := testDNSCryptCert(priv, dnscryptESXSalsa20, resolverPK, 42, now.Add(-time.Hour), now.Add(time.Hour))
+	tampered := append([]byte(nil), valid...)
+	tampered[len(tampered)-1] ^= 1
+	badMagic := append([]byte("DNSX"), valid[4:]...)
+	for _, tc := range []struct {
+		name string
+		b    []byte
+		pk   []byte
+		ok   bool
+	}{
+		{"xsalsa20", valid, pub, true},
+		{"xchacha20", testDNSCryptCert(priv, dnscryptESXChacha20, resolverPK, 42, now.Add(-time.Hour), now.Add(time.Hour)), pub, true},
+		{"unknown version", testDNSCryptCert(priv, 3, resolverPK, 42, now.Add(-time.Hour), now.Add(time.Hour)), pub, false},
+		{"bad magic", badMagic, pub, false},
+		{"tampered", tampered, pub, false},
+		{"other provider", valid, otherPub, false},
+		{"no provider key", valid, nil, false},
+		{"expired", testDNSCryptCert(priv, dnscryptESXSalsa20, resolverPK, 42, now.Add(-2*time.Hour), now.Add(-time.Hour)), pub, false},
+		{"not yet valid", testDNSCryptCert(priv, dnscryptESXSalsa20, resolverPK, 42, now.Add(time.Hour), now.Add(2*time.Hour)), pub, false},
+		{"short", valid[:100], pub, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cert, err := parseDNSCryptCert(tc.b, tc.pk, now)
+			if (err == nil) != tc.ok {
+				t.Fatalf("got error %v, want ok=%t", err, tc.ok)
+			}
+			if err != nil {
+				return
+			}
+			if !bytes.Equal(cert.resolverPK[:], resolverPK) || string(cert.clientMagic[:]) != "magic123" || cert.serial != 42 {
+				t.Errorf("got certificate %+v", cert)
+			}
+		})
+	}
+}
+
+func TestDNSCryptPad(t *testing.T) {
+	for _, tc := range []struct {
+		len, minSize, want int
+	}{
+		{10, 0, 64},
+		{63, 0, 64},
+		{64, 0, 128},
+		{10, dnscryptMinQuerySize, dnscryptMinQuerySize},
+		{300, dnscryptMinQuerySize, 320},
+	} {
+		query := bytes.Repeat([]byte{0x80}, tc.len) // looks like padding
+		padded := dnscryptPad(query, tc.minSize)
+		if len(padded) != tc.want {
+			t.Errorf("%d bytes padded to %d, want %d", tc.len, len(padded), tc.want)
+		}
+		if got, ok := dnscryptUnpad(padded); !ok || !bytes.Equal(got, query) {
+			t.Errorf("%d bytes: unpadded to %d bytes, %t", tc.len, len(got), ok)
+		}
+	}
+	for _, b := range [][]byte{nil, make([]byte, 64), {1, 2, 3, 0}} {
+		if _, ok := dnscryptUnpad(b); ok {
+			t.Errorf("%x unpadded", b)
+		}
+	}
+}
+
+func TestDNSCryptSeal(t *testing.T) {
+	resolverPub, resolverPriv, err := box.GenerateKey(crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var clientPriv [32]byte
+	crand.Read(clientPriv[:])
+	clientPub, err := curve25519.X25519(clientPriv[:], curve25519.Basepoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nonce [24]byte
+	crand.Read(nonce[:])
+	message := []byte("query padded to some length")
+	for _, es := range []uint16{dnscryptESXSalsa20, dnscryptESXChacha20} {
+		cert := &dnscryptCert{esVersion: es, resolverPK: *resolverPub}
+		key, err := cert.sharedKey(&clientPriv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sealed := cert.seal([]byte("prefix"), message, &nonce, key)
+		if !bytes.HasPrefix(sealed, []byte("prefix")) {
+			t.Fatalf("version %d: seal does not append to out", es)
+		}
+		sealed = sealed[len("prefix"):]
+		if plain, ok := cert.open(sealed, &nonce, key); !ok || !bytes.Equal(plain, message) {
+			t.Errorf("version %d: open failed: %q, %t", es, plain, ok)
+		}
+		sealed[len(sealed)-1] ^= 1
+		if _, ok := cert.open(sealed, &nonce, key); ok {
+			t.Errorf("version %d: tampered box opened", es)
+		}
+		sealed[len(sealed)-1] ^= 1
+		if es != dnscryptESXSalsa20 {
+			continue
+		}
+		// resolver opens it with its private key
+		var pub [32]byte
+		copy(pub[:], clientPub)
+		if plain, ok := box.Open(nil, sealed, &nonce, &pub, resolverPriv); !ok || !bytes.Equal(plain, message) {
+			t.Errorf("resolver cannot open box: %q, %t", plain, ok)
+		}
+	}
+}
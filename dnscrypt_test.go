@@ -0,0 +1,194 @@
+package dot_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/artyom/dot"
+	"github.com/artyom/dot/dottest"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const dnscryptProvider = "2.dnscrypt-cert.example"
+
+// dnscryptServer is a DNSCrypt server using X25519-XSalsa20Poly1305,
+// answering queries with h. It publishes certificates of all keys, but
+// only accepts queries made with the one of the highest serial.
+type dnscryptServer struct {
+	pc       net.PacketConn
+	h        dot.Handler
+	certs    [][]byte
+	magic    [8]byte
+	priv     *[32]byte // resolver key of the current certificate
+	provider ed25519.PublicKey
+}
+
+// newDNSCryptServer starts server with certificates of given validity
+// periods, the last of which is the current one.
+func newDNSCryptServer(t *testing.T, h dot.Handler, validity ...[2]time.Time) *dnscryptServer {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	providerPub, providerPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &dnscryptServer{pc: pc, h: h, provider: providerPub}
+	for i, v := range validity {
+		pub, priv, err := box.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var magic [8]byte
+		rand.Read(magic[:])
+		signed := append(pub[:], magic[:]...)
+		signed = binary.BigEndian.AppendUint32(signed, uint32(i+1)) // serial
+		signed = binary.BigEndian.AppendUint32(signed, uint32(v[0].Unix()))
+		signed = binary.BigEndian.AppendUint32(signed, uint32(v[1].Unix()))
+		cert := append([]byte("DNSC\x00\x01\x00\x00"), ed25519.Sign(providerPriv, signed)...)
+		s.certs = append(s.certs, append(cert, signed...))
+		s.priv, s.magic = priv, magic
+	}
+	go s.serve()
+	return s
+}
+
+func (s *dnscryptServer) stamp() *dot.Stamp {
+	return &dot.Stamp{
+		Proto:        dot.StampDNSCrypt,
+		Addr:         s.pc.LocalAddr().String(),
+		PublicKey:    s.provider,
+		ProviderName: dnscryptProvider,
+	}
+}
+
+func (s *dnscryptServer) serve() {
+	buf := make([]byte, 4096)
+	for {
+		n, addr, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var resp []byte
+		if bytes.HasPrefix(buf[:n], s.magic[:]) {
+			resp = s.answer(buf[:n])
+		} else {
+			resp = s.certResponse(buf[:n])
+		}
+		if resp != nil {
+			s.pc.WriteTo(resp, addr)
+		}
+	}
+}
+
+// certResponse answers plain DNS query for certificates.
+func (s *dnscryptServer) certResponse(query []byte) []byte {
+	var q dnsmessage.Message
+	if err := q.Unpack(query); err != nil || len(q.Questions) != 1 ||
+		q.Questions[0].Type != dnsmessage.TypeTXT || q.Questions[0].Name.String() != dnscryptProvider+"." {
+		return nil
+	}
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: q.Header.ID, Response: true},
+		Questions: q.Questions,
+	}
+	for _, cert := range s.certs {
+		msg.Answers = append(msg.Answers, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: q.Questions[0].Name, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET, TTL: 60},
+			Body:   &dnsmessage.TXTResource{TXT: []string{string(cert)}},
+		})
+	}
+	b, _ := msg.Pack()
+	return b
+}
+
+// answer decrypts query, answers it with s.h and returns encrypted response.
+func (s *dnscryptServer) answer(msg []byte) []byte {
+	if len(msg) < 8+32+12+16 {
+		return nil
+	}
+	var clientPub [32]byte
+	var nonce [24]byte
+	copy(clientPub[:], msg[8:40])
+	copy(nonce[:], msg[40:52])
+	plain, ok := box.Open(nil, msg[52:], &nonce, &clientPub, s.priv)
+	if !ok || len(plain) < 256 || len(plain)%64 != 0 {
+		return nil
+	}
+	i := bytes.LastIndexByte(plain, 0x80)
+	if i < 0 || !bytes.Equal(plain[i+1:], make([]byte, len(plain)-i-1)) {
+		return nil
+	}
+	resp, err := s.h.ServeDNS(context.Background(), plain[:i])
+	if err != nil {
+		return nil
+	}
+	rand.Read(nonce[12:])
+	padded := append(resp, 0x80)
+	padded = append(padded, make([]byte, 63-len(resp)%64)...)
+	out := append([]byte{0x72, 0x36, 0x66, 0x6e, 0x76, 0x57, 0x6a, 0x38}, nonce[:]...)
+	return box.Seal(out, padded, &nonce, &clientPub, s.priv)
+}
+
+func TestDNSCryptClient(t *testing.T) {
+	now := time.Now()
+	current := [2]time.Time{now.Add(-time.Hour), now.Add(time.Hour)}
+	expired := [2]time.Time{now.Add(-2 * time.Hour), now.Add(-time.Hour)}
+	zone := dottest.Zone{"example.com": {"192.0.2.1"}}
+	for _, tc := range []struct {
+		name     string
+		validity [][2]time.Time
+		ok       bool
+	}{
+		{"single certificate", [][2]time.Time{current}, true},
+		{"highest serial wins", [][2]time.Time{current, current}, true},
+		{"expired", [][2]time.Time{expired}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newDNSCryptServer(t, zone, tc.validity...)
+			c := &dot.DNSCryptClient{Server: srv.stamp()}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			for range 2 { // second query reuses certificate
+				addrs, err := dot.NewResolver(c).LookupHost(ctx, "example.com")
+				if !tc.ok {
+					if err == nil {
+						t.Fatalf("lookup succeeded with %v", addrs)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(addrs) != 1 || addrs[0] != "192.0.2.1" {
+					t.Fatalf("got %v, want 192.0.2.1", addrs)
+				}
+			}
+		})
+	}
+}
+
+func TestDNSCryptClientWrongProvider(t *testing.T) {
+	now := time.Now()
+	srv := newDNSCryptServer(t, dottest.Zone{}, [2]time.Time{now.Add(-time.Hour), now.Add(time.Hour)})
+	st := srv.stamp()
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.PublicKey = other
+	c := &dot.DNSCryptClient{Server: st}
+	if _, err := serveQuery(t, c, "example.com", dnsmessage.TypeA); err == nil {
+		t.Fatal("query succeeded with certificate signed by another provider")
+	}
+}
@@ -0,0 +1,364 @@
+package dot
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DnstapRole selects kinds of dnstap messages Dnstap.Handler logs, as
+// defined by dnstap Message.Type.
+type DnstapRole uint8
+
+const (
+	// DnstapClient messages are queries received from clients and
+	// responses sent back to them, use it for Handler of Proxy, Server or
+	// DoHHandler. Such messages carry client addresses.
+	DnstapClient DnstapRole = 5
+
+	// DnstapForwarder messages are queries forwarded upstream and
+	// responses received, e.g. by Proxy.
+	DnstapForwarder DnstapRole = 7
+
+	// DnstapStub messages are queries sent upstream by a stub resolver and
+	// responses received, e.g. by a program using NewResolver.
+	DnstapStub DnstapRole = 9
+)
+
+// dnstapContentType is the Frame Streams content type of dnstap data.
+const dnstapContentType = "protobuf:dnstap.Dnstap"
+
+// Frame Streams control frame types.
+const (
+	fstrmAccept = 1
+	fstrmStart  = 2
+	fstrmStop   = 3
+	fstrmReady  = 4
+	fstrmFinish = 5
+
+	fstrmFieldContentType = 1
+)
+
+// dnstapQueue is the number of messages Dnstap buffers before it starts
+// dropping them.
+const dnstapQueue = 1024
+
+// Dnstap logs DNS messages in dnstap format (https://dnstap.info) as a Frame
+// Streams, for collection and analysis by tools like dnstap-read or
+// dnscollector:
+//
+//	tap, err := dot.DialDnstap("unix", "/var/run/dnstap.sock")
+//	if err != nil {
+//		return err
+//	}
+//	defer tap.Close()
+//	p := &dot.Proxy{
+//		Handler: tap.Handler(dot.DnstapClient,
+//			dot.NewCache(tap.Handler(dot.DnstapForwarder, dot.Forward(dot.Quad9())), 1000)),
+//	}
+//
+// Messages are written in background, so that slow collector does not slow
+// down queries; once its buffer is full, Dnstap drops new messages and
+// counts them.
+//
+// Dnstap is safe for concurrent use.
+type Dnstap struct {
+	// Identity and Version, if set, are added to every message, as dnstap
+	// identity and version fields. They must not be changed once Dnstap is
+	// used.
+	Identity, Version string
+
+	queue   chan []byte
+	done    chan struct{}
+	dropped atomic.Uint64
+	err     error // set by writer loop before done is closed
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewDnstap returns Dnstap writing unidirectional Frame Streams to w, e.g. a
+// file. Close writes the final frame and closes w if it is an io.Closer. If
+// writing to w fails, w is closed and all subsequent messages are dropped.
+func NewDnstap(w io.Writer) (*Dnstap, error) {
+	if err := writeControl(w, fstrmStart, true); err != nil {
+		return nil, err
+	}
+	d := newDnstap()
+	go d.loop(w, nil, func(w io.Writer) error {
+		err := writeControl(w, fstrmStop, false)
+		if c, ok := w.(io.Closer); ok {
+			if err2 := c.Close(); err == nil {
+				err = err2
+			}
+		}
+		return err
+	})
+	return d, nil
+}
+
+// DialDnstap returns Dnstap writing bidirectional Frame Streams to
+// collector listening on given network address, usually a "unix" socket. If
+// connection breaks, Dnstap re-establishes it, dropping messages until it
+// succeeds. DialDnstap returns an error if initial connection or handshake
+// fail.
+func DialDnstap(network, address string) (*Dnstap, error) {
+	dial := func() (io.Writer, error) {
+		conn, err := net.DialTimeout(network, address, 5*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if err := fstrmHandshake(conn); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("dot: dnstap handshake: %w", err)
+		}
+		conn.SetDeadline(time.Time{})
+		return conn, nil
+	}
+	w, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	d := newDnstap()
+	go d.loop(w, dial, func(w io.Writer) error {
+		conn := w.(net.Conn)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if err := writeControl(conn, fstrmStop, false); err != nil {
+			return err
+		}
+		typ, err := readControl(conn)
+		if err == nil && typ != fstrmFinish {
+			err = fmt.Errorf("dot: dnstap: unexpected control frame type %d", typ)
+		}
+		return err
+	})
+	return d, nil
+}
+
+func newDnstap() *Dnstap {
+	return &Dnstap{
+		queue: make(chan []byte, dnstapQueue),
+		done:  make(chan struct{}),
+	}
+}
+
+// Dropped returns the number of messages dropped because writing them did
+// not keep up, or failed.
+func (d *Dnstap) Dropped() uint64 { return d.dropped.Load() }
+
+// Close writes messages still buffered and closes the stream, returning
+// the error that stopped writing, if any. Messages logged after Close are
+// dropped.
+func (d *Dnstap) Close() error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+	}
+	d.mu.Unlock()
+	<-d.done
+	return d.err
+}
+
+// Handler returns Handler that passes queries to next, logging them and
+// their responses as messages of given role. Queries that next fails to
+// answer are logged without responses.
+func (d *Dnstap) Handler(role DnstapRole, next Handler) Handler {
+	if next == nil {
+		panic("dot: nil Handler")
+	}
+	return HandlerFunc(func(ctx context.Context, query []byte) ([]byte, error) {
+		m := dnstapMessage{typ: uint8(role), queryTime: time.Now(), query: query}
+		if role == DnstapClient {
+			m.client, _ = clientFrom(ctx)
+		}
+		d.log(&m)
+		resp, err := next.ServeDNS(ctx, query)
+		if err != nil || resp == nil {
+			return resp, err
+		}
+		m.typ++
+		m.responseTime = time.Now()
+		m.query, m.response = nil, resp
+		d.log(&m)
+		return resp, nil
+	})
+}
+
+// log encodes m and queues it for writing.
+func (d *Dnstap) log(m *dnstapMessage) {
+	frame := m.append(make([]byte, 4, 64+len(m.query)+len(m.response)), d.Identity, d.Version)
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		d.dropped.Add(1)
+		return
+	}
+	select {
+	case d.queue <- frame:
+	default:
+		d.dropped.Add(1)
+	}
+}
+
+// loop writes queued frames to w. If writing fails and reopen is not nil,
+// it is used to get a new writer, at most once a second; frames are dropped
+// meanwhile. Once queue is closed, finish is called with the current writer,
+// if any.
+func (d *Dnstap) loop(w io.Writer, reopen func() (io.Writer, error), finish func(io.Writer) error) {
+	defer close(d.done)
+	var lastOpen time.Time
+	for frame := range d.queue {
+		if w == nil {
+			if reopen == nil || time.Since(lastOpen) < time.Second {
+				d.dropped.Add(1)
+				continue
+			}
+			lastOpen = time.Now()
+			var err error
+			if w, err = reopen(); err != nil {
+				d.err = err
+				d.dropped.Add(1)
+				continue
+			}
+			d.err = nil
+		}
+		if _, err := w.Write(frame); err != nil {
+			d.err = err
+			d.dropped.Add(1)
+			if c, ok := w.(io.Closer); ok {
+				c.Close()
+			}
+			w = nil
+		}
+	}
+	if w != nil {
+		d.err = finish(w)
+	}
+}
+
+// fstrmHandshake performs bidirectional Frame Streams handshake over
+// conn: READY, ACCEPT, and START.
+func fstrmHandshake(conn net.Conn) error {
+	if err := writeControl(conn, fstrmReady, true); err != nil {
+		return err
+	}
+	typ, err := readControl(conn)
+	if err != nil {
+		return err
+	}
+	if typ != fstrmAccept {
+		return fmt.Errorf("unexpected control frame type %d", typ)
+	}
+	return writeControl(conn, fstrmStart, true)
+}
+
+// writeControl writes Frame Streams control frame of type typ, with dnstap
+// content type field if withType is true.
+func writeControl(w io.Writer, typ uint32, withType bool) error {
+	b := binary.BigEndian.AppendUint32(nil, 0) // escape
+	b = binary.BigEndian.AppendUint32(b, 0)    // placeholder for length
+	b = binary.BigEndian.AppendUint32(b, typ)
+	if withType {
+		b = binary.BigEndian.AppendUint32(b, fstrmFieldContentType)
+		b = binary.BigEndian.AppendUint32(b, uint32(len(dnstapContentType)))
+		b = append(b, dnstapContentType...)
+	}
+	binary.BigEndian.PutUint32(b[4:], uint32(len(b)-8))
+	_, err := w.Write(b)
+	return err
+}
+
+// readControl reads Frame Streams control frame and returns its type.
+func readControl(r io.Reader) (uint32, error) {
+	var hdr [12]byte
+	if _, err := io.ReadFull(r, hdr[:8]); err != nil {
+		return 0, err
+	}
+	n := binary.BigEndian.Uint32(hdr[4:])
+	if binary.BigEndian.Uint32(hdr[:4]) != 0 || n < 4 || n > 512 {
+		return 0, errors.New("malformed control frame")
+	}
+	if _, err := io.ReadFull(r, hdr[8:]); err != nil {
+		return 0, err
+	}
+	if _, err := io.CopyN(io.Discard, r, int64(n-4)); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(hdr[8:]), nil
+}
+
+// dnstapMessage is the part of dnstap Message Dnstap logs.
+type dnstapMessage struct {
+	typ          uint8
+	client       clientInfo
+	queryTime    time.Time
+	responseTime time.Time
+	query        []byte
+	response     []byte
+}
+
+// append appends m encoded as dnstap protobuf message to b.
+func (m *dnstapMessage) append(b []byte, identity, version string) []byte {
+	var msg []byte
+	msg = pbVarint(msg, 1, uint64(m.typ))
+	if addr, ok := m.client.addr.(interface{ AddrPort() netip.AddrPort }); ok {
+		ap := addr.AddrPort()
+		ip := ap.Addr().Unmap()
+		family := uint64(1)
+		if ip.Is6() {
+			family = 2
+		}
+		msg = pbVarint(msg, 2, family)
+		msg = pbVarint(msg, 3, uint64(m.client.proto))
+		msg = pbBytes(msg, 4, ip.AsSlice())
+		msg = pbVarint(msg, 6, uint64(ap.Port()))
+	}
+	msg = pbVarint(msg, 8, uint64(m.queryTime.Unix()))
+	msg = pbFixed32(msg, 9, uint32(m.queryTime.Nanosecond()))
+	if m.query != nil {
+		msg = pbBytes(msg, 10, m.query)
+	}
+	if m.response != nil {
+		msg = pbVarint(msg, 12, uint64(m.responseTime.Unix()))
+		msg = pbFixed32(msg, 13, uint32(m.responseTime.Nanosecond()))
+		msg = pbBytes(msg, 14, m.response)
+	}
+	if identity != "" {
+		b = pbBytes(b, 1, []byte(identity))
+	}
+	if version != "" {
+		b = pbBytes(b, 2, []byte(version))
+	}
+	b = pbBytes(b, 14, msg)
+	return pbVarint(b, 15, 1) // type: MESSAGE
+}
+
+// pbVarint appends protobuf varint field.
+func pbVarint(b []byte, field int, v uint64) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3)
+	return binary.AppendUvarint(b, v)
+}
+
+// pbFixed32 appends protobuf fixed32 field.
+func pbFixed32(b []byte, field int, v uint32) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|5)
+	return binary.LittleEndian.AppendUint32(b, v)
+}
+
+// pbBytes appends protobuf length-delimited field.
+func pbBytes(b []byte, field int, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
@@ -0,0 +1,261 @@
+package dot
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// pbField is a decoded protobuf field; v holds varint and fixed32 values,
+// b holds length-delimited ones.
+type pbField struct {
+	num, wire int
+	v         uint64
+	b         []byte
+}
+
+// decodePB decodes protobuf message into its fields keyed by field number,
+// supporting only wire types dnstap uses.
+func decodePB(t *testing.T, b []byte) map[int]pbField {
+	t.Helper()
+	out := make(map[int]pbField)
+	for len(b) != 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatalf("malformed field key in %x", b)
+		}
+		b = b[n:]
+		f := pbField{num: int(key >> 3), wire: int(key & 7)}
+		switch f.wire {
+		case 0:
+			if f.v, n = binary.Uvarint(b); n <= 0 {
+				t.Fatalf("malformed varint field %d", f.num)
+			}
+			b = b[n:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				t.Fatalf("malformed length-delimited field %d", f.num)
+			}
+			f.b, b = b[n:n+int(l)], b[n+int(l):]
+		case 5:
+			if len(b) < 4 {
+				t.Fatalf("malformed fixed32 field %d", f.num)
+			}
+			f.v, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		default:
+			t.Fatalf("unexpected wire type %d of field %d", f.wire, f.num)
+		}
+		if _, dup := out[f.num]; dup {
+			t.Fatalf("field %d repeated", f.num)
+		}
+		out[f.num] = f
+	}
+	return out
+}
+
+// fstrmFrame is a Frame Streams frame: either control frame of type
+// control, or data frame.
+type fstrmFrame struct {
+	control     uint32
+	contentType string
+	data        []byte
+}
+
+// readFrame reads a single Frame Streams frame from r.
+func readFrame(r io.Reader) (fstrmFrame, error) {
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return fstrmFrame{}, err
+	}
+	if n != 0 {
+		data := make([]byte, n)
+		_, err := io.ReadFull(r, data)
+		return fstrmFrame{data: data}, err
+	}
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return fstrmFrame{}, err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return fstrmFrame{}, err
+	}
+	if len(b) < 4 {
+		return fstrmFrame{}, errors.New("short control frame")
+	}
+	f := fstrmFrame{control: binary.BigEndian.Uint32(b)}
+	for b = b[4:]; len(b) >= 8; {
+		typ, l := binary.BigEndian.Uint32(b), binary.BigEndian.Uint32(b[4:])
+		if uint32(len(b)-8) < l {
+			return fstrmFrame{}, errors.New("malformed control field")
+		}
+		if typ == fstrmFieldContentType {
+			f.contentType = string(b[8 : 8+l])
+		}
+		b = b[8+l:]
+	}
+	return f, nil
+}
+
+func TestDnstapMessages(t *testing.T) {
+	query, response := []byte("query bytes"), []byte("response bytes")
+	failing := HandlerFunc(func(context.Context, []byte) ([]byte, error) { return nil, errors.New("upstream failure") })
+	answering := HandlerFunc(func(context.Context, []byte) ([]byte, error) { return response, nil })
+	udp4 := clientInfo{addr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5353}, proto: protoUDP}
+	dot6 := clientInfo{addr: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 42853}, proto: protoDoT}
+	for _, tc := range []struct {
+		name   string
+		role   DnstapRole
+		client *clientInfo
+		next   Handler
+		family uint64 // 0 if message must carry no address
+		ip     net.IP
+		types  []uint64 // of logged messages
+	}{
+		{name: "client udp4", role: DnstapClient, client: &udp4, next: answering, family: 1, ip: net.IPv4(192, 0, 2, 1).To4(), types: []uint64{5, 6}},
+		{name: "client dot6", role: DnstapClient, client: &dot6, next: answering, family: 2, ip: net.ParseIP("2001:db8::1"), types: []uint64{5, 6}},
+		{name: "client unknown", role: DnstapClient, next: answering, types: []uint64{5, 6}},
+		{name: "forwarder", role: DnstapForwarder, client: &udp4, next: answering, types: []uint64{7, 8}},
+		{name: "stub failing", role: DnstapStub, next: failing, types: []uint64{9}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			d, err := NewDnstap(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d.Identity, d.Version = "test-host", "dot test"
+			ctx := context.Background()
+			if tc.client != nil {
+				ctx = context.WithValue(ctx, clientKey{}, *tc.client)
+			}
+			begin := time.Now()
+			d.Handler(tc.role, tc.next).ServeDNS(ctx, query)
+			if err := d.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			r := bytes.NewReader(buf.Bytes())
+			if f, err := readFrame(r); err != nil || f.control != fstrmStart || f.contentType != dnstapContentType {
+				t.Fatalf("got first frame %+v, %v, want START with dnstap content type", f, err)
+			}
+			for i, typ := range tc.types {
+				f, err := readFrame(r)
+				if err != nil || f.data == nil {
+					t.Fatalf("data frame #%d: got %+v, %v", i+1, f, err)
+				}
+				frame := decodePB(t, f.data)
+				if string(frame[1].b) != "test-host" || string(frame[2].b) != "dot test" || frame[15].v != 1 {
+					t.Errorf("got dnstap frame identity %q, version %q, type %d", frame[1].b, frame[2].b, frame[15].v)
+				}
+				msg := decodePB(t, frame[14].b)
+				if msg[1].v != typ {
+					t.Errorf("message #%d: got type %d, want %d", i+1, msg[1].v, typ)
+				}
+				if tc.family == 0 {
+					for _, num := range []int{2, 3, 4, 6} {
+						if _, ok := msg[num]; ok {
+							t.Errorf("message #%d: has address field %d", i+1, num)
+						}
+					}
+				} else if msg[2].v != tc.family || msg[3].v != uint64(tc.client.proto) ||
+					!net.IP(msg[4].b).Equal(tc.ip) || len(msg[4].b) != len(tc.ip) {
+					t.Errorf("message #%d: got family %d, protocol %d, address %x", i+1, msg[2].v, msg[3].v, msg[4].b)
+				}
+				if ts := time.Unix(int64(msg[8].v), int64(msg[9].v)); ts.Before(begin.Truncate(time.Second)) || msg[9].wire != 5 {
+					t.Errorf("message #%d: got query time %v", i+1, ts)
+				}
+				isResponse := typ%2 == 0
+				if isResponse {
+					if !bytes.Equal(msg[14].b, response) || msg[13].wire != 5 {
+						t.Errorf("response #%d: got message %q", i+1, msg[14].b)
+					}
+					if _, ok := msg[10]; ok {
+						t.Errorf("response #%d: carries query", i+1)
+					}
+				} else if !bytes.Equal(msg[10].b, query) {
+					t.Errorf("query #%d: got message %q", i+1, msg[10].b)
+				}
+			}
+			if f, err := readFrame(r); err != nil || f.control != fstrmStop {
+				t.Fatalf("got last frame %+v, %v, want STOP", f, err)
+			}
+			if r.Len() != 0 {
+				t.Errorf("%d bytes after STOP frame", r.Len())
+			}
+		})
+	}
+}
+
+func TestDialDnstap(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "dnstap.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	type result struct {
+		frames int
+		err    error
+	}
+	results := make(chan result, 1)
+	go func() {
+		// collector side of bidirectional handshake
+		res := func() result {
+			conn, err := l.Accept()
+			if err != nil {
+				return result{err: err}
+			}
+			defer conn.Close()
+			if f, err := readFrame(conn); err != nil || f.control != fstrmReady || f.contentType != dnstapContentType {
+				return result{err: errors.New("no READY frame")}
+			}
+			if err := writeControl(conn, fstrmAccept, true); err != nil {
+				return result{err: err}
+			}
+			if f, err := readFrame(conn); err != nil || f.control != fstrmStart {
+				return result{err: errors.New("no START frame")}
+			}
+			var n int
+			for {
+				f, err := readFrame(conn)
+				if err != nil {
+					return result{n, err}
+				}
+				if f.data != nil {
+					n++
+					continue
+				}
+				if f.control != fstrmStop {
+					return result{n, errors.New("unexpected control frame")}
+				}
+				return result{n, writeControl(conn, fstrmFinish, false)}
+			}
+		}()
+		results <- res
+	}()
+	d, err := DialDnstap("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := d.Handler(DnstapStub, HandlerFunc(func(context.Context, []byte) ([]byte, error) { return []byte("response"), nil }))
+	for range 3 {
+		h.ServeDNS(context.Background(), []byte("query"))
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if res := <-results; res.err != nil || res.frames != 6 {
+		t.Errorf("collector got %d frames, %v; want 6", res.frames, res.err)
+	}
+	h.ServeDNS(context.Background(), []byte("query"))
+	if n := d.Dropped(); n != 2 {
+		t.Errorf("got %d messages dropped after Close, want 2", n)
+	}
+}
@@ -0,0 +1,145 @@
+package dot
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dohMediaType is the media type of DNS-over-HTTPS messages, see RFC 8484,
+// section 6.
+const dohMediaType = "application/dns-message"
+
+// DoHHandler is an http.Handler serving DNS-over-HTTPS requests as described
+// in RFC 8484. It accepts both GET and POST requests, answering queries with
+// Handler, or forwarding them to Resolver if Handler is nil.
+//
+// Serve it with http.Server over TLS to get HTTP/2 support:
+//
+//	mux := http.NewServeMux()
+//	mux.Handle("/dns-query", &dot.DoHHandler{Resolver: dot.Quad9()})
+//	log.Fatal(http.ListenAndServeTLS(":443", "cert.pem", "key.pem", mux))
+type DoHHandler struct {
+	// Handler answers queries. If nil, queries are forwarded to Resolver.
+	Handler Handler
+
+	// Resolver is used to forward queries if Handler is nil. It must be
+	// the one returned by one of this package's functions, or otherwise
+	// have its Dial field set to a function returning stream connections.
+	Resolver *net.Resolver
+
+	// Timeout limits how long handling a single query may take, 5 seconds
+	// if zero. Queries that fail or time out are answered with SERVFAIL.
+	Timeout time.Duration
+
+	// ErrorLog specifies an optional logger for handler errors. If nil,
+	// errors are not logged.
+	ErrorLog *log.Logger
+}
+
+// ServeHTTP implements http.Handler. Successful responses carry Cache-Control
+// header with max-age set to the smallest TTL found in the DNS response.
+func (h *DoHHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var query []byte
+	switch r.Method {
+	case http.MethodGet:
+		var err error
+		if query, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns")); err != nil || len(query) == 0 {
+			http.Error(w, "missing or malformed dns parameter", http.StatusBadRequest)
+			return
+		}
+	case http.MethodPost:
+		if r.Header.Get("Content-Type") != dohMediaType {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		var err error
+		if query, err = io.ReadAll(io.LimitReader(r.Body, maxMsgSize+1)); err != nil {
+			http.Error(w, "failed to read request body", http.StatusBadRequest)
+			return
+		}
+		if len(query) > maxMsgSize {
+			http.Error(w, "query too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	handler := h.Handler
+	if handler == nil {
+		handler = Forward(h.Resolver)
+	}
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	if addr, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		ctx = context.WithValue(ctx, clientKey{}, clientInfo{addr: net.TCPAddrFromAddrPort(addr), proto: protoDoH})
+	}
+	resp := handleQuery(ctx, handler, query, h.logf)
+	if resp == nil {
+		http.Error(w, "malformed query", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", dohMediaType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(resp)))
+	if ttl, ok := minTTL(resp); ok {
+		w.Header().Set("Cache-Control", "max-age="+strconv.FormatUint(uint64(ttl), 10))
+	}
+	w.Write(resp)
+}
+
+func (h *DoHHandler) logf(format string, args ...interface{}) {
+	if h.ErrorLog != nil {
+		h.ErrorLog.Printf(format, args...)
+	}
+}
+
+// minTTL returns the smallest TTL of resource records in msg, ignoring the
+// OPT pseudo-record. It returns false if msg has no records to take TTL from.
+func minTTL(msg []byte) (uint32, bool) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(msg); err != nil {
+		return 0, false
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return 0, false
+	}
+	var ttl uint32
+	var found bool
+	sections := []struct {
+		header func() (dnsmessage.ResourceHeader, error)
+		skip   func() error
+	}{
+		{p.AnswerHeader, p.SkipAnswer},
+		{p.AuthorityHeader, p.SkipAuthority},
+		{p.AdditionalHeader, p.SkipAdditional},
+	}
+	for _, sec := range sections {
+		for {
+			h, err := sec.header()
+			if err != nil {
+				break
+			}
+			if h.Type != dnsmessage.TypeOPT && (!found || h.TTL < ttl) {
+				ttl, found = h.TTL, true
+			}
+			if err := sec.skip(); err != nil {
+				return ttl, found
+			}
+		}
+	}
+	return ttl, found
+}
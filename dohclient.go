@@ -0,0 +1,70 @@
+package dot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// DoHClient is a Handler sending queries to DNS-over-HTTPS service as
+// described in RFC 8484, using POST requests:
+//
+//	r := dot.NewResolver(&dot.DoHClient{URL: "https://dns.quad9.net/dns-query"})
+//
+// Under GOOS=js it is what Resolver returned by New uses, since browsers only
+// allow HTTP requests through the Fetch API.
+//
+// DoHClient is safe for concurrent use, its fields must not be changed once
+// it is used.
+type DoHClient struct {
+	// URL is the URI template of the service without variables, such as
+	// "https://dns.google/dns-query".
+	URL string
+
+	// HTTPClient is used to make requests, http.DefaultClient if nil. Use
+	// Transport if its host names should not be resolved in cleartext.
+	HTTPClient *http.Client
+}
+
+// ServeDNS implements Handler interface.
+func (c *DoHClient) ServeDNS(ctx context.Context, query []byte) ([]byte, error) {
+	if len(query) < 12 {
+		return nil, errors.New("dot: query too short")
+	}
+	// RFC 8484, section 4.1: use zero ID to make requests cacheable
+	msg := append([]byte(nil), query...)
+	msg[0], msg[1] = 0, 0
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(msg))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohMediaType)
+	req.Header.Set("Accept", dohMediaType)
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("dot: doh request failed: %s", resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != dohMediaType {
+		return nil, fmt.Errorf("dot: doh response has unexpected content type %q", ct)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxMsgSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < 12 || len(body) > maxMsgSize {
+		return nil, errors.New("dot: malformed doh response")
+	}
+	body[0], body[1] = query[0], query[1]
+	return body, nil
+}
@@ -4,6 +4,7 @@ package dot
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"math/rand"
 	"net"
 	"time"
@@ -13,56 +14,174 @@ import (
 // 1.0.0.1 on port 853.
 //
 // See https://developers.cloudflare.com/1.1.1.1/dns-over-tls/ for details.
-func Cloudflare() *net.Resolver {
-	return newResolver("cloudflare-dns.com", "1.1.1.1:853", "1.0.0.1:853")
+func Cloudflare(opts ...Option) *net.Resolver {
+	return newResolver("cloudflare-dns.com", []string{"1.1.1.1:853", "1.0.0.1:853"}, opts)
 }
 
 // Quad9 returns Resolver that uses Quad9 service on 9.9.9.9 and 149.112.112.112
 // on port 853.
 //
 // See https://quad9.net/faq/ for details.
-func Quad9() *net.Resolver {
-	return newResolver("dns.quad9.net", "9.9.9.9:853", "149.112.112.112:853")
+func Quad9(opts ...Option) *net.Resolver {
+	return newResolver("dns.quad9.net", []string{"9.9.9.9:853", "149.112.112.112:853"}, opts)
 }
 
 // Google returns Resolver that uses Google Public DNS service on 8.8.8.8 and
 // 8.8.4.4 on port 853.
 //
 // See https://developers.google.com/speed/public-dns/ for details.
-func Google() *net.Resolver {
-	return newResolver("dns.google", "8.8.8.8:853", "8.8.4.4:853")
+func Google(opts ...Option) *net.Resolver {
+	return newResolver("dns.google", []string{"8.8.8.8:853", "8.8.4.4:853"}, opts)
 }
 
 // LibreOps returns Resolver that uses LibreDNS service on 116.202.176.26 on
 // port 853 operated by LibreOps.
 //
 // See https://libredns.gr/ for details.
-func LibreOps() *net.Resolver {
-	return newResolver("dot.libredns.gr", "116.202.176.26:853")
+func LibreOps(opts ...Option) *net.Resolver {
+	return newResolver("dot.libredns.gr", []string{"116.202.176.26:853"}, opts)
 }
 
-func newResolver(serverName string, addrs ...string) *net.Resolver {
+// New returns Resolver that uses DNS-over-TLS service reachable on given
+// addresses, verifying that its certificate is valid for serverName. Each
+// address must be in the host:port form, the service port is usually 853.
+// If more than one address is given, a random one is picked for every new
+// connection.
+//
+// Under GOOS=js, where programs cannot establish TCP connections, Resolver
+// uses DNS-over-HTTPS service at https://serverName/dns-query with
+// DoHClient instead, which built-in providers all run; addrs and opts have no
+// effect then.
+//
+// New panics if serverName or addrs are empty, and reports other problems
+// with them only once it dials upstream. Use NewChecked to build Resolver
+// from user-supplied configuration: it returns an error instead.
+func New(serverName string, addrs []string, opts ...Option) *net.Resolver {
+	return newResolver(serverName, addrs, opts)
+}
+
+// DialFunc returns function establishing DNS-over-TLS connections to service
+// reachable on given addresses, as used by Resolver returned by New. Use it to
+// plug DNS-over-TLS into net.Resolver configured differently, or into other
+// libraries expecting such dial function:
+//
+//	r := &net.Resolver{
+//		PreferGo:     true,
+//		StrictErrors: true,
+//		Dial:         dot.DialFunc("dns.example.com", []string{"192.0.2.1:853"}),
+//	}
+//
+// Returned function ignores its network and address arguments. Resolver using
+// it must have PreferGo set to true. DialFunc panics if serverName or addrs are
+// empty; check them with Validate first if they come from user-supplied
+// configuration.
+func DialFunc(serverName string, addrs []string, opts ...Option) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return newDialFunc(serverName, addrs, newConfig(opts))
+}
+
+func newDialFunc(serverName string, addrs []string, c config) func(ctx context.Context, network, address string) (net.Conn, error) {
 	if serverName == "" {
 		panic("dot: server name cannot be empty")
 	}
 	if len(addrs) == 0 {
 		panic("dot: addrs cannot be empty")
 	}
+	pick := c.pick
+	if pick == nil {
+		pick = func(addrs []string) string { return addrs[rand.Intn(len(addrs))] }
+	}
+	addrs = append([]string(nil), addrs...)
+	if c.port != "" {
+		for i, addr := range addrs {
+			if host, _, err := net.SplitHostPort(addr); err == nil {
+				addrs[i] = net.JoinHostPort(host, c.port)
+			}
+		}
+	}
 	var d net.Dialer
+	if c.fastOpen {
+		d.Control = setFastOpen
+	}
+	dial := d.DialContext
+	if c.dialer != nil {
+		dial = c.dialer
+	}
 	cfg := &tls.Config{
 		ServerName:         serverName,
 		ClientSessionCache: tls.NewLRUClientSessionCache(0),
+		RootCAs:            c.rootCAs,
+	}
+	switch c.postQuantum {
+	case pqPreferred:
+		cfg.CurvePreferences = append(postQuantumCurves[:len(postQuantumCurves):len(postQuantumCurves)],
+			tls.X25519, tls.CurveP256, tls.CurveP384)
+	case pqRequired:
+		cfg.MinVersion = tls.VersionTLS13
+		cfg.CurvePreferences = postQuantumCurves
+	}
+	if c.fips {
+		cfg.MinVersion = tls.VersionTLS12
+		cfg.CipherSuites = fipsCipherSuites
+		cfg.CurvePreferences = []tls.CurveID{tls.CurveP256, tls.CurveP384}
 	}
-	return &net.Resolver{
-		PreferGo: true,
-		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-			conn, err := d.DialContext(ctx, "tcp", addrs[rand.Intn(len(addrs))])
-			if err != nil {
-				return nil, err
+	if c.sessionCache != nil {
+		cfg.ClientSessionCache = c.sessionCache
+	}
+	if c.noResumption {
+		cfg.ClientSessionCache = nil
+		cfg.SessionTicketsDisabled = true
+	}
+	verifiers := c.verifiers
+	if c.fips {
+		verifiers = append(verifiers[:len(verifiers):len(verifiers)], verifyFIPS)
+	}
+	if len(c.handshakeHooks) != 0 || len(verifiers) != 0 {
+		hooks := c.handshakeHooks
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, fn := range verifiers {
+				if err := fn(cs); err != nil {
+					return err
+				}
 			}
-			conn.(*net.TCPConn).SetKeepAlive(true)
-			conn.(*net.TCPConn).SetKeepAlivePeriod(3 * time.Minute)
-			return tls.Client(conn, cfg), nil
-		},
+			for _, fn := range hooks {
+				fn(cs)
+			}
+			return nil
+		}
+	}
+	timeout := c.timeout
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		if c.postQuantum == pqRequired && c.fips {
+			return nil, errors.New("dot: post-quantum key exchange cannot be required in FIPS mode")
+		}
+		if c.postQuantum == pqRequired && len(postQuantumCurves) == 0 {
+			return nil, errors.New("dot: post-quantum key exchange requires Go 1.24 or newer")
+		}
+		var deadline time.Time
+		if _, ok := ctx.Deadline(); !ok && timeout > 0 {
+			deadline = time.Now().Add(timeout)
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, deadline)
+			defer cancel()
+		}
+		addr, ok := upstreamFrom(ctx)
+		if !ok {
+			addr = pick(addrs)
+		}
+		conn, err := dial(ctx, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		if tc, ok := conn.(*net.TCPConn); ok {
+			tc.SetKeepAlive(true)
+			tc.SetKeepAlivePeriod(3 * time.Minute)
+		}
+		if !deadline.IsZero() {
+			conn.SetDeadline(deadline)
+		}
+		if c.tlsClient != nil {
+			return c.tlsClient(conn, cfg), nil
+		}
+		return tls.Client(conn, cfg), nil
 	}
 }
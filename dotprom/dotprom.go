@@ -0,0 +1,104 @@
+// Package dotprom exports metrics of github.com/artyom/dot clients, caches
+// and latency recorders to Prometheus.
+//
+// Collector reads metrics from the values it is given on every scrape, so
+// nothing needs to be wired into the query path beyond what package dot
+// already records:
+//
+//	rec := dot.NewLatencyRecorder()
+//	client := dot.NewClient("dns.quad9.net", []string{"9.9.9.9:853"})
+//	cache := dot.NewCache(rec.Handler("quad9", client), 10000)
+//	prometheus.MustRegister(&dotprom.Collector{
+//		Latency: rec,
+//		Clients: map[string]*dot.Client{"quad9": client},
+//		Caches:  map[string]*dot.Cache{"default": cache},
+//	})
+//
+// Package dotprom is a separate module, so that programs using package dot
+// do not depend on the Prometheus client library.
+package dotprom
+
+import (
+	"strings"
+
+	"github.com/artyom/dot"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is a prometheus.Collector exporting metrics of package dot
+// values. Any of its fields may be nil. Fields must not be modified once
+// Collector is registered.
+type Collector struct {
+	// Latency provides query counts, errors and latency histograms, labeled
+	// by provider name and query type.
+	Latency *dot.LatencyRecorder
+
+	// Clients provide connection pool metrics, labeled by map keys.
+	Clients map[string]*dot.Client
+
+	// Caches provide cache hit metrics, labeled by map keys.
+	Caches map[string]*dot.Cache
+}
+
+var (
+	queryDuration = prometheus.NewDesc("dot_query_duration_seconds",
+		"Time it took to answer DNS queries.", []string{"provider", "type"}, nil)
+	queryErrors = prometheus.NewDesc("dot_query_errors_total",
+		"Number of DNS queries that failed.", []string{"provider", "type"}, nil)
+	clientConns = prometheus.NewDesc("dot_client_connections",
+		"Number of open pooled connections.", []string{"client"}, nil)
+	clientInflight = prometheus.NewDesc("dot_client_queries_inflight",
+		"Number of queries in flight.", []string{"client"}, nil)
+	clientQueries = prometheus.NewDesc("dot_client_queries_total",
+		"Number of queries completed, successfully or not.", []string{"client"}, nil)
+	clientFailures = prometheus.NewDesc("dot_client_failures_total",
+		"Number of queries that got no response.", []string{"client"}, nil)
+	clientDials = prometheus.NewDesc("dot_client_dials_total",
+		"Number of connections established.", []string{"client"}, nil)
+	cacheHits = prometheus.NewDesc("dot_cache_hits_total",
+		"Number of queries answered from cache.", []string{"cache"}, nil)
+	cacheMisses = prometheus.NewDesc("dot_cache_misses_total",
+		"Number of cacheable queries passed upstream.", []string{"cache"}, nil)
+	cacheEntries = prometheus.NewDesc("dot_cache_entries",
+		"Number of responses currently cached.", []string{"cache"}, nil)
+)
+
+// Describe implements prometheus.Collector interface.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{queryDuration, queryErrors,
+		clientConns, clientInflight, clientQueries, clientFailures, clientDials, cacheHits, cacheMisses, cacheEntries} {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector interface. Query counts are
+// exported as the count of dot_query_duration_seconds histogram.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	if c.Latency != nil {
+		for _, s := range c.Latency.Snapshot() {
+			buckets := make(map[float64]uint64, len(dot.HistogramBuckets))
+			var n uint64
+			for i, bound := range dot.HistogramBuckets {
+				n += s.Buckets[i]
+				buckets[bound.Seconds()] = n
+			}
+			typ := strings.TrimPrefix(s.Type.String(), "Type") // "A", not "TypeA"
+			ch <- prometheus.MustNewConstHistogram(queryDuration, s.Count, s.Sum.Seconds(), buckets, s.Provider, typ)
+			ch <- prometheus.MustNewConstMetric(queryErrors, prometheus.CounterValue, float64(s.Errors), s.Provider, typ)
+		}
+	}
+	for name, client := range c.Clients {
+		st := client.Stats()
+		ch <- prometheus.MustNewConstMetric(clientConns, prometheus.GaugeValue, float64(st.Conns), name)
+		ch <- prometheus.MustNewConstMetric(clientInflight, prometheus.GaugeValue, float64(st.Inflight), name)
+		ch <- prometheus.MustNewConstMetric(clientQueries, prometheus.CounterValue, float64(st.Queries), name)
+		ch <- prometheus.MustNewConstMetric(clientFailures, prometheus.CounterValue, float64(st.Failures), name)
+		ch <- prometheus.MustNewConstMetric(clientDials, prometheus.CounterValue, float64(st.Dials), name)
+	}
+	for name, cache := range c.Caches {
+		st := cache.Stats()
+		ch <- prometheus.MustNewConstMetric(cacheHits, prometheus.CounterValue, float64(st.Hits), name)
+		ch <- prometheus.MustNewConstMetric(cacheMisses, prometheus.CounterValue, float64(st.Misses), name)
+		ch <- prometheus.MustNewConstMetric(cacheEntries, prometheus.GaugeValue, float64(st.Len), name)
+	}
+}
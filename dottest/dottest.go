@@ -0,0 +1,345 @@
+// Package dottest provides utilities for testing code that uses DNS-over-TLS
+// resolvers: a local DoT server with scripted answers and fault injection,
+// and Resolver pointed at it.
+//
+//	srv := dottest.NewServer(dottest.Zone{"example.com": {"192.0.2.1"}})
+//	defer srv.Close()
+//	addrs, err := srv.Resolver().LookupHost(ctx, "example.com")
+package dottest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/artyom/dot"
+)
+
+// ServerName is the TLS server name certificates of test servers are issued
+// for.
+const ServerName = "dot.test"
+
+// Fault describes how Server misbehaves when handling a query.
+type Fault int
+
+const (
+	// NoFault makes Server answer query normally.
+	NoFault Fault = iota
+	// Drop makes Server read query and never answer it.
+	Drop
+	// Reset makes Server abruptly reset connection after reading query.
+	Reset
+	// Garbage makes Server answer with bytes that are not a valid DNS
+	// message.
+	Garbage
+)
+
+// Cert selects certificate Server presents.
+type Cert int
+
+const (
+	// ValidCert is valid for ServerName and trusted by Resolver.
+	ValidCert Cert = iota
+	// ExpiredCert is trusted by Resolver, but has expired.
+	ExpiredCert
+	// WrongNameCert is trusted by Resolver, but issued for another name.
+	WrongNameCert
+	// UntrustedCert is valid for ServerName, but signed by a certificate
+	// authority Resolver does not trust.
+	UntrustedCert
+)
+
+// Server is a DNS-over-TLS server listening on a loopback address, for use in
+// end-to-end tests.
+type Server struct {
+	// Addr is the address server listens on, in the host:port form. It is
+	// set once server is started.
+	Addr string
+
+	// Handler answers queries.
+	Handler dot.Handler
+
+	// Delay, if positive, postpones every response by given duration.
+	Delay time.Duration
+
+	// Fault, if set, is called for every query to decide whether server
+	// should misbehave handling it. It may be called concurrently.
+	Fault func(query []byte) Fault
+
+	// Cert selects certificate server presents, ValidCert by default.
+	Cert Cert
+
+	// TLS, if set, is the base TLS configuration of server, e.g. to limit
+	// protocol versions or cipher suites it accepts. Start sets its
+	// Certificates and NextProtos fields.
+	TLS *tls.Config
+
+	l     net.Listener
+	roots *x509.CertPool
+	wg    sync.WaitGroup
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// NewServer starts and returns a new Server answering queries with h. The
+// caller should call Close when finished, to shut it down.
+func NewServer(h dot.Handler) *Server {
+	s := NewUnstartedServer(h)
+	s.Start()
+	return s
+}
+
+// NewUnstartedServer returns a new Server answering queries with h, but does
+// not start it. After changing its configuration, the caller should call
+// Start.
+func NewUnstartedServer(h dot.Handler) *Server {
+	return &Server{Handler: h}
+}
+
+// Start starts server. It panics if server is already started, or if it
+// cannot listen on a loopback address.
+func (s *Server) Start() {
+	if s.l != nil {
+		panic("dottest: server already started")
+	}
+	ca, caKey := newCA()
+	s.roots = x509.NewCertPool()
+	s.roots.AddCert(ca)
+	notBefore, notAfter := time.Now().Add(-time.Hour), time.Now().Add(24*time.Hour)
+	name := ServerName
+	signer, signerKey := ca, caKey
+	switch s.Cert {
+	case ExpiredCert:
+		notBefore, notAfter = time.Now().Add(-48*time.Hour), time.Now().Add(-24*time.Hour)
+	case WrongNameCert:
+		name = "wrong." + ServerName
+	case UntrustedCert:
+		signer, signerKey = newCA()
+	}
+	cert := newLeaf(name, notBefore, notAfter, signer, signerKey)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("dottest: failed to listen: %v", err))
+	}
+	s.Addr = l.Addr().String()
+	cfg := &tls.Config{}
+	if s.TLS != nil {
+		cfg = s.TLS.Clone()
+	}
+	cfg.Certificates = []tls.Certificate{cert}
+	cfg.NextProtos = []string{"dot"}
+	s.l = tls.NewListener(l, cfg)
+	s.conns = make(map[net.Conn]struct{})
+	s.wg.Add(1)
+	go s.serve()
+}
+
+// Resolver returns Resolver using server as its upstream, trusting its
+// certificate authority. Options are passed to dot.New.
+func (s *Server) Resolver(opts ...dot.Option) *net.Resolver {
+	if s.l == nil {
+		panic("dottest: server not started")
+	}
+	opts = append([]dot.Option{dot.WithRootCAs(s.roots)}, opts...)
+	return dot.New(ServerName, []string{s.Addr}, opts...)
+}
+
+// RootCAs returns pool with certificate authority Resolver trusts, for
+// constructing custom resolvers.
+func (s *Server) RootCAs() *x509.CertPool { return s.roots }
+
+// CloseClientConnections closes all currently open client connections.
+func (s *Server) CloseClientConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+// Close shuts down server and blocks until all its connections are closed.
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	if s.l != nil {
+		s.l.Close()
+	}
+	s.CloseClientConnections()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel() // runs before wg.Wait, unblocking delayed responses
+	var wmu sync.Mutex
+	for {
+		query, err := readMsg(conn)
+		if err != nil {
+			return
+		}
+		var fault Fault
+		if s.Fault != nil {
+			fault = s.Fault(query)
+		}
+		switch fault {
+		case Drop:
+			continue
+		case Reset:
+			if tc, ok := conn.(*tls.Conn); ok {
+				if c, ok := tc.NetConn().(*net.TCPConn); ok {
+					c.SetLinger(0)
+					c.Close() // bypass TLS close_notify alert
+				}
+			}
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var resp []byte
+			if fault == Garbage {
+				resp = []byte("garbage")
+			} else {
+				var err error
+				if resp, err = s.Handler.ServeDNS(ctx, query); err != nil || resp == nil {
+					return
+				}
+			}
+			if s.Delay > 0 {
+				t := time.NewTimer(s.Delay)
+				defer t.Stop()
+				select {
+				case <-t.C:
+				case <-ctx.Done():
+					return
+				}
+			}
+			wmu.Lock()
+			defer wmu.Unlock()
+			writeMsg(conn, resp)
+		}()
+	}
+}
+
+func readMsg(r io.Reader) ([]byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(hdr[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func writeMsg(w io.Writer, msg []byte) error {
+	if len(msg) > 65535 {
+		return errors.New("dottest: message too large")
+	}
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	_, err := w.Write(buf)
+	return err
+}
+
+func newCA() (*x509.Certificate, *ecdsa.PrivateKey) {
+	key := newKey()
+	tmpl := &x509.Certificate{
+		SerialNumber:          newSerial(),
+		Subject:               pkix.Name{CommonName: "dottest CA"},
+		NotBefore:             time.Now().Add(-72 * time.Hour),
+		NotAfter:              time.Now().Add(72 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		panic(fmt.Sprintf("dottest: creating CA certificate: %v", err))
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(fmt.Sprintf("dottest: parsing CA certificate: %v", err))
+	}
+	return cert, key
+}
+
+func newLeaf(name string, notBefore, notAfter time.Time, ca *x509.Certificate, caKey *ecdsa.PrivateKey) tls.Certificate {
+	key := newKey()
+	tmpl := &x509.Certificate{
+		SerialNumber: newSerial(),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		panic(fmt.Sprintf("dottest: creating certificate: %v", err))
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func newKey() *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(fmt.Sprintf("dottest: generating key: %v", err))
+	}
+	return key
+}
+
+func newSerial() *big.Int {
+	n, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		panic(fmt.Sprintf("dottest: generating serial number: %v", err))
+	}
+	return n
+}
@@ -0,0 +1,170 @@
+package dottest_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/artyom/dot"
+	"github.com/artyom/dot/dottest"
+)
+
+func TestZone(t *testing.T) {
+	srv := dottest.NewServer(dottest.Zone{
+		"example.com":   {"192.0.2.1", "2001:db8::1"},
+		"Example.ORG.":  {"192.0.2.2"},
+		"invalid.entry": {"not an address"},
+	})
+	defer srv.Close()
+	r := srv.Resolver()
+	for _, tc := range []struct {
+		network, host string
+		want          []string
+		notFound      bool
+	}{
+		{network: "ip", host: "example.com", want: []string{"192.0.2.1", "2001:db8::1"}},
+		{network: "ip4", host: "example.com", want: []string{"192.0.2.1"}},
+		{network: "ip6", host: "example.com", want: []string{"2001:db8::1"}},
+		{network: "ip", host: "example.org", want: []string{"192.0.2.2"}},
+		{network: "ip", host: "EXAMPLE.org.", want: []string{"192.0.2.2"}},
+		{network: "ip", host: "missing.example.com", notFound: true},
+		{network: "ip", host: "invalid.entry", notFound: true},
+	} {
+		t.Run(tc.network+" "+tc.host, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			ips, err := r.LookupIP(ctx, tc.network, tc.host)
+			if tc.notFound {
+				var dnsErr *net.DNSError
+				if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+					t.Fatalf("got %v, %v; want not found error", ips, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, ip := range ips {
+				got = append(got, ip.String())
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tc.want) {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestServerCert(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		cert  dottest.Cert
+		check func(error) bool
+	}{
+		{name: "valid", cert: dottest.ValidCert, check: func(err error) bool { return err == nil }},
+		{name: "expired", cert: dottest.ExpiredCert, check: func(err error) bool {
+			var e x509.CertificateInvalidError
+			return errors.As(err, &e) && e.Reason == x509.Expired
+		}},
+		{name: "wrong name", cert: dottest.WrongNameCert, check: func(err error) bool {
+			var e x509.HostnameError
+			return errors.As(err, &e)
+		}},
+		{name: "untrusted", cert: dottest.UntrustedCert, check: func(err error) bool {
+			var e x509.UnknownAuthorityError
+			return errors.As(err, &e)
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := dottest.NewUnstartedServer(dottest.Zone{"example.com": {"192.0.2.1"}})
+			srv.Cert = tc.cert
+			srv.Start()
+			defer srv.Close()
+			c := dot.NewClient(dottest.ServerName, []string{srv.Addr}, dot.WithRootCAs(srv.RootCAs()))
+			defer c.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if _, err := c.Exchange(ctx, newQuery(t)); !tc.check(err) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestServerFault(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		fault dottest.Fault
+	}{
+		{name: "drop", fault: dottest.Drop},
+		{name: "reset", fault: dottest.Reset},
+		{name: "garbage", fault: dottest.Garbage},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := dottest.NewUnstartedServer(dottest.Zone{"example.com": {"192.0.2.1"}})
+			srv.Fault = func([]byte) dottest.Fault { return tc.fault }
+			srv.Start()
+			defer srv.Close()
+			c := dot.NewClient(dottest.ServerName, []string{srv.Addr}, dot.WithRootCAs(srv.RootCAs()))
+			defer c.Close()
+			query := newQuery(t)
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+			if resp, err := c.Exchange(ctx, query); err == nil {
+				t.Fatalf("got response %q, want error", resp)
+			}
+		})
+	}
+}
+
+func TestServerDelay(t *testing.T) {
+	const delay = 200 * time.Millisecond
+	srv := dottest.NewUnstartedServer(dottest.Zone{"example.com": {"192.0.2.1"}})
+	srv.Delay = delay
+	srv.Start()
+	defer srv.Close()
+	c := dot.NewClient(dottest.ServerName, []string{srv.Addr}, dot.WithRootCAs(srv.RootCAs()))
+	defer c.Close()
+	begin := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := c.Exchange(ctx, newQuery(t)); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(begin); took < delay {
+		t.Fatalf("response took %v, want at least %v", took, delay)
+	}
+}
+
+func TestServerTLS(t *testing.T) {
+	srv := dottest.NewUnstartedServer(dottest.Zone{"example.com": {"192.0.2.1"}})
+	srv.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	srv.Start()
+	defer srv.Close()
+	var version uint16
+	r := srv.Resolver(dot.WithHandshakeHook(func(cs tls.ConnectionState) { version = cs.Version }))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := r.LookupIP(ctx, "ip4", "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if version != tls.VersionTLS12 {
+		t.Fatalf("negotiated %s, want TLS 1.2", tls.VersionName(version))
+	}
+}
+
+// newQuery returns A query for example.com.
+func newQuery(t *testing.T) []byte {
+	t.Helper()
+	return []byte{
+		0x12, 0x34, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+		0x00, 0x01, 0x00, 0x01,
+	}
+}
@@ -0,0 +1,78 @@
+package dottest
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Zone is a dot.Handler answering A and AAAA queries with addresses from the
+// map, keyed by names; trailing dot is optional and case is ignored. Queries
+// for names missing from the map are answered with NXDOMAIN, queries of other
+// types with an empty answer. Answers have TTL of 60 seconds.
+type Zone map[string][]string
+
+// ServeDNS implements dot.Handler interface.
+func (z Zone) ServeDNS(ctx context.Context, query []byte) ([]byte, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil, err
+	}
+	h.Response = true
+	h.Authoritative = true
+	h.RecursionAvailable = true
+	addrs, ok := z.lookup(q.Name.String())
+	if !ok {
+		h.RCode = dnsmessage.RCodeNameError
+	}
+	b := dnsmessage.NewBuilder(nil, h)
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(q); err != nil {
+		return nil, err
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	rh := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 60}
+	for _, s := range addrs {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			continue
+		}
+		ip4 := ip.To4()
+		switch {
+		case q.Type == dnsmessage.TypeA && ip4 != nil:
+			var r dnsmessage.AResource
+			copy(r.A[:], ip4)
+			err = b.AResource(rh, r)
+		case q.Type == dnsmessage.TypeAAAA && ip4 == nil:
+			var r dnsmessage.AAAAResource
+			copy(r.AAAA[:], ip)
+			err = b.AAAAResource(rh, r)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+func (z Zone) lookup(name string) ([]string, bool) {
+	name = strings.TrimSuffix(name, ".")
+	for k, v := range z {
+		if strings.EqualFold(strings.TrimSuffix(k, "."), name) {
+			return v, true
+		}
+	}
+	return nil, false
+}
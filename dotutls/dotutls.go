@@ -0,0 +1,131 @@
+// Package dotutls makes github.com/artyom/dot resolvers do TLS handshakes
+// with uTLS, mimicking ClientHello of a popular browser, for networks that
+// fingerprint and block the Go default one on connections to well-known
+// DNS-over-TLS services:
+//
+//	r := dot.Cloudflare(dotutls.WithFingerprint(utls.HelloChrome_Auto))
+//
+// It pulls in uTLS and its dependencies, so it is a separate module, and
+// programs using package dot alone do not depend on them.
+//
+// Fingerprint dictates protocol versions, cipher suites and key exchanges,
+// so WithPostQuantum and WithFIPS options have no effect with it.
+package dotutls
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+
+	"github.com/artyom/dot"
+	utls "github.com/refraction-networking/utls"
+)
+
+// WithFingerprint returns dot.Option making Resolver or Client send
+// ClientHello identified by id, such as utls.HelloChrome_Auto or
+// utls.HelloFirefox_Auto, with "dot" added to the protocols it offers with
+// ALPN. Upstream certificates are verified as usual, handshake hooks are
+// called with connection state converted to the crypto/tls one. Resolvers
+// created with the same Option share TLS session cache.
+func WithFingerprint(id utls.ClientHelloID) dot.Option {
+	cache := utls.NewLRUClientSessionCache(0)
+	return dot.WithTLSClient(func(conn net.Conn, cfg *tls.Config) net.Conn {
+		ucfg := &utls.Config{
+			ServerName:             cfg.ServerName,
+			RootCAs:                cfg.RootCAs,
+			SessionTicketsDisabled: cfg.SessionTicketsDisabled,
+
+			// browser fingerprints may not have TLS 1.3 pre-shared
+			// key extension, do full handshakes then
+			PreferSkipResumptionOnNilExtension: true,
+		}
+		if cfg.ClientSessionCache != nil {
+			ucfg.ClientSessionCache = cache
+		}
+		c := &uconn{UConn: utls.UClient(conn, ucfg, utls.HelloCustom), verify: cfg.VerifyConnection}
+		spec, err := utls.UTLSIdToSpec(id)
+		if err == nil {
+			offerDoT(&spec)
+			err = c.ApplyPreset(&spec)
+		}
+		if err != nil {
+			c.done, c.err = true, err
+		}
+		return c
+	})
+}
+
+// alpnProto is the ALPN protocol ID of DNS-over-TLS.
+const alpnProto = "dot"
+
+// offerDoT adds DNS-over-TLS to protocols spec offers with ALPN, after the
+// browser ones, so that upstreams requiring ALPN agreement accept the
+// handshake.
+func offerDoT(spec *utls.ClientHelloSpec) {
+	for _, ext := range spec.Extensions {
+		if alpn, ok := ext.(*utls.ALPNExtension); ok {
+			alpn.AlpnProtocols = append(alpn.AlpnProtocols, alpnProto)
+		}
+	}
+}
+
+// uconn makes utls.UConn look like tls.Conn to package dot: it does the
+// handshake before the first read or write, and reports crypto/tls
+// connection state.
+type uconn struct {
+	*utls.UConn
+	verify func(tls.ConnectionState) error
+
+	mu   sync.Mutex
+	done bool
+	err  error // handshake error
+}
+
+func (c *uconn) HandshakeContext(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done {
+		return c.err
+	}
+	c.done = true
+	c.err = c.UConn.HandshakeContext(ctx)
+	if c.err == nil && c.verify != nil {
+		c.err = c.verify(c.ConnectionState())
+	}
+	if c.err != nil {
+		c.UConn.Close()
+	}
+	return c.err
+}
+
+func (c *uconn) Read(b []byte) (int, error) {
+	if err := c.HandshakeContext(context.Background()); err != nil {
+		return 0, err
+	}
+	return c.UConn.Read(b)
+}
+
+func (c *uconn) Write(b []byte) (int, error) {
+	if err := c.HandshakeContext(context.Background()); err != nil {
+		return 0, err
+	}
+	return c.UConn.Write(b)
+}
+
+// ConnectionState returns connection state converted to the crypto/tls one.
+func (c *uconn) ConnectionState() tls.ConnectionState {
+	st := c.UConn.ConnectionState()
+	return tls.ConnectionState{
+		Version:                     st.Version,
+		HandshakeComplete:           st.HandshakeComplete,
+		DidResume:                   st.DidResume,
+		CipherSuite:                 st.CipherSuite,
+		NegotiatedProtocol:          st.NegotiatedProtocol,
+		ServerName:                  st.ServerName,
+		PeerCertificates:            st.PeerCertificates,
+		VerifiedChains:              st.VerifiedChains,
+		SignedCertificateTimestamps: st.SignedCertificateTimestamps,
+		OCSPResponse:                st.OCSPResponse,
+	}
+}
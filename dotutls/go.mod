@@ -0,0 +1,17 @@
+module github.com/artyom/dot/dotutls
+
+go 1.22
+
+require (
+	github.com/artyom/dot v0.0.0-20261014160754-0a91af886364
+	github.com/refraction-networking/utls v1.6.7
+)
+
+require (
+	github.com/andybalholm/brotli v1.0.6 // indirect
+	github.com/cloudflare/circl v1.3.7 // indirect
+	github.com/klauspost/compress v1.17.9 // indirect
+	golang.org/x/crypto v0.33.0 // indirect
+	golang.org/x/net v0.35.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+)
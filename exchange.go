@@ -0,0 +1,277 @@
+package dot
+
+import (
+	"context"
+	crand "crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// maxMsgSize is the largest DNS message that can be framed over a stream
+// connection.
+const maxMsgSize = 65535
+
+// ednsSize is the UDP payload size advertised in queries this package
+// builds, as recommended by DNS Flag Day 2020.
+const ednsSize = 1232
+
+// exchange sends a single wire-format DNS query over a connection obtained
+// from r.Dial and returns the wire-format response.
+func exchange(ctx context.Context, r *net.Resolver, query []byte) ([]byte, error) {
+	if r == nil || r.Dial == nil {
+		return nil, errors.New("dot: resolver has no Dial function")
+	}
+	conn, err := r.Dial(ctx, "tcp", "")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if d, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(d)
+	}
+	stop := closeOnDone(ctx, conn)
+	defer stop()
+	if err := writeMsg(conn, query); err != nil {
+		return nil, err
+	}
+	return readMsg(conn)
+}
+
+// tlsConn is implemented by *tls.Conn, and by connections returned by
+// functions passed to WithTLSClient that expose their TLS state.
+type tlsConn interface {
+	HandshakeContext(context.Context) error
+	ConnectionState() tls.ConnectionState
+}
+
+// exchangeResult describes a single query sent over a fresh connection.
+type exchangeResult struct {
+	resp      []byte
+	remote    net.Addr
+	tls       *tls.ConnectionState // nil if connection is not a TLS one
+	handshake time.Duration        // establishing connection, including TLS handshake
+	query     time.Duration        // sending query and reading response
+}
+
+// timedExchange is like exchange, but it completes TLS handshake before
+// sending query, so that handshake and query can be timed separately. It
+// also checks that response ID matches query. Once connection is dialed, it
+// returns non-nil result even on error, filled as far as exchange got.
+func timedExchange(ctx context.Context, r *net.Resolver, query []byte) (*exchangeResult, error) {
+	if r == nil || r.Dial == nil {
+		return nil, errors.New("dot: resolver has no Dial function")
+	}
+	begin := time.Now()
+	conn, err := r.Dial(ctx, "tcp", "")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if d, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(d)
+	}
+	stop := closeOnDone(ctx, conn)
+	defer stop()
+	res := &exchangeResult{remote: conn.RemoteAddr()}
+	if tc, ok := conn.(tlsConn); ok {
+		if err := tc.HandshakeContext(ctx); err != nil {
+			return res, err
+		}
+		st := tc.ConnectionState()
+		res.tls = &st
+	}
+	res.handshake = time.Since(begin)
+	begin = time.Now()
+	if err := writeMsg(conn, query); err != nil {
+		return res, err
+	}
+	resp, err := readMsg(conn)
+	if err != nil {
+		return res, err
+	}
+	res.query = time.Since(begin)
+	if len(resp) < 2 || len(query) < 2 || resp[0] != query[0] || resp[1] != query[1] {
+		return res, errors.New("dot: response id does not match query id")
+	}
+	res.resp = resp
+	return res, nil
+}
+
+// closeOnDone arranges for conn to be unblocked once ctx is done. Returned
+// function must be called to release associated resources.
+func closeOnDone(ctx context.Context, conn net.Conn) (stop func()) {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// writeMsg writes DNS message to stream connection prefixed with its
+// two-byte length as described in RFC 1035, section 4.2.2.
+func writeMsg(w io.Writer, msg []byte) error {
+	if len(msg) > maxMsgSize {
+		return errors.New("dot: message too large")
+	}
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	_, err := w.Write(buf)
+	return err
+}
+
+// readMsg reads single length-prefixed DNS message from stream connection.
+func readMsg(r io.Reader) ([]byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(hdr[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// errorResponse builds a response to query carrying given rcode and no
+// records. It returns nil if query cannot be parsed.
+func errorResponse(query []byte, rcode dnsmessage.RCode) []byte {
+	return headerOnly(query, func(h *dnsmessage.Header) {
+		h.Response = true
+		h.RecursionAvailable = true
+		h.Authoritative = false
+		h.Truncated = false
+		h.RCode = rcode
+	})
+}
+
+// answerResponse builds response to query with header h and question q,
+// carrying given rcode and answers, as if it came from an authoritative
+// source.
+func answerResponse(h dnsmessage.Header, q dnsmessage.Question, rcode dnsmessage.RCode, answers []dnsmessage.Resource) ([]byte, error) {
+	h.Response = true
+	h.Authoritative = true
+	h.RecursionAvailable = true
+	h.Truncated = false
+	h.RCode = rcode
+	msg := dnsmessage.Message{
+		Header:    h,
+		Questions: []dnsmessage.Question{q},
+		Answers:   answers,
+	}
+	return msg.Pack()
+}
+
+// udpSize returns the maximum UDP response size client who sent query can
+// handle, as advertised by the EDNS(0) OPT record, or 512 if there's none.
+func udpSize(query []byte) int {
+	const minSize = 512
+	var p dnsmessage.Parser
+	if _, err := p.Start(query); err != nil {
+		return minSize
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return minSize
+	}
+	if err := p.SkipAllAnswers(); err != nil {
+		return minSize
+	}
+	if err := p.SkipAllAuthorities(); err != nil {
+		return minSize
+	}
+	for {
+		h, err := p.AdditionalHeader()
+		if err != nil {
+			return minSize
+		}
+		if h.Type == dnsmessage.TypeOPT {
+			if size := int(h.Class); size > minSize {
+				return size
+			}
+			return minSize
+		}
+		if err := p.SkipAdditional(); err != nil {
+			return minSize
+		}
+	}
+}
+
+// truncate returns the response reduced to its header and question section
+// with the TC bit set, signaling the client to retry over TCP. It returns nil
+// if resp cannot be parsed.
+func truncate(resp []byte) []byte {
+	return headerOnly(resp, func(h *dnsmessage.Header) { h.Truncated = true })
+}
+
+// headerOnly returns a copy of msg stripped of everything but its header and
+// question section, with header modified by fn.
+func headerOnly(msg []byte, fn func(*dnsmessage.Header)) []byte {
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil {
+		return nil
+	}
+	qs, err := p.AllQuestions()
+	if err != nil {
+		return nil
+	}
+	fn(&h)
+	b := dnsmessage.NewBuilder(nil, h)
+	if err := b.StartQuestions(); err != nil {
+		return nil
+	}
+	for _, q := range qs {
+		if err := b.Question(q); err != nil {
+			return nil
+		}
+	}
+	out, err := b.Finish()
+	if err != nil {
+		return nil
+	}
+	return out
+}
+
+// newQuery returns wire-format recursive query for name of given type with a
+// random ID. Query has EDNS(0) OPT record, so that upstream may include
+// extended DNS errors (RFC 8914) in response.
+func newQuery(name string, qtype dnsmessage.Type) ([]byte, error) {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	n, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+	var id [2]byte
+	if _, err := crand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:               binary.BigEndian.Uint16(id[:]),
+			RecursionDesired: true,
+		},
+		Questions: []dnsmessage.Question{{Name: n, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(ednsSize, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, err
+	}
+	msg.Additionals = []dnsmessage.Resource{{Header: opt, Body: &dnsmessage.OPTResource{}}}
+	return msg.Pack()
+}
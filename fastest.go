@@ -0,0 +1,81 @@
+package dot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// probeNames are queried to measure providers in Fastest and FastestEvery.
+var probeNames = []string{"example.com", "example.net", "example.org"}
+
+// Fastest measures all built-in providers concurrently and returns Resolver of
+// the fastest healthy one: the one with the least failure rate and the
+// smallest median time to establish a connection and get an answer.
+func Fastest(ctx context.Context) (*net.Resolver, error) {
+	p, err := fastestProvider(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return p.Resolver(), nil
+}
+
+// FastestEvery is like Fastest, but it also re-measures providers every
+// interval in background, switching returned Resolver to the currently
+// fastest one. If re-measurement finds no healthy providers, Resolver keeps
+// using the previously selected one. Background re-measurement stops once
+// ctx is canceled.
+func FastestEvery(ctx context.Context, interval time.Duration) (*net.Resolver, error) {
+	if interval <= 0 {
+		return nil, errors.New("dot: non-positive interval")
+	}
+	p, err := fastestProvider(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var cur atomic.Pointer[net.Resolver]
+	cur.Store(p.Resolver())
+	name := p.Name
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			p, err := fastestProvider(ctx)
+			if err != nil || p.Name == name {
+				continue
+			}
+			name = p.Name
+			cur.Store(p.Resolver())
+		}
+	}()
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return cur.Load().Dial(ctx, network, address)
+		},
+	}, nil
+}
+
+func fastestProvider(ctx context.Context) (Provider, error) {
+	resolvers := make(map[string]*net.Resolver, len(providers))
+	for _, p := range providers {
+		resolvers[p.Name] = p.Resolver()
+	}
+	ms, err := Measure(ctx, resolvers, probeNames)
+	if err != nil {
+		return Provider{}, err
+	}
+	if ms[0].Failures == ms[0].Attempts {
+		return Provider{}, fmt.Errorf("dot: no healthy providers, %s: %w", ms[0].Name, ms[0].Err)
+	}
+	p, _ := LookupProvider(ms[0].Name)
+	return p, nil
+}
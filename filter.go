@@ -0,0 +1,255 @@
+package dot
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// BlockMode defines how Filter answers queries for blocked names.
+type BlockMode int
+
+const (
+	// BlockNXDomain makes Filter answer queries for blocked names with
+	// NXDOMAIN, as if they did not exist.
+	BlockNXDomain BlockMode = iota
+	// BlockNullIP makes Filter answer A and AAAA queries for blocked names
+	// with an unspecified address, 0.0.0.0 or ::, and queries of other
+	// types with an empty answer. Some clients retry NXDOMAIN with search
+	// domains appended, this mode avoids such extra queries.
+	BlockNullIP
+)
+
+// Filter is a Handler answering queries for blocked names locally, so that
+// they never reach upstream, and passing other queries to another Handler.
+// Names are blocked by loading blocklists; allowlists take precedence over
+// them, making it possible to unblock names listed in third-party
+// blocklists.
+//
+// Lists may be in the hosts file format, where names following the address
+// on each line are listed, like in "0.0.0.0 ads.example.com", or have a
+// single name on each line. A name matches itself only; a name prefixed with
+// "*." matches its subdomains: "*.example.com" matches "ads.example.com" and
+// "a.b.example.com", but not "example.com". Empty lines and everything after
+// "#" are ignored.
+//
+// Filter can also apply policies of Response Policy Zones, see LoadRPZ.
+//
+// Filter is safe for concurrent use, lists may be loaded while it serves
+// queries.
+type Filter struct {
+	next Handler
+	mode BlockMode
+
+	mu    sync.RWMutex
+	block domainSet
+	allow domainSet
+	rpz   []*rpzZone
+}
+
+// NewFilter returns Filter wrapping next answering queries for blocked names
+// according to mode. Until blocklists are loaded, it blocks nothing.
+func NewFilter(next Handler, mode BlockMode) *Filter {
+	if next == nil {
+		panic("dot: nil Handler")
+	}
+	return &Filter{
+		next:  next,
+		mode:  mode,
+		block: newDomainSet(),
+		allow: newDomainSet(),
+	}
+}
+
+// Block loads blocklist from r, adding its names to already blocked ones.
+// Lines that cannot be parsed are skipped, only an error reading r is
+// returned.
+func (f *Filter) Block(r io.Reader) error {
+	names, err := parseDomainList(r)
+	if err != nil {
+		return err
+	}
+	f.BlockNames(names...)
+	return nil
+}
+
+// Allow loads allowlist from r, in the same format as Block.
+func (f *Filter) Allow(r io.Reader) error {
+	names, err := parseDomainList(r)
+	if err != nil {
+		return err
+	}
+	f.AllowNames(names...)
+	return nil
+}
+
+// BlockNames blocks given names, which may be prefixed with "*." to block
+// their subdomains.
+func (f *Filter) BlockNames(names ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, name := range names {
+		f.block.add(name)
+	}
+}
+
+// AllowNames allows given names, which may be prefixed with "*." to allow
+// their subdomains.
+func (f *Filter) AllowNames(names ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, name := range names {
+		f.allow.add(name)
+	}
+}
+
+// Blocked reports whether queries for name are blocked: either listed in
+// blocklists, or having RPZ policy other than PASSTHRU or TCP-Only.
+func (f *Filter) Blocked(name string) bool {
+	blocked, rule := f.policy(hostsKey(name))
+	if rule != nil {
+		return rule.action != rpzPassthru && rule.action != rpzTCPOnly
+	}
+	return blocked
+}
+
+// policy reports whether name is blocked by blocklists, or returns RPZ rule
+// that applies to it instead.
+func (f *Filter) policy(name string) (blocked bool, rule *rpzRule) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.allow.match(name) {
+		return false, nil
+	}
+	if rule := f.rpzMatch(name); rule != nil {
+		return false, rule
+	}
+	return f.block.match(name), nil
+}
+
+// ServeDNS implements Handler interface.
+func (f *Filter) ServeDNS(ctx context.Context, query []byte) ([]byte, error) {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(query)
+	if err != nil {
+		return f.next.ServeDNS(ctx, query)
+	}
+	q, err := p.Question()
+	if err != nil {
+		return f.next.ServeDNS(ctx, query)
+	}
+	blocked, rule := f.policy(hostsKey(q.Name.String()))
+	if rule != nil {
+		return f.applyRPZ(ctx, query, hdr, q, rule)
+	}
+	if !blocked {
+		return f.next.ServeDNS(ctx, query)
+	}
+	if f.mode != BlockNullIP {
+		return answerResponse(hdr, q, dnsmessage.RCodeNameError, nil)
+	}
+	rh := dnsmessage.ResourceHeader{Name: q.Name, Class: q.Class}
+	var answers []dnsmessage.Resource
+	switch q.Type {
+	case dnsmessage.TypeA:
+		answers = append(answers, dnsmessage.Resource{Header: rh, Body: &dnsmessage.AResource{}})
+	case dnsmessage.TypeAAAA:
+		answers = append(answers, dnsmessage.Resource{Header: rh, Body: &dnsmessage.AAAAResource{}})
+	}
+	return answerResponse(hdr, q, dnsmessage.RCodeSuccess, answers)
+}
+
+// domainSet holds names matching themselves, and wildcards matching
+// subdomains of their names. All names are lowercased and have no trailing
+// dot.
+type domainSet struct {
+	names     map[string]struct{}
+	wildcards map[string]struct{}
+}
+
+func newDomainSet() domainSet {
+	return domainSet{
+		names:     make(map[string]struct{}),
+		wildcards: make(map[string]struct{}),
+	}
+}
+
+func (s domainSet) add(name string) {
+	name = hostsKey(name)
+	if suffix, ok := strings.CutPrefix(name, "*."); ok {
+		if suffix != "" {
+			s.wildcards[suffix] = struct{}{}
+		}
+		return
+	}
+	if name != "" {
+		s.names[name] = struct{}{}
+	}
+}
+
+func (s domainSet) match(name string) bool {
+	if _, ok := s.names[name]; ok {
+		return true
+	}
+	if len(s.wildcards) == 0 {
+		return false
+	}
+	for {
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			return false
+		}
+		name = name[i+1:]
+		if _, ok := s.wildcards[name]; ok {
+			return true
+		}
+	}
+}
+
+// parseDomainList returns names listed in r, which is either in the hosts
+// file format or has a single name on each line.
+func parseDomainList(r io.Reader) ([]string, error) {
+	var names []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+			continue
+		case len(fields) == 1:
+			names = append(names, fields[0])
+			continue
+		}
+		if _, err := netip.ParseAddr(fields[0]); err != nil {
+			continue
+		}
+		for _, name := range fields[1:] {
+			if _, err := netip.ParseAddr(name); err == nil || isLocalhostName(name) {
+				// hosts-format blocklists usually start with
+				// entries like "127.0.0.1 localhost"
+				continue
+			}
+			names = append(names, name)
+		}
+	}
+	return names, sc.Err()
+}
+
+func isLocalhostName(name string) bool {
+	switch hostsKey(name) {
+	case "localhost", "localhost.localdomain", "local", "broadcasthost",
+		"ip6-localhost", "ip6-loopback", "ip6-localnet", "ip6-mcastprefix",
+		"ip6-allnodes", "ip6-allrouters", "ip6-allhosts":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+package dot_test
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/artyom/dot"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// upstreamAddr is the address answerHandler answers A queries with.
+var upstreamAddr = [4]byte{192, 0, 2, 1}
+
+// answerHandler returns Handler answering A queries with upstreamAddr and
+// queries of other types with an empty answer, counting queries in calls.
+func answerHandler(calls *atomic.Int32) dot.Handler {
+	return dot.HandlerFunc(func(_ context.Context, query []byte) ([]byte, error) {
+		calls.Add(1)
+		var q dnsmessage.Message
+		if err := q.Unpack(query); err != nil {
+			return nil, err
+		}
+		msg := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: q.Header.ID, Response: true, RecursionAvailable: true},
+			Questions: q.Questions,
+		}
+		if q.Questions[0].Type == dnsmessage.TypeA {
+			msg.Answers = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: q.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 300},
+				Body:   &dnsmessage.AResource{A: upstreamAddr},
+			}}
+		}
+		return msg.Pack()
+	})
+}
+
+// serveQuery sends h query of type typ for name and returns unpacked
+// response, or nil if h answered nothing, as it does for dropped queries.
+func serveQuery(t *testing.T, h dot.Handler, name string, typ dnsmessage.Type) (*dnsmessage.Message, error) {
+	t.Helper()
+	query := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: 0x1234, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  dnsmessage.MustNewName(name + "."),
+			Type:  typ,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	b, err := query.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := h.ServeDNS(context.Background(), b)
+	if err != nil || resp == nil {
+		return nil, err
+	}
+	msg := new(dnsmessage.Message)
+	if err := msg.Unpack(resp); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Header.ID != query.Header.ID {
+		t.Fatalf("response ID %d does not match query", msg.Header.ID)
+	}
+	return msg, nil
+}
+
+// answerAddrs returns addresses of A and AAAA records in msg answer section.
+func answerAddrs(msg *dnsmessage.Message) []netip.Addr {
+	var addrs []netip.Addr
+	for _, rr := range msg.Answers {
+		switch b := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			addrs = append(addrs, netip.AddrFrom4(b.A))
+		case *dnsmessage.AAAAResource:
+			addrs = append(addrs, netip.AddrFrom16(b.AAAA))
+		}
+	}
+	return addrs
+}
+
+const testBlocklist = `# comment
+127.0.0.1 localhost
+0.0.0.0 ads.example.com tracker.example.com # trailing comment
+::1 ip6-localhost
+
+Banner.Example.NET.
+*.doubleclick.example
+`
+
+func TestFilter(t *testing.T) {
+	var calls atomic.Int32
+	f := dot.NewFilter(answerHandler(&calls), dot.BlockNXDomain)
+	if err := f.Block(strings.NewReader(testBlocklist)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Allow(strings.NewReader("tracker.example.com\n*.ok.doubleclick.example\n")); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name    string
+		blocked bool
+	}{
+		{"ads.example.com", true},
+		{"ADS.example.com", true},
+		{"banner.example.net", true},
+		{"a.doubleclick.example", true},
+		{"a.b.doubleclick.example", true},
+		{"doubleclick.example", false}, // wildcards only match subdomains
+		{"tracker.example.com", false}, // allowed
+		{"x.ok.doubleclick.example", false},
+		{"www.ads.example.com", false},
+		{"example.com", false},
+		{"localhost", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := f.Blocked(tc.name); got != tc.blocked {
+				t.Errorf("Blocked reports %t, want %t", got, tc.blocked)
+			}
+			before := calls.Load()
+			msg, err := serveQuery(t, f, tc.name, dnsmessage.TypeA)
+			if err != nil {
+				t.Fatal(err)
+			}
+			passed := calls.Load() != before
+			if passed == tc.blocked {
+				t.Errorf("query passed upstream: %t, want %t", passed, !tc.blocked)
+			}
+			wantRCode := dnsmessage.RCodeSuccess
+			if tc.blocked {
+				wantRCode = dnsmessage.RCodeNameError
+			}
+			if msg.Header.RCode != wantRCode {
+				t.Errorf("got rcode %v, want %v", msg.Header.RCode, wantRCode)
+			}
+		})
+	}
+}
+
+func TestFilterNullIP(t *testing.T) {
+	var calls atomic.Int32
+	f := dot.NewFilter(answerHandler(&calls), dot.BlockNullIP)
+	f.BlockNames("ads.example.com")
+	for _, tc := range []struct {
+		typ  dnsmessage.Type
+		want []netip.Addr
+	}{
+		{dnsmessage.TypeA, []netip.Addr{netip.IPv4Unspecified()}},
+		{dnsmessage.TypeAAAA, []netip.Addr{netip.IPv6Unspecified()}},
+		{dnsmessage.TypeTXT, nil},
+	} {
+		t.Run(tc.typ.String(), func(t *testing.T) {
+			msg, err := serveQuery(t, f, "ads.example.com", tc.typ)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.Header.RCode != dnsmessage.RCodeSuccess {
+				t.Errorf("got rcode %v, want %v", msg.Header.RCode, dnsmessage.RCodeSuccess)
+			}
+			got := answerAddrs(msg)
+			if len(got) != len(tc.want) || len(got) != 0 && got[0] != tc.want[0] {
+				t.Errorf("got answer %v, want %v", got, tc.want)
+			}
+		})
+	}
+	if n := calls.Load(); n != 0 {
+		t.Errorf("upstream got %d queries for blocked name", n)
+	}
+}
@@ -0,0 +1,131 @@
+package dot
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ErrFiltered is matched by errors.Is for errors lookups return when upstream
+// answered with an address that indicates blocking rather than a real host,
+// or marked its response as blocked, censored or filtered.
+var ErrFiltered = errors.New("dot: answer filtered by provider")
+
+// FilteredError is returned by lookups whose answer was found to be blocked by
+// provider, so that applications do not try to connect to a sinkhole
+// address. Match it with errors.Is(err, ErrFiltered).
+type FilteredError struct {
+	Name   string
+	Reason string       // why answer is considered filtered
+	Addrs  []netip.Addr // sinkhole addresses answer had, if any
+}
+
+func (e *FilteredError) Error() string {
+	return fmt.Sprintf("dot: lookup %s: answer filtered by provider: %s", e.Name, e.Reason)
+}
+
+// Is reports whether target is ErrFiltered.
+func (e *FilteredError) Is(target error) bool { return target == ErrFiltered }
+
+// sinkholeAddrs lists addresses known to be returned by providers instead of
+// blocked names, besides the unspecified ones: block page addresses of
+// Cisco Umbrella (OpenDNS).
+var sinkholeAddrs = []netip.Addr{
+	netip.AddrFrom4([4]byte{146, 112, 61, 104}),
+	netip.AddrFrom4([4]byte{146, 112, 61, 105}),
+	netip.AddrFrom4([4]byte{146, 112, 61, 106}),
+	netip.AddrFrom4([4]byte{146, 112, 61, 107}),
+	netip.AddrFrom4([4]byte{146, 112, 61, 108}),
+	netip.AddrFrom4([4]byte{146, 112, 61, 110}),
+	netip.MustParseAddr("::ffff:146.112.61.104"),
+	netip.MustParseAddr("::ffff:146.112.61.105"),
+	netip.MustParseAddr("::ffff:146.112.61.106"),
+	netip.MustParseAddr("::ffff:146.112.61.107"),
+	netip.MustParseAddr("::ffff:146.112.61.108"),
+	netip.MustParseAddr("::ffff:146.112.61.110"),
+}
+
+// isSinkhole reports whether ip is an address providers answer blocked names
+// with.
+func isSinkhole(ip netip.Addr) bool {
+	if ip.IsUnspecified() {
+		return true
+	}
+	for _, v := range sinkholeAddrs {
+		if ip == v {
+			return true
+		}
+	}
+	return false
+}
+
+// sinkholeError returns *FilteredError if addrs is non-empty and all of its
+// addresses are sinkhole ones, and nil otherwise.
+func sinkholeError(name string, addrs []netip.Addr) error {
+	if len(addrs) == 0 {
+		return nil
+	}
+	for _, ip := range addrs {
+		if !isSinkhole(ip) {
+			return nil
+		}
+	}
+	return &FilteredError{
+		Name:   name,
+		Reason: fmt.Sprintf("sinkhole address %v", addrs[0]),
+		Addrs:  addrs,
+	}
+}
+
+// Extended DNS error codes indicating blocking, see RFC 8914, section 4.
+const (
+	edeOption   = 15
+	edeBlocked  = 15
+	edeCensored = 16
+	edeFiltered = 17
+)
+
+// filteredError returns *FilteredError if msg carries an extended DNS error
+// indicating blocking, or if it is an answer to A or AAAA query with sinkhole
+// addresses only. Otherwise it returns nil.
+func filteredError(name string, msg *dnsmessage.Message) error {
+	for _, rr := range msg.Additionals {
+		opt, ok := rr.Body.(*dnsmessage.OPTResource)
+		if !ok {
+			continue
+		}
+		for _, o := range opt.Options {
+			if o.Code != edeOption || len(o.Data) < 2 {
+				continue
+			}
+			code := uint16(o.Data[0])<<8 | uint16(o.Data[1])
+			var reason string
+			switch code {
+			case edeBlocked:
+				reason = "Blocked"
+			case edeCensored:
+				reason = "Censored"
+			case edeFiltered:
+				reason = "Filtered"
+			default:
+				continue
+			}
+			if text := o.Data[2:]; len(text) != 0 {
+				reason += ": " + string(text)
+			}
+			return &FilteredError{Name: name, Reason: reason}
+		}
+	}
+	var addrs []netip.Addr
+	for _, rr := range msg.Answers {
+		switch v := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			addrs = append(addrs, netip.AddrFrom4(v.A))
+		case *dnsmessage.AAAAResource:
+			addrs = append(addrs, netip.AddrFrom16(v.AAAA))
+		}
+	}
+	return sinkholeError(name, addrs)
+}
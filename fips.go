@@ -0,0 +1,31 @@
+package dot
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// fipsCipherSuites lists TLS 1.2 cipher suites WithFIPS allows.
+var fipsCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+// verifyFIPS fails handshakes that negotiated cipher suite not approved by
+// FIPS 140-3. tls.Config.CipherSuites has no effect on TLS 1.3, so this is
+// the only way to keep TLS_CHACHA20_POLY1305_SHA256 out outside of Go FIPS
+// 140-3 mode.
+func verifyFIPS(cs tls.ConnectionState) error {
+	switch cs.CipherSuite {
+	case tls.TLS_AES_128_GCM_SHA256, tls.TLS_AES_256_GCM_SHA384:
+		return nil
+	}
+	for _, id := range fipsCipherSuites {
+		if cs.CipherSuite == id {
+			return nil
+		}
+	}
+	return fmt.Errorf("dot: cipher suite %s is not approved by FIPS 140-3", tls.CipherSuiteName(cs.CipherSuite))
+}
@@ -0,0 +1,26 @@
+package dot
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestVerifyFIPS(t *testing.T) {
+	for _, tc := range []struct {
+		suite uint16
+		ok    bool
+	}{
+		{tls.TLS_AES_128_GCM_SHA256, true},
+		{tls.TLS_AES_256_GCM_SHA384, true},
+		{tls.TLS_CHACHA20_POLY1305_SHA256, false},
+		{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, true},
+		{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, true},
+		{tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256, false},
+		{tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA, false},
+	} {
+		err := verifyFIPS(tls.ConnectionState{CipherSuite: tc.suite})
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: got error %v, want ok=%t", tls.CipherSuiteName(tc.suite), err, tc.ok)
+		}
+	}
+}
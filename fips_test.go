@@ -0,0 +1,122 @@
+//go:build go1.25
+
+package dot_test
+
+import (
+	"context"
+	"crypto/tls"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/artyom/dot"
+	"github.com/artyom/dot/dottest"
+)
+
+func TestFIPS(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		server  *tls.Config
+		opts    []dot.Option
+		wantErr bool
+		version uint16
+		suites  []uint16
+	}{{
+		name:    "tls13",
+		version: tls.VersionTLS13,
+		suites:  []uint16{tls.TLS_AES_128_GCM_SHA256, tls.TLS_AES_256_GCM_SHA384},
+	}, {
+		name:    "tls12",
+		server:  &tls.Config{MaxVersion: tls.VersionTLS12},
+		version: tls.VersionTLS12,
+		suites:  []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+	}, {
+		name: "tls12 chacha20 only",
+		server: &tls.Config{
+			MaxVersion:   tls.VersionTLS12,
+			CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256},
+		},
+		wantErr: true,
+	}, {
+		name:    "tls11 only",
+		server:  &tls.Config{MinVersion: tls.VersionTLS10, MaxVersion: tls.VersionTLS11},
+		wantErr: true,
+	}, {
+		name:    "x25519 only",
+		server:  &tls.Config{CurvePreferences: []tls.CurveID{tls.X25519}},
+		wantErr: true,
+	}, {
+		name:    "tls12 p384 only",
+		server:  &tls.Config{MaxVersion: tls.VersionTLS12, CurvePreferences: []tls.CurveID{tls.CurveP384}},
+		version: tls.VersionTLS12,
+		suites:  []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+	}, {
+		name:    "post-quantum preferred",
+		opts:    []dot.Option{dot.WithPostQuantum()},
+		version: tls.VersionTLS13,
+		suites:  []uint16{tls.TLS_AES_128_GCM_SHA256, tls.TLS_AES_256_GCM_SHA384},
+	}, {
+		name:    "post-quantum required",
+		opts:    []dot.Option{dot.WithPostQuantumRequired()},
+		wantErr: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			// negotiated parameters are recorded by server, as TLS 1.2
+			// clients verify connection before key exchange is done
+			var mu sync.Mutex
+			var states []tls.ConnectionState
+			srv := dottest.NewUnstartedServer(dottest.Zone{"example.com": {"192.0.2.1"}})
+			srv.TLS = &tls.Config{}
+			if tc.server != nil {
+				srv.TLS = tc.server.Clone()
+			}
+			srv.TLS.VerifyConnection = func(cs tls.ConnectionState) error {
+				mu.Lock()
+				defer mu.Unlock()
+				states = append(states, cs)
+				return nil
+			}
+			srv.Start()
+			defer srv.Close()
+
+			opts := append([]dot.Option{dot.WithFIPS()}, tc.opts...)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_, err := srv.Resolver(opts...).LookupHost(ctx, "example.com")
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("lookup succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(states) == 0 {
+				t.Fatal("no handshakes recorded")
+			}
+			for _, cs := range states {
+				if cs.Version != tc.version {
+					t.Errorf("version %s, want %s", tls.VersionName(cs.Version), tls.VersionName(tc.version))
+				}
+				if !contains(tc.suites, cs.CipherSuite) {
+					t.Errorf("cipher suite %s is not allowed", tls.CipherSuiteName(cs.CipherSuite))
+				}
+				if cs.CurveID != tls.CurveP256 && cs.CurveID != tls.CurveP384 {
+					t.Errorf("curve %s, want P-256 or P-384", cs.CurveID)
+				}
+			}
+		})
+	}
+}
+
+func contains(ids []uint16, id uint16) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
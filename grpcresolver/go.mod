@@ -0,0 +1,11 @@
+module github.com/artyom/dot/grpcresolver
+
+go 1.22
+
+require google.golang.org/grpc v1.66.0
+
+require (
+	golang.org/x/net v0.35.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+	google.golang.org/protobuf v1.34.2 // indirect
+)
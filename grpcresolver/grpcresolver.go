@@ -0,0 +1,257 @@
+// Package grpcresolver provides gRPC name resolver doing its lookups over
+// DNS-over-TLS.
+//
+// gRPC does its own name resolution, bypassing net.DefaultResolver, so
+// replacing it with dot.SetDefault is not enough. Use Builder with grpc
+// dial option instead:
+//
+//	conn, err := grpc.NewClient("dot:///api.example.com:443",
+//		grpc.WithResolvers(grpcresolver.NewBuilder(dot.Quad9())),
+//		grpc.WithTransportCredentials(credentials.NewTLS(nil)))
+//
+// Target format is the same as of the gRPC built-in "dns" scheme:
+// dot:///host[:port], port defaults to 443. Authority part of the target is
+// ignored, queries are always sent over the resolver Builder was created
+// with.
+//
+// Package grpcresolver is a separate module, so that programs using package
+// dot do not depend on gRPC.
+package grpcresolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	grpclbstate "google.golang.org/grpc/balancer/grpclb/state"
+	"google.golang.org/grpc/resolver"
+)
+
+// Scheme is the scheme of targets handled by Builder.
+const Scheme = "dot"
+
+const (
+	defaultPort     = "443"
+	refreshInterval = 30 * time.Minute // re-resolve in absence of errors
+	minInterval     = 30 * time.Second // limits ResolveNow rate
+	lookupTimeout   = 10 * time.Second
+)
+
+// Option configures Builder.
+type Option func(*Builder)
+
+// WithSRV returns Option enabling lookups of _grpclb._tcp SRV records for
+// target host, passing found balancer addresses to the grpclb load balancing
+// policy, like gRPC built-in resolver does when its SRV lookups are enabled.
+func WithSRV() Option {
+	return func(b *Builder) { b.srv = true }
+}
+
+// Builder is a gRPC resolver.Builder for the Scheme, resolving target names
+// using DNS-over-TLS resolver.
+type Builder struct {
+	r   *net.Resolver
+	srv bool
+}
+
+// NewBuilder returns Builder resolving names with r, which should be the one
+// returned by one of github.com/artyom/dot functions.
+func NewBuilder(r *net.Resolver, opts ...Option) *Builder {
+	if r == nil {
+		panic("grpcresolver: nil Resolver")
+	}
+	b := &Builder{r: r}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Scheme returns Scheme.
+func (b *Builder) Scheme() string { return Scheme }
+
+// Build implements resolver.Builder interface.
+func (b *Builder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	host, port, err := parseTarget(target.Endpoint())
+	if err != nil {
+		return nil, err
+	}
+	// IP literal needs no resolution
+	if ip := net.ParseIP(host); ip != nil {
+		addr := resolver.Address{Addr: net.JoinHostPort(host, port)}
+		if err := cc.UpdateState(resolver.State{Addresses: []resolver.Address{addr}}); err != nil {
+			return nil, err
+		}
+		return noopResolver{}, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &dotResolver{
+		b:      b,
+		host:   host,
+		port:   port,
+		cc:     cc,
+		ctx:    ctx,
+		cancel: cancel,
+		now:    make(chan struct{}, 1),
+	}
+	d.wg.Add(1)
+	go d.watch()
+	return d, nil
+}
+
+func parseTarget(endpoint string) (host, port string, err error) {
+	if endpoint == "" {
+		return "", "", errors.New("grpcresolver: missing address")
+	}
+	if ip := net.ParseIP(endpoint); ip != nil {
+		return endpoint, defaultPort, nil
+	}
+	if host, port, err = net.SplitHostPort(endpoint); err != nil {
+		// no port given
+		return endpoint, defaultPort, nil
+	}
+	if host == "" {
+		return "", "", fmt.Errorf("grpcresolver: missing host in %q", endpoint)
+	}
+	if port == "" {
+		return "", "", fmt.Errorf("grpcresolver: missing port after colon in %q", endpoint)
+	}
+	return host, port, nil
+}
+
+type noopResolver struct{}
+
+func (noopResolver) ResolveNow(resolver.ResolveNowOptions) {}
+func (noopResolver) Close()                                {}
+
+type dotResolver struct {
+	b          *Builder
+	host, port string
+	cc         resolver.ClientConn
+	ctx        context.Context
+	cancel     context.CancelFunc
+	now        chan struct{}
+	wg         sync.WaitGroup
+}
+
+func (d *dotResolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case d.now <- struct{}{}:
+	default:
+	}
+}
+
+func (d *dotResolver) Close() {
+	d.cancel()
+	d.wg.Wait()
+}
+
+func (d *dotResolver) watch() {
+	defer d.wg.Done()
+	backoff := time.Second
+	for {
+		state, err := d.lookup()
+		if err == nil {
+			err = d.cc.UpdateState(state)
+		} else {
+			d.cc.ReportError(err)
+		}
+		var next time.Duration
+		if err == nil {
+			backoff = time.Second
+			// make sure resolution happens not too often, even if
+			// ResolveNow is called repeatedly
+			next = refreshInterval
+			t := time.NewTimer(minInterval)
+			select {
+			case <-d.ctx.Done():
+				t.Stop()
+				return
+			case <-t.C:
+			}
+			next -= minInterval
+		} else {
+			next = backoff
+			if backoff *= 2; backoff > 2*time.Minute {
+				backoff = 2 * time.Minute
+			}
+		}
+		t := time.NewTimer(next)
+		select {
+		case <-d.ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		case <-d.now:
+			t.Stop()
+		}
+	}
+}
+
+func (d *dotResolver) lookup() (resolver.State, error) {
+	ctx, cancel := context.WithTimeout(d.ctx, lookupTimeout)
+	defer cancel()
+	var state resolver.State
+	addrs, err := d.b.r.LookupHost(ctx, d.host)
+	if err != nil && !isNotFound(err) {
+		return state, err
+	}
+	for _, a := range addrs {
+		state.Addresses = append(state.Addresses, resolver.Address{Addr: net.JoinHostPort(a, d.port)})
+	}
+	if !d.b.srv {
+		if len(state.Addresses) == 0 {
+			return state, err
+		}
+		return state, nil
+	}
+	balancers, srvErr := d.lookupSRV(ctx)
+	if srvErr != nil {
+		return state, srvErr
+	}
+	if len(state.Addresses) == 0 && len(balancers) == 0 {
+		return state, fmt.Errorf("grpcresolver: no addresses found for %s", d.host)
+	}
+	if len(balancers) != 0 {
+		state = grpclbstate.Set(state, &grpclbstate.State{BalancerAddresses: balancers})
+	}
+	return state, nil
+}
+
+func (d *dotResolver) lookupSRV(ctx context.Context) ([]resolver.Address, error) {
+	_, srvs, err := d.b.r.LookupSRV(ctx, "grpclb", "tcp", d.host)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var out []resolver.Address
+	for _, s := range srvs {
+		addrs, err := d.b.r.LookupHost(ctx, s.Target)
+		if err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, a := range addrs {
+			out = append(out, resolver.Address{
+				Addr:       net.JoinHostPort(a, strconv.Itoa(int(s.Port))),
+				ServerName: s.Target,
+			})
+		}
+	}
+	return out, nil
+}
+
+// isNotFound reports whether err is a definitive negative answer, as opposed
+// to a temporary failure worth retrying.
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && !dnsErr.IsTimeout && !dnsErr.IsTemporary
+}
@@ -0,0 +1,152 @@
+package dot
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Handler answers DNS queries.
+type Handler interface {
+	// ServeDNS returns wire-format response to wire-format query. If it
+	// returns an error, query is answered with SERVFAIL; if it returns nil
+	// response and nil error, query is dropped.
+	ServeDNS(ctx context.Context, query []byte) ([]byte, error)
+}
+
+// HandlerFunc is an adapter to allow the use of ordinary functions as DNS
+// handlers.
+type HandlerFunc func(ctx context.Context, query []byte) ([]byte, error)
+
+// ServeDNS calls f(ctx, query).
+func (f HandlerFunc) ServeDNS(ctx context.Context, query []byte) ([]byte, error) {
+	return f(ctx, query)
+}
+
+// Middleware wraps Handler into another one adding some behavior, such as
+// caching, filtering or logging, around it. Functions of this package having
+// the func(next Handler) Handler signature, like MDNS, are Middleware as is;
+// others are easily adapted with a closure.
+type Middleware func(next Handler) Handler
+
+// Chain returns h wrapped into middlewares, so that queries pass through them
+// in the order given before reaching h, and responses pass back in reverse:
+//
+//	h := dot.Chain(dot.NewClient("dns.quad9.net", []string{"9.9.9.9:853"}),
+//		dot.MDNS, // local names never reach the cache or upstream
+//		func(next dot.Handler) dot.Handler { return dot.NewCache(next, 1000) },
+//	)
+//
+// Chain with no middlewares returns h as is.
+func Chain(h Handler, middlewares ...Middleware) Handler {
+	if h == nil {
+		panic("dot: nil Handler")
+	}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// Forward returns Handler that forwards queries upstream over connections
+// obtained from r.Dial. r should be the one returned by one of this package's
+// functions.
+func Forward(r *net.Resolver) Handler {
+	return HandlerFunc(func(ctx context.Context, query []byte) ([]byte, error) {
+		return exchange(ctx, r, query)
+	})
+}
+
+// NewResolver returns Resolver doing its lookups through h, which lets
+// handlers, such as Cache, serve lookups of the code using Resolver:
+//
+//	c := dot.NewClient("dns.quad9.net", []string{"9.9.9.9:853"})
+//	r := dot.NewResolver(dot.NewCache(c, 1000))
+//
+// If h is a *Client, its pooled connections are used directly.
+func NewResolver(h Handler) *net.Resolver {
+	if h == nil {
+		panic("dot: nil Handler")
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return &queryConn{h: h, ctx: ctx}, nil
+		},
+	}
+}
+
+// Rewrite returns Handler that passes queries to next and calls fn with every
+// response it returns, so that fn can modify, filter or replace its records
+// before response is sent to the client. If fn returns an error, query is
+// answered with SERVFAIL. For example, to strip AAAA records from all
+// responses:
+//
+//	h := dot.Rewrite(next, func(resp *dnsmessage.Message) error {
+//		answers := resp.Answers[:0]
+//		for _, rr := range resp.Answers {
+//			if rr.Header.Type != dnsmessage.TypeAAAA {
+//				answers = append(answers, rr)
+//			}
+//		}
+//		resp.Answers = answers
+//		return nil
+//	})
+//
+// Responses that cannot be parsed are treated as errors.
+func Rewrite(next Handler, fn func(resp *dnsmessage.Message) error) Handler {
+	if next == nil {
+		panic("dot: nil Handler")
+	}
+	return HandlerFunc(func(ctx context.Context, query []byte) ([]byte, error) {
+		resp, err := next.ServeDNS(ctx, query)
+		if err != nil || resp == nil {
+			return resp, err
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(resp); err != nil {
+			return nil, fmt.Errorf("dot: parsing response: %w", err)
+		}
+		if err := fn(&msg); err != nil {
+			return nil, err
+		}
+		return msg.Pack()
+	})
+}
+
+// Fallback returns Handler that passes queries to primary, and retries them
+// with secondary if primary fails or answers with SERVFAIL or REFUSED, as
+// providers do on failures of their DNSSEC validation or filtering. If
+// secondary fails too, the primary's response or error is returned. Use it
+// to keep name resolution working through hiccups of a single provider:
+//
+//	h := dot.Fallback(
+//		dot.NewClient("dns.quad9.net", []string{"9.9.9.9:853"}),
+//		dot.NewClient("one.one.one.one", []string{"1.1.1.1:853"}),
+//	)
+func Fallback(primary, secondary Handler) Handler {
+	if primary == nil || secondary == nil {
+		panic("dot: nil Handler")
+	}
+	return HandlerFunc(func(ctx context.Context, query []byte) ([]byte, error) {
+		resp, err := primary.ServeDNS(ctx, query)
+		if err == nil && (len(resp) < 4 || !retryableRCode(dnsmessage.RCode(resp[3]&0x0f))) {
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return resp, err
+		}
+		if resp2, err2 := secondary.ServeDNS(ctx, query); err2 == nil && resp2 != nil {
+			return resp2, nil
+		}
+		return resp, err
+	})
+}
+
+// retryableRCode reports whether response with code rc may be answered
+// differently by another provider.
+func retryableRCode(rc dnsmessage.RCode) bool {
+	return rc == dnsmessage.RCodeServerFailure || rc == dnsmessage.RCodeRefused
+}
@@ -0,0 +1,200 @@
+package dot
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// HistogramBuckets are upper bounds of LatencyRecorder histogram buckets,
+// doubling from 1ms to about 16s. Latencies above the last bound are counted
+// in an extra overflow bucket.
+var HistogramBuckets = func() []time.Duration {
+	out := make([]time.Duration, 15)
+	for i := range out {
+		out[i] = time.Millisecond << i
+	}
+	return out
+}()
+
+// LatencyRecorder records latency of queries in histograms, one per provider
+// name and query type. Recording takes a few atomic operations, so it can
+// stay enabled in production. Use it to compare latency percentiles of
+// upstreams:
+//
+//	rec := dot.NewLatencyRecorder()
+//	h := rec.Handler("quad9", dot.NewClient("dns.quad9.net", []string{"9.9.9.9:853"}))
+//	r := dot.NewResolver(h)
+//	...
+//	for _, s := range rec.Snapshot() {
+//		log.Printf("%s %v: p50=%v p99=%v", s.Provider, s.Type, s.Quantile(0.5), s.Quantile(0.99))
+//	}
+//
+// LatencyRecorder is safe for concurrent use.
+type LatencyRecorder struct {
+	mu     sync.RWMutex
+	series map[histogramKey]*histogram
+}
+
+// NewLatencyRecorder returns empty LatencyRecorder.
+func NewLatencyRecorder() *LatencyRecorder {
+	return &LatencyRecorder{series: make(map[histogramKey]*histogram)}
+}
+
+type histogramKey struct {
+	provider string
+	typ      dnsmessage.Type
+}
+
+type histogram struct {
+	buckets [16]atomic.Uint64 // len(HistogramBuckets)+1
+	errors  atomic.Uint64
+	sum     atomic.Int64 // nanoseconds
+}
+
+// Handler returns Handler that passes queries to next, recording how long
+// it takes next to answer them under provider name. Queries that fail or are
+// dropped are recorded too, and also counted as errors.
+func (rec *LatencyRecorder) Handler(provider string, next Handler) Handler {
+	if next == nil {
+		panic("dot: nil Handler")
+	}
+	return HandlerFunc(func(ctx context.Context, query []byte) ([]byte, error) {
+		begin := time.Now()
+		resp, err := next.ServeDNS(ctx, query)
+		rec.Record(provider, queryType(query), time.Since(begin), err != nil || resp == nil)
+		return resp, err
+	})
+}
+
+// Record records a single query of type typ sent to provider that took d to
+// complete, for use by Handler implementations measuring latency themselves.
+// If failed is true, query is also counted as an error.
+func (rec *LatencyRecorder) Record(provider string, typ dnsmessage.Type, d time.Duration, failed bool) {
+	key := histogramKey{provider: provider, typ: typ}
+	rec.mu.RLock()
+	h := rec.series[key]
+	rec.mu.RUnlock()
+	if h == nil {
+		rec.mu.Lock()
+		if h = rec.series[key]; h == nil {
+			h = new(histogram)
+			rec.series[key] = h
+		}
+		rec.mu.Unlock()
+	}
+	i := sort.Search(len(HistogramBuckets), func(i int) bool { return d <= HistogramBuckets[i] })
+	h.buckets[i].Add(1)
+	h.sum.Add(int64(d))
+	if failed {
+		h.errors.Add(1)
+	}
+}
+
+// Snapshot returns current state of all histograms, ordered by provider name
+// and query type.
+func (rec *LatencyRecorder) Snapshot() []HistogramSnapshot {
+	rec.mu.RLock()
+	out := make([]HistogramSnapshot, 0, len(rec.series))
+	for key, h := range rec.series {
+		s := HistogramSnapshot{
+			Provider: key.provider,
+			Type:     key.typ,
+			Buckets:  make([]uint64, len(h.buckets)),
+			Errors:   h.errors.Load(),
+			Sum:      time.Duration(h.sum.Load()),
+		}
+		for i := range h.buckets {
+			s.Buckets[i] = h.buckets[i].Load()
+			s.Count += s.Buckets[i]
+		}
+		out = append(out, s)
+	}
+	rec.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Provider != out[j].Provider {
+			return out[i].Provider < out[j].Provider
+		}
+		return out[i].Type < out[j].Type
+	})
+	return out
+}
+
+// Reset discards all recorded data.
+func (rec *LatencyRecorder) Reset() {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.series = make(map[histogramKey]*histogram)
+}
+
+// HistogramSnapshot is the state of a single LatencyRecorder histogram.
+type HistogramSnapshot struct {
+	Provider string
+	Type     dnsmessage.Type
+
+	// Buckets holds the number of queries that took no longer than the
+	// corresponding HistogramBuckets bound, but longer than the previous
+	// one. The last element counts queries that took longer than all
+	// bounds.
+	Buckets []uint64
+
+	Count  uint64        // number of queries recorded
+	Errors uint64        // number of queries that failed
+	Sum    time.Duration // total time of all queries recorded
+}
+
+// Mean returns average latency, or zero if there were no queries.
+func (s HistogramSnapshot) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / time.Duration(s.Count)
+}
+
+// Quantile returns estimate of q-quantile of latency, e.g. 0.99 for the 99th
+// percentile, interpolating linearly within the bucket it falls in. It
+// returns zero if there were no queries; for quantiles falling in the
+// overflow bucket it returns the last bound of HistogramBuckets.
+func (s HistogramSnapshot) Quantile(q float64) time.Duration {
+	if s.Count == 0 || math.IsNaN(q) {
+		return 0
+	}
+	q = math.Max(0, math.Min(1, q))
+	rank := q * float64(s.Count)
+	var seen float64
+	for i, n := range s.Buckets {
+		if n == 0 || seen+float64(n) < rank {
+			seen += float64(n)
+			continue
+		}
+		if i == len(HistogramBuckets) {
+			break
+		}
+		var lower time.Duration
+		if i > 0 {
+			lower = HistogramBuckets[i-1]
+		}
+		upper := HistogramBuckets[i]
+		return lower + time.Duration(float64(upper-lower)*(rank-seen)/float64(n))
+	}
+	return HistogramBuckets[len(HistogramBuckets)-1]
+}
+
+// queryType returns type of the first question of query, or zero type if
+// query cannot be parsed.
+func queryType(query []byte) dnsmessage.Type {
+	var p dnsmessage.Parser
+	if _, err := p.Start(query); err != nil {
+		return 0
+	}
+	q, err := p.Question()
+	if err != nil {
+		return 0
+	}
+	return q.Type
+}
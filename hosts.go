@@ -0,0 +1,252 @@
+package dot
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// hostsCheckInterval limits how often hosts file is checked for changes.
+const hostsCheckInterval = 5 * time.Second
+
+// Hosts is a Handler answering queries for names listed in a hosts file, or
+// in a map, locally, passing all other queries to another Handler.
+//
+// A and AAAA queries for listed names are answered with addresses of the
+// matching family, PTR queries for listed addresses are answered with their
+// names. Queries of other types for listed names get an empty answer, so
+// they never reach upstream. Answers have zero TTL, so that changes take
+// effect immediately.
+type Hosts struct {
+	next Handler
+	path string // empty if entries are static
+
+	mu      sync.RWMutex
+	entries *hostsEntries
+	checked time.Time // when file was last checked for changes
+	modTime time.Time
+	size    int64
+}
+
+type hostsEntries struct {
+	addrs map[string][]netip.Addr // keyed by lowercased name without trailing dot
+	names map[netip.Addr][]string // names with trailing dot, as listed
+}
+
+// NewHosts returns Hosts wrapping next that answers queries for names listed
+// in hosts file at path, /etc/hosts if path is empty. The file is reloaded
+// once it changes. Missing or unreadable file is treated as an empty one.
+func NewHosts(next Handler, path string) *Hosts {
+	if next == nil {
+		panic("dot: nil Handler")
+	}
+	if path == "" {
+		path = "/etc/hosts"
+	}
+	h := &Hosts{next: next, path: path}
+	h.reload(time.Now())
+	return h
+}
+
+// NewHostsMap returns Hosts wrapping next that answers queries for names
+// from the map of names to their addresses. Names are case-insensitive, with
+// optional trailing dot; addresses that fail to parse are ignored.
+func NewHostsMap(next Handler, hosts map[string][]string) *Hosts {
+	if next == nil {
+		panic("dot: nil Handler")
+	}
+	e := newHostsEntries()
+	for name, addrs := range hosts {
+		for _, s := range addrs {
+			if ip, err := netip.ParseAddr(s); err == nil {
+				e.add(ip, name)
+			}
+		}
+	}
+	return &Hosts{next: next, entries: e}
+}
+
+// ServeDNS implements Handler interface.
+func (h *Hosts) ServeDNS(ctx context.Context, query []byte) ([]byte, error) {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(query)
+	if err != nil {
+		return h.next.ServeDNS(ctx, query)
+	}
+	q, err := p.Question()
+	if err != nil || q.Class != dnsmessage.ClassINET {
+		return h.next.ServeDNS(ctx, query)
+	}
+	e := h.current()
+	var answers []dnsmessage.Resource
+	rh := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET}
+	if q.Type == dnsmessage.TypePTR {
+		ip, ok := reverseAddr(q.Name.String())
+		if !ok {
+			return h.next.ServeDNS(ctx, query)
+		}
+		names := e.names[ip]
+		if len(names) == 0 {
+			return h.next.ServeDNS(ctx, query)
+		}
+		for _, name := range names {
+			n, err := dnsmessage.NewName(name)
+			if err != nil {
+				continue
+			}
+			answers = append(answers, dnsmessage.Resource{Header: rh, Body: &dnsmessage.PTRResource{PTR: n}})
+		}
+		return answerResponse(hdr, q, dnsmessage.RCodeSuccess, answers)
+	}
+	addrs, ok := e.addrs[hostsKey(q.Name.String())]
+	if !ok {
+		return h.next.ServeDNS(ctx, query)
+	}
+	for _, ip := range addrs {
+		switch {
+		case q.Type == dnsmessage.TypeA && ip.Is4():
+			answers = append(answers, dnsmessage.Resource{Header: rh, Body: &dnsmessage.AResource{A: ip.As4()}})
+		case q.Type == dnsmessage.TypeAAAA && ip.Is6():
+			answers = append(answers, dnsmessage.Resource{Header: rh, Body: &dnsmessage.AAAAResource{AAAA: ip.As16()}})
+		}
+	}
+	return answerResponse(hdr, q, dnsmessage.RCodeSuccess, answers)
+}
+
+// current returns entries to use, reloading hosts file if it has changed.
+func (h *Hosts) current() *hostsEntries {
+	if h.path == "" {
+		return h.entries
+	}
+	now := time.Now()
+	h.mu.RLock()
+	e, checked := h.entries, h.checked
+	h.mu.RUnlock()
+	if now.Sub(checked) < hostsCheckInterval {
+		return e
+	}
+	return h.reload(now)
+}
+
+// reload re-reads hosts file if its modification time or size differ from
+// the ones it had when last read.
+func (h *Hosts) reload(now time.Time) *hostsEntries {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.entries != nil && now.Sub(h.checked) < hostsCheckInterval {
+		return h.entries // reloaded concurrently
+	}
+	h.checked = now
+	fi, err := os.Stat(h.path)
+	if err != nil {
+		h.entries, h.modTime, h.size = newHostsEntries(), time.Time{}, 0
+		return h.entries
+	}
+	if h.entries != nil && fi.ModTime().Equal(h.modTime) && fi.Size() == h.size {
+		return h.entries
+	}
+	h.modTime, h.size = fi.ModTime(), fi.Size()
+	data, err := os.ReadFile(h.path)
+	if err != nil {
+		h.entries = newHostsEntries()
+		return h.entries
+	}
+	h.entries = parseHosts(data)
+	return h.entries
+}
+
+func newHostsEntries() *hostsEntries {
+	return &hostsEntries{
+		addrs: make(map[string][]netip.Addr),
+		names: make(map[netip.Addr][]string),
+	}
+}
+
+func (e *hostsEntries) add(ip netip.Addr, name string) {
+	ip = ip.Unmap().WithZone("")
+	key := hostsKey(name)
+	if key == "" {
+		return
+	}
+	for _, v := range e.addrs[key] {
+		if v == ip {
+			return
+		}
+	}
+	e.addrs[key] = append(e.addrs[key], ip)
+	e.names[ip] = append(e.names[ip], key+".")
+}
+
+// parseHosts parses hosts file in the format described in hosts(5).
+func parseHosts(data []byte) *hostsEntries {
+	e := newHostsEntries()
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		ip, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			continue
+		}
+		for _, name := range fields[1:] {
+			e.add(ip, name)
+		}
+	}
+	return e
+}
+
+func hostsKey(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// reverseAddr returns address encoded in a name of the in-addr.arpa or
+// ip6.arpa domain, as used by PTR queries.
+func reverseAddr(name string) (netip.Addr, bool) {
+	name = hostsKey(name)
+	if s, ok := strings.CutSuffix(name, ".in-addr.arpa"); ok {
+		labels := strings.Split(s, ".")
+		if len(labels) != 4 {
+			return netip.Addr{}, false
+		}
+		var b [4]byte
+		for i, l := range labels {
+			n, err := strconv.ParseUint(l, 10, 8)
+			if err != nil {
+				return netip.Addr{}, false
+			}
+			b[3-i] = byte(n)
+		}
+		return netip.AddrFrom4(b), true
+	}
+	if s, ok := strings.CutSuffix(name, ".ip6.arpa"); ok {
+		labels := strings.Split(s, ".")
+		if len(labels) != 32 {
+			return netip.Addr{}, false
+		}
+		var b [16]byte
+		for i, l := range labels {
+			n, err := strconv.ParseUint(l, 16, 4)
+			if err != nil || len(l) != 1 {
+				return netip.Addr{}, false
+			}
+			j := 31 - i
+			b[j/2] |= byte(n) << (4 * (1 - j%2))
+		}
+		return netip.AddrFrom16(b), true
+	}
+	return netip.Addr{}, false
+}
@@ -0,0 +1,191 @@
+package dot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// SRV is a parsed SRV record, see RFC 2782.
+type SRV struct {
+	Target   string // host name with trailing dot, "." if service is not available
+	Port     uint16
+	Priority uint16
+	Weight   uint16
+	TTL      uint32
+}
+
+// NAPTR is a parsed NAPTR record, see RFC 3403.
+type NAPTR struct {
+	Order       uint16
+	Preference  uint16
+	Flags       string
+	Service     string
+	Regexp      string
+	Replacement string // domain name with trailing dot, "." if not used
+	TTL         uint32
+}
+
+// typeNAPTR is the NAPTR record type, not defined by dnsmessage.
+const typeNAPTR dnsmessage.Type = 35
+
+// LookupSRV looks up SRV records of name over a connection obtained from
+// r.Dial. Unlike r.LookupSRV, it takes the full record name, such as
+// "_sip._udp.example.com", does not reject records with target names Go
+// considers invalid, and returns record TTLs. Records are ordered by
+// priority, and by weight, heaviest first, within the same priority;
+// weighted random selection, if needed, is left to the caller.
+func LookupSRV(ctx context.Context, r *net.Resolver, name string) ([]SRV, error) {
+	msg, err := lookupRecords(ctx, resolverHandler(r), name, dnsmessage.TypeSRV)
+	if err != nil {
+		return nil, err
+	}
+	var out []SRV
+	for _, rr := range msg.Answers {
+		if v, ok := rr.Body.(*dnsmessage.SRVResource); ok {
+			out = append(out, SRV{
+				Target:   v.Target.String(),
+				Port:     v.Port,
+				Priority: v.Priority,
+				Weight:   v.Weight,
+				TTL:      rr.Header.TTL,
+			})
+		}
+	}
+	if len(out) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Priority != out[j].Priority {
+			return out[i].Priority < out[j].Priority
+		}
+		return out[i].Weight > out[j].Weight
+	})
+	return out, nil
+}
+
+// LookupNAPTR looks up NAPTR records of name over a connection obtained from
+// r.Dial, which net.Resolver has no method for. Records are ordered by order
+// and preference fields, as RFC 3403 requires clients to process them.
+func LookupNAPTR(ctx context.Context, r *net.Resolver, name string) ([]NAPTR, error) {
+	msg, err := lookupRecords(ctx, resolverHandler(r), name, typeNAPTR)
+	if err != nil {
+		return nil, err
+	}
+	var out []NAPTR
+	for _, rr := range msg.Answers {
+		v, ok := rr.Body.(*dnsmessage.UnknownResource)
+		if !ok || rr.Header.Type != typeNAPTR {
+			continue
+		}
+		n, err := parseNAPTR(v.Data)
+		if err != nil {
+			return nil, &net.DNSError{Err: err.Error(), Name: name}
+		}
+		n.TTL = rr.Header.TTL
+		out = append(out, n)
+	}
+	if len(out) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Order != out[j].Order {
+			return out[i].Order < out[j].Order
+		}
+		return out[i].Preference < out[j].Preference
+	})
+	return out, nil
+}
+
+// resolverHandler returns Handler sending every query over a new connection
+// obtained from r.Dial.
+func resolverHandler(r *net.Resolver) Handler {
+	return HandlerFunc(func(ctx context.Context, query []byte) ([]byte, error) {
+		return exchange(ctx, r, query)
+	})
+}
+
+// lookupRecords sends query for name of type qtype to h, returning parsed
+// response if it is successful. Errors are reported as *net.DNSError, like
+// net.Resolver does, except for filtered answers reported as
+// *FilteredError.
+func lookupRecords(ctx context.Context, h Handler, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	query, err := newQuery(name, qtype)
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: name}
+	}
+	exact := prepareQuery(ctx, query)
+	resp, err := h.ServeDNS(ctx, query)
+	if err != nil {
+		return nil, &net.DNSError{
+			Err:         err.Error(),
+			Name:        name,
+			IsTimeout:   errors.Is(err, context.DeadlineExceeded) || isTimeout(err),
+			IsTemporary: true,
+		}
+	}
+	if err := checkResponse(query, resp, exact); err != nil {
+		return nil, &net.DNSError{Err: strings.TrimPrefix(err.Error(), "dot: "), Name: name}
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(resp); err != nil {
+		return nil, &net.DNSError{Err: "cannot unmarshal DNS message", Name: name}
+	}
+	if err := filteredError(name, &msg); err != nil {
+		return nil, err
+	}
+	switch msg.Header.RCode {
+	case dnsmessage.RCodeSuccess:
+		return &msg, nil
+	case dnsmessage.RCodeNameError:
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	case dnsmessage.RCodeServerFailure:
+		return nil, &net.DNSError{Err: "server misbehaving", Name: name, IsTemporary: true}
+	}
+	return nil, &net.DNSError{Err: "response code " + strings.TrimPrefix(msg.Header.RCode.String(), "RCode"), Name: name}
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// parseNAPTR parses NAPTR record data, which dnsmessage does not support.
+func parseNAPTR(b []byte) (NAPTR, error) {
+	errMalformed := errors.New("malformed NAPTR record")
+	if len(b) < 4 {
+		return NAPTR{}, errMalformed
+	}
+	n := NAPTR{
+		Order:      uint16(b[0])<<8 | uint16(b[1]),
+		Preference: uint16(b[2])<<8 | uint16(b[3]),
+	}
+	b = b[4:]
+	for _, s := range []*string{&n.Flags, &n.Service, &n.Regexp} {
+		if len(b) < 1 || len(b) < 1+int(b[0]) {
+			return NAPTR{}, errMalformed
+		}
+		*s = string(b[1 : 1+b[0]])
+		b = b[1+b[0]:]
+	}
+	// replacement is an uncompressed domain name
+	var labels []string
+	for {
+		if len(b) < 1 || b[0] > 63 || len(b) < 1+int(b[0]) {
+			return NAPTR{}, fmt.Errorf("%v: bad replacement", errMalformed)
+		}
+		l := int(b[0])
+		if l == 0 {
+			break
+		}
+		labels = append(labels, string(b[1:1+l]))
+		b = b[1+l:]
+	}
+	n.Replacement = strings.Join(labels, ".") + "."
+	return n, nil
+}
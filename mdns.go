@@ -0,0 +1,118 @@
+package dot
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// mdnsTimeout limits how long mDNS query waits for answers if its context
+// has no earlier deadline.
+const mdnsTimeout = 2 * time.Second
+
+var mdnsAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// MDNS returns Handler that resolves names in the "local." domain, and
+// reverse names of link-local addresses, using one-shot multicast DNS queries
+// on the local link as described in RFC 6762, section 5.1. All other queries
+// are passed to next. Use it to keep local host names from leaking to public
+// upstream, which cannot resolve them anyway:
+//
+//	r := dot.NewResolver(dot.MDNS(dot.NewClient("dns.quad9.net", []string{"9.9.9.9:853"})))
+//
+// Queries are sent over IPv4 only. If no host answers within 2 seconds, or
+// before the query context is done, query is answered with NXDOMAIN.
+func MDNS(next Handler) Handler {
+	if next == nil {
+		panic("dot: nil Handler")
+	}
+	return HandlerFunc(func(ctx context.Context, query []byte) ([]byte, error) {
+		var q dnsmessage.Message
+		if err := q.Unpack(query); err != nil || len(q.Questions) != 1 || !isLinkLocalName(q.Questions[0].Name.String()) {
+			return next.ServeDNS(ctx, query)
+		}
+		return mdnsExchange(ctx, &q)
+	})
+}
+
+// isLinkLocalName reports whether name should be resolved with mDNS, see RFC
+// 6762, section 4.
+func isLinkLocalName(name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, suffix := range []string{".local", ".254.169.in-addr.arpa",
+		".8.e.f.ip6.arpa", ".9.e.f.ip6.arpa", ".a.e.f.ip6.arpa", ".b.e.f.ip6.arpa"} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// mdnsExchange sends query q to the mDNS multicast group and returns the first
+// response answering it, adjusted to look like a unicast DNS response.
+func mdnsExchange(ctx context.Context, q *dnsmessage.Message) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, mdnsTimeout)
+	defer cancel()
+	mq := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: q.Header.ID},
+		Questions: q.Questions,
+	}
+	query, err := mq.Pack()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	d, _ := ctx.Deadline()
+	conn.SetDeadline(d)
+	stop := closeOnDone(ctx, conn)
+	defer stop()
+	if _, err := conn.WriteTo(query, mdnsAddr); err != nil {
+		return nil, fmt.Errorf("dot: sending mDNS query: %w", err)
+	}
+	question := q.Questions[0]
+	buf := make([]byte, maxMsgSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && ctx.Err() != context.Canceled {
+				// nobody answered
+				return answerResponse(q.Header, question, dnsmessage.RCodeNameError, nil)
+			}
+			return nil, err
+		}
+		var resp dnsmessage.Message
+		if err := resp.Unpack(buf[:n]); err != nil || !resp.Header.Response || resp.Header.RCode != dnsmessage.RCodeSuccess {
+			continue
+		}
+		var answers []dnsmessage.Resource
+		for _, rr := range resp.Answers {
+			rr.Header.Class &^= 1 << 15 // cache-flush bit
+			if strings.EqualFold(rr.Header.Name.String(), question.Name.String()) &&
+				(rr.Header.Type == question.Type || rr.Header.Type == dnsmessage.TypeCNAME) {
+				answers = append(answers, rr)
+			}
+		}
+		if len(answers) == 0 {
+			continue
+		}
+		out := dnsmessage.Message{
+			Header: dnsmessage.Header{
+				ID:                 q.Header.ID,
+				Response:           true,
+				RecursionDesired:   q.Header.RecursionDesired,
+				RecursionAvailable: true,
+			},
+			Questions: q.Questions,
+			Answers:   answers,
+		}
+		return out.Pack()
+	}
+}
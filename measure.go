@@ -0,0 +1,167 @@
+package dot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Measurement holds statistics Measure collected for a single resolver.
+type Measurement struct {
+	Name     string // key of the resolver in the map passed to Measure
+	Attempts int    // number of queries attempted
+	Failures int    // number of queries that failed
+
+	// Handshake is the distribution of times it took to establish a new
+	// connection, including both TCP and TLS handshakes.
+	Handshake Latency
+
+	// Query is the distribution of times it took to receive an answer once
+	// the connection was established.
+	Query Latency
+
+	// Total is the distribution of combined handshake and query times.
+	Total Latency
+
+	// Err is the last error encountered, if any.
+	Err error
+}
+
+// FailureRate returns fraction of failed attempts, from 0 to 1.
+func (m Measurement) FailureRate() float64 {
+	if m.Attempts == 0 {
+		return 0
+	}
+	return float64(m.Failures) / float64(m.Attempts)
+}
+
+// Latency summarizes distribution of durations of successful attempts. All
+// fields are zero if there were none.
+type Latency struct {
+	Min, P50, P90, P99, Max time.Duration
+}
+
+func newLatency(samples []time.Duration) Latency {
+	if len(samples) == 0 {
+		return Latency{}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	// nearest-rank percentile
+	pct := func(p int) time.Duration {
+		idx := (p*len(samples)+99)/100 - 1
+		if idx < 0 {
+			idx = 0
+		}
+		return samples[idx]
+	}
+	return Latency{
+		Min: samples[0],
+		P50: pct(50),
+		P90: pct(90),
+		P99: pct(99),
+		Max: samples[len(samples)-1],
+	}
+}
+
+// probeTimeout limits how long a single Measure attempt can take.
+const probeTimeout = 5 * time.Second
+
+// Measure benchmarks resolvers by resolving A records of every name in
+// names, each over a fresh connection. Resolvers are measured concurrently,
+// names are queried one after another in the given order; repeat names to
+// gather more samples. Attempts are considered failed if they time out, or
+// response has an error code.
+//
+// Resolvers must be the ones returned by this package's functions. Returned
+// measurements are sorted best first: by failure rate, then by median total
+// time.
+func Measure(ctx context.Context, resolvers map[string]*net.Resolver, names []string) ([]Measurement, error) {
+	if len(resolvers) == 0 {
+		return nil, errors.New("dot: no resolvers to measure")
+	}
+	if len(names) == 0 {
+		return nil, errors.New("dot: no names to query")
+	}
+	out := make([]Measurement, 0, len(resolvers))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, r := range resolvers {
+		wg.Add(1)
+		go func(name string, r *net.Resolver) {
+			defer wg.Done()
+			m := measure(ctx, r, names)
+			m.Name = name
+			mu.Lock()
+			out = append(out, m)
+			mu.Unlock()
+		}(name, r)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if fi, fj := out[i].FailureRate(), out[j].FailureRate(); fi != fj {
+			return fi < fj
+		}
+		if out[i].Total.P50 != out[j].Total.P50 {
+			return out[i].Total.P50 < out[j].Total.P50
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out, nil
+}
+
+func measure(ctx context.Context, r *net.Resolver, names []string) Measurement {
+	var m Measurement
+	var handshake, query, total []time.Duration
+	for _, name := range names {
+		if ctx.Err() != nil {
+			break
+		}
+		m.Attempts++
+		hs, qt, err := probe(ctx, r, name)
+		if err != nil {
+			m.Failures++
+			m.Err = err
+			continue
+		}
+		handshake = append(handshake, hs)
+		query = append(query, qt)
+		total = append(total, hs+qt)
+	}
+	m.Handshake = newLatency(handshake)
+	m.Query = newLatency(query)
+	m.Total = newLatency(total)
+	return m
+}
+
+// probe establishes a new connection with r and sends A query for name over
+// it, reporting handshake and query durations separately.
+func probe(ctx context.Context, r *net.Resolver, name string) (handshake, query time.Duration, err error) {
+	msg, err := newQuery(name, dnsmessage.TypeA)
+	if err != nil {
+		return 0, 0, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	res, err := timedExchange(ctx, r, msg)
+	if err != nil {
+		return 0, 0, err
+	}
+	var p dnsmessage.Parser
+	h, err := p.Start(res.resp)
+	if err != nil {
+		return 0, 0, err
+	}
+	if h.RCode != dnsmessage.RCodeSuccess {
+		return 0, 0, fmt.Errorf("dot: %s: response code %v", name, h.RCode)
+	}
+	return res.handshake, res.query, nil
+}
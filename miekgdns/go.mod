@@ -0,0 +1,17 @@
+module github.com/artyom/dot/miekgdns
+
+go 1.22
+
+require (
+	github.com/artyom/dot v0.0.0-20261014160754-0a91af886364
+	github.com/miekg/dns v1.1.62
+)
+
+require (
+	golang.org/x/crypto v0.33.0 // indirect
+	golang.org/x/mod v0.18.0 // indirect
+	golang.org/x/net v0.35.0 // indirect
+	golang.org/x/sync v0.7.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+	golang.org/x/tools v0.22.0 // indirect
+)
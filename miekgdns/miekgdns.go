@@ -0,0 +1,75 @@
+// Package miekgdns adapts DNS-over-TLS clients of github.com/artyom/dot to
+// the *dns.Msg based API of github.com/miekg/dns.
+//
+// Client has the same Exchange and ExchangeContext methods as dns.Client, so
+// it can replace it in code that only needs these:
+//
+//	c := &miekgdns.Client{Handler: dot.NewClient("dns.quad9.net", []string{"9.9.9.9:853"})}
+//	resp, rtt, err := c.Exchange(new(dns.Msg).SetQuestion("example.com.", dns.TypeA), "")
+//
+// Package miekgdns is a separate module, so that programs using package dot
+// do not depend on github.com/miekg/dns.
+package miekgdns
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/artyom/dot"
+	"github.com/miekg/dns"
+)
+
+// Client exchanges *dns.Msg messages through a dot.Handler, which is usually
+// a *dot.Client, so that messages are sent over its pooled DNS-over-TLS
+// connections.
+type Client struct {
+	// Handler sends queries upstream.
+	Handler dot.Handler
+
+	// Timeout limits how long Exchange waits for response, 5 seconds if
+	// zero. It is not used by ExchangeContext.
+	Timeout time.Duration
+}
+
+// Exchange is like ExchangeContext, but it limits exchange duration with
+// c.Timeout.
+func (c *Client) Exchange(m *dns.Msg, address string) (r *dns.Msg, rtt time.Duration, err error) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return c.ExchangeContext(ctx, m, address)
+}
+
+// ExchangeContext sends query m upstream and returns its response along with
+// the time exchange took. The address argument is ignored, it is only there
+// for compatibility with dns.Client: upstream is defined by c.Handler.
+func (c *Client) ExchangeContext(ctx context.Context, m *dns.Msg, address string) (r *dns.Msg, rtt time.Duration, err error) {
+	if c.Handler == nil {
+		return nil, 0, errors.New("miekgdns: nil Handler")
+	}
+	query, err := m.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+	begin := time.Now()
+	resp, err := c.Handler.ServeDNS(ctx, query)
+	rtt = time.Since(begin)
+	if err != nil {
+		return nil, rtt, err
+	}
+	if resp == nil {
+		return nil, rtt, errors.New("miekgdns: query dropped")
+	}
+	r = new(dns.Msg)
+	if err := r.Unpack(resp); err != nil {
+		return nil, rtt, err
+	}
+	if r.Id != m.Id {
+		return nil, rtt, dns.ErrId
+	}
+	return r, rtt, nil
+}
@@ -0,0 +1,158 @@
+package dot
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// LookupNetIP looks up host using r, returning only addresses of the family
+// network selects: "ip" for both IPv4 and IPv6, "ip4" for IPv4 only, "ip6"
+// for IPv6 only. Unlike r.LookupNetIP, which may return IPv4 addresses as
+// IPv4-mapped IPv6 ones, it always returns IPv4 addresses in their 4-byte
+// form, so that they compare equal to the ones made by netip.AddrFrom4.
+//
+// If all addresses found are the ones providers answer blocked names with,
+// such as 0.0.0.0 or ::, it returns *FilteredError instead of them.
+func LookupNetIP(ctx context.Context, r *net.Resolver, network, host string) ([]netip.Addr, error) {
+	addrs, err := r.LookupNetIP(ctx, network, host)
+	if err != nil {
+		return nil, err
+	}
+	out := addrs[:0]
+	for _, ip := range addrs {
+		ip = ip.Unmap()
+		if (network == "ip4" && !ip.Is4()) || (network == "ip6" && !ip.Is6()) {
+			continue
+		}
+		out = append(out, ip)
+	}
+	if len(out) == 0 {
+		return nil, &net.DNSError{Err: "no suitable address found", Name: host, IsNotFound: true}
+	}
+	if err := sinkholeError(host, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// LookupAddrPort looks up address in the host:port form using r, where port
+// may be either a number or a service name. Network is one of "tcp",
+// "tcp4", "tcp6", "udp", "udp4" and "udp6"; its suffix selects address
+// family as LookupNetIP network does.
+func LookupAddrPort(ctx context.Context, r *net.Resolver, network, address string) ([]netip.AddrPort, error) {
+	host, service, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	var ipNetwork string
+	switch {
+	case strings.HasPrefix(network, "tcp"), strings.HasPrefix(network, "udp"):
+		ipNetwork = "ip" + network[3:]
+	default:
+		return nil, net.UnknownNetworkError(network)
+	}
+	port, err := strconv.ParseUint(service, 10, 16)
+	if err != nil {
+		p, err := r.LookupPort(ctx, network, service)
+		if err != nil {
+			return nil, err
+		}
+		port = uint64(p)
+	}
+	addrs, err := LookupNetIP(ctx, r, ipNetwork, host)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]netip.AddrPort, len(addrs))
+	for i, ip := range addrs {
+		out[i] = netip.AddrPortFrom(ip, uint16(port))
+	}
+	return out, nil
+}
+
+// LookupNetAddr does reverse lookup of ip using r, returning names that map
+// to it.
+func LookupNetAddr(ctx context.Context, r *net.Resolver, ip netip.Addr) ([]string, error) {
+	return r.LookupAddr(ctx, ip.Unmap().WithZone("").String())
+}
+
+// LookupNetIP looks up host as the package-level LookupNetIP does, but
+// instead of going through net.Resolver, which may dial a separate
+// connection for every query, it sends A and AAAA queries concurrently over
+// the same pooled connection and merges their answers, IPv4 addresses first.
+// Use SortAddrs or InterleaveAddrs to order them for connecting.
+//
+// Host is looked up as is, as if it were a fully qualified name: neither the
+// hosts file nor the search list of the system resolver are consulted. If
+// one of the queries succeeds, failure of the other one is ignored. Besides
+// sinkhole addresses, answers marked by upstream with extended DNS errors
+// Blocked, Censored or Filtered are reported as *FilteredError.
+func (c *Client) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		ip = ip.Unmap()
+		if (network == "ip4" && !ip.Is4()) || (network == "ip6" && !ip.Is6()) {
+			return nil, &net.DNSError{Err: "no suitable address found", Name: host, IsNotFound: true}
+		}
+		return []netip.Addr{ip}, nil
+	}
+	var qtypes []dnsmessage.Type
+	switch network {
+	case "ip":
+		qtypes = []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+	case "ip4":
+		qtypes = []dnsmessage.Type{dnsmessage.TypeA}
+	case "ip6":
+		qtypes = []dnsmessage.Type{dnsmessage.TypeAAAA}
+	default:
+		return nil, net.UnknownNetworkError(network)
+	}
+	type result struct {
+		addrs []netip.Addr
+		err   error
+	}
+	results := make([]result, len(qtypes))
+	var wg sync.WaitGroup
+	for i, qtype := range qtypes {
+		wg.Add(1)
+		go func(res *result, qtype dnsmessage.Type) {
+			defer wg.Done()
+			msg, err := lookupRecords(ctx, c, host, qtype)
+			if err != nil {
+				res.err = err
+				return
+			}
+			for _, rr := range msg.Answers {
+				switch v := rr.Body.(type) {
+				case *dnsmessage.AResource:
+					res.addrs = append(res.addrs, netip.AddrFrom4(v.A))
+				case *dnsmessage.AAAAResource:
+					res.addrs = append(res.addrs, netip.AddrFrom16(v.AAAA).Unmap())
+				}
+			}
+		}(&results[i], qtype)
+	}
+	wg.Wait()
+	var out []netip.Addr
+	var err error
+	for _, res := range results {
+		out = append(out, res.addrs...)
+		var de *net.DNSError
+		if res.err != nil && (err == nil || errors.As(err, &de) && de.IsNotFound) {
+			err = res.err
+		}
+	}
+	if len(out) != 0 {
+		return out, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
@@ -0,0 +1,421 @@
+package dot
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// odohMediaType is the media type of Oblivious DoH messages, see RFC 9230,
+// section 8.1.
+const odohMediaType = "application/oblivious-dns-message"
+
+const (
+	odohVersion = 0x0001
+
+	odohMessageQuery    = 0x01
+	odohMessageResponse = 0x02
+
+	// HPKE algorithms of the only supported configuration: DHKEM(X25519,
+	// HKDF-SHA256), HKDF-SHA256 and AES-128-GCM.
+	hpkeKEMX25519  = 0x0020
+	hpkeKDFSHA256  = 0x0001
+	hpkeAEADAES128 = 0x0001
+
+	// odohConfigTTL is how long target configuration is used before it is
+	// fetched again.
+	odohConfigTTL = time.Hour
+)
+
+// ODoHClient is a Handler sending queries to Oblivious DNS-over-HTTPS target
+// through an oblivious proxy, as described in RFC 9230. Queries are
+// encrypted to the target's public key, so that proxy cannot see them, and
+// target cannot see client address, as it only talks to the proxy:
+//
+//	c := &dot.ODoHClient{
+//		Target: "https://odoh.cloudflare-dns.com/dns-query",
+//		Proxy:  "https://odoh-proxy.example/proxy",
+//	}
+//	r := dot.NewResolver(c)
+//
+// Target public key configuration is fetched directly from the target's
+// /.well-known/odohconfigs, and refreshed every hour, or once target rejects
+// a query. Only the mandatory-to-implement X25519, HKDF-SHA256 and
+// AES-128-GCM configuration is supported.
+//
+// ODoHClient is safe for concurrent use, its fields must not be changed once
+// it is used.
+type ODoHClient struct {
+	// Target is the URL of the ODoH target.
+	Target string
+
+	// Proxy is the URL of the oblivious proxy queries are sent through.
+	// If empty, queries are sent to Target directly, which hides them
+	// from intermediaries, but not client address from the target.
+	Proxy string
+
+	// HTTPClient is used to make requests, http.DefaultClient if nil. Use
+	// Transport if its host names should not be resolved in cleartext.
+	HTTPClient *http.Client
+
+	mu      sync.Mutex
+	config  *odohConfig
+	fetched time.Time
+}
+
+// ServeDNS implements Handler interface.
+func (c *ODoHClient) ServeDNS(ctx context.Context, query []byte) ([]byte, error) {
+	if len(query) < 12 {
+		return nil, errors.New("dot: query too short")
+	}
+	resp, err := c.exchange(ctx, query, false)
+	if errors.Is(err, errODoHRejected) {
+		resp, err = c.exchange(ctx, query, true)
+	}
+	return resp, err
+}
+
+// errODoHRejected is returned when target fails to decrypt query, which
+// usually means its key was rotated.
+var errODoHRejected = errors.New("dot: odoh target rejected query")
+
+func (c *ODoHClient) exchange(ctx context.Context, query []byte, refresh bool) ([]byte, error) {
+	cfg, err := c.getConfig(ctx, refresh)
+	if err != nil {
+		return nil, err
+	}
+	msg, st, err := cfg.sealQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	u, err := c.queryURL()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(msg))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", odohMediaType)
+	req.Header.Set("Accept", odohMediaType)
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 2*maxMsgSize))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized && !refresh:
+		return nil, errODoHRejected
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("dot: odoh request failed: %s", resp.Status)
+	}
+	return st.openResponse(body)
+}
+
+func (c *ODoHClient) queryURL() (string, error) {
+	if c.Proxy == "" {
+		return c.Target, nil
+	}
+	target, err := url.Parse(c.Target)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(c.Proxy)
+	if err != nil {
+		return "", err
+	}
+	vals := u.Query()
+	vals.Set("targethost", target.Host)
+	vals.Set("targetpath", target.EscapedPath())
+	u.RawQuery = vals.Encode()
+	return u.String(), nil
+}
+
+func (c *ODoHClient) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// getConfig returns target configuration, fetching it if it is missing,
+// stale, or refresh is true.
+func (c *ODoHClient) getConfig(ctx context.Context, refresh bool) (*odohConfig, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.config != nil && !refresh && time.Since(c.fetched) < odohConfigTTL {
+		return c.config, nil
+	}
+	target, err := url.Parse(c.Target)
+	if err != nil {
+		return nil, err
+	}
+	u := url.URL{Scheme: target.Scheme, Host: target.Host, Path: "/.well-known/odohconfigs"}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("dot: fetching odoh configs: %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxMsgSize))
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := parseODoHConfigs(body)
+	if err != nil {
+		return nil, err
+	}
+	c.config, c.fetched = cfg, time.Now()
+	return cfg, nil
+}
+
+// odohConfig is the supported ObliviousDoHConfigContents of the target, RFC
+// 9230, section 6.
+type odohConfig struct {
+	publicKey *ecdh.PublicKey
+	keyID     []byte
+}
+
+// parseODoHConfigs returns the first supported configuration of
+// ObliviousDoHConfigs structure.
+func parseODoHConfigs(b []byte) (*odohConfig, error) {
+	configs, b, ok := readVector(b)
+	if !ok || len(b) != 0 {
+		return nil, errors.New("dot: malformed odoh configs")
+	}
+	for len(configs) != 0 {
+		if len(configs) < 4 {
+			return nil, errors.New("dot: malformed odoh configs")
+		}
+		version := binary.BigEndian.Uint16(configs)
+		contents, rest, ok := readVector(configs[2:])
+		if !ok {
+			return nil, errors.New("dot: malformed odoh configs")
+		}
+		configs = rest
+		if version != odohVersion || len(contents) < 6 {
+			continue
+		}
+		kem := binary.BigEndian.Uint16(contents)
+		kdf := binary.BigEndian.Uint16(contents[2:])
+		aead := binary.BigEndian.Uint16(contents[4:])
+		pub, rest, ok := readVector(contents[6:])
+		if !ok || len(rest) != 0 || kem != hpkeKEMX25519 || kdf != hpkeKDFSHA256 || aead != hpkeAEADAES128 {
+			continue
+		}
+		pk, err := ecdh.X25519().NewPublicKey(pub)
+		if err != nil {
+			continue
+		}
+		return &odohConfig{
+			publicKey: pk,
+			keyID:     hkdfExpand(hkdfExtract(nil, contents), []byte("odoh key id"), sha256.Size),
+		}, nil
+	}
+	return nil, errors.New("dot: no supported odoh config")
+}
+
+// odohQueryState keeps what is needed to decrypt response to a query.
+type odohQueryState struct {
+	plaintext []byte
+	secret    []byte // exported from HPKE context
+}
+
+// sealQuery returns encrypted ObliviousDoHMessage carrying query, RFC 9230,
+// section 6.3.
+func (cfg *odohConfig) sealQuery(query []byte) ([]byte, *odohQueryState, error) {
+	// pad plaintext so that queries of similar length look the same
+	padding := (128 - (len(query)+4)%128) % 128
+	plain := appendVector(nil, query)
+	plain = appendVector(plain, make([]byte, padding))
+
+	enc, hc, err := hpkeSetupBaseS(cfg.publicKey, []byte("odoh query"))
+	if err != nil {
+		return nil, nil, err
+	}
+	aad := appendVector([]byte{odohMessageQuery}, cfg.keyID)
+	ct := hc.aead.Seal(nil, hc.baseNonce, plain, aad)
+
+	msg := appendVector([]byte{odohMessageQuery}, cfg.keyID)
+	msg = appendVector(msg, append(enc, ct...))
+	st := &odohQueryState{
+		plaintext: plain,
+		secret:    hc.export([]byte("odoh response"), 16),
+	}
+	return msg, st, nil
+}
+
+// openResponse decrypts ObliviousDoHMessage response, RFC 9230, section 6.4,
+// returning DNS message it carries.
+func (st *odohQueryState) openResponse(msg []byte) ([]byte, error) {
+	if len(msg) < 1 || msg[0] != odohMessageResponse {
+		return nil, errors.New("dot: malformed odoh response")
+	}
+	nonce, rest, ok := readVector(msg[1:])
+	if !ok {
+		return nil, errors.New("dot: malformed odoh response")
+	}
+	ct, rest, ok := readVector(rest)
+	if !ok || len(rest) != 0 {
+		return nil, errors.New("dot: malformed odoh response")
+	}
+	salt := appendVector(append([]byte(nil), st.plaintext...), nonce)
+	prk := hkdfExtract(salt, st.secret)
+	key := hkdfExpand(prk, []byte("odoh key"), 16)
+	aeadNonce := hkdfExpand(prk, []byte("odoh nonce"), 12)
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	aad := appendVector([]byte{odohMessageResponse}, nonce)
+	plain, err := aead.Open(nil, aeadNonce, ct, aad)
+	if err != nil {
+		return nil, errors.New("dot: failed to decrypt odoh response")
+	}
+	resp, _, ok := readVector(plain)
+	if !ok || len(resp) < 12 {
+		return nil, errors.New("dot: malformed odoh response")
+	}
+	return resp, nil
+}
+
+// hpkeContext is the sender context of HPKE base mode, RFC 9180, section 5.1,
+// for single-shot encryption.
+type hpkeContext struct {
+	aead           cipher.AEAD
+	baseNonce      []byte
+	exporterSecret []byte
+	suiteID        []byte
+}
+
+// hpkeSetupBaseS sets up HPKE base mode sender context for DHKEM(X25519,
+// HKDF-SHA256), HKDF-SHA256 and AES-128-GCM, returning encapsulated key and
+// the context.
+func hpkeSetupBaseS(pkR *ecdh.PublicKey, info []byte) (enc []byte, hc *hpkeContext, err error) {
+	skE, err := ecdh.X25519().GenerateKey(crand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	dh, err := skE.ECDH(pkR)
+	if err != nil {
+		return nil, nil, err
+	}
+	enc = skE.PublicKey().Bytes()
+	kemSuite := []byte{'K', 'E', 'M', hpkeKEMX25519 >> 8, hpkeKEMX25519 & 0xff}
+	kemContext := append(append([]byte(nil), enc...), pkR.Bytes()...)
+	eaePRK := labeledExtract(kemSuite, nil, "eae_prk", dh)
+	sharedSecret := labeledExpand(kemSuite, eaePRK, "shared_secret", kemContext, 32)
+
+	suite := []byte{'H', 'P', 'K', 'E',
+		hpkeKEMX25519 >> 8, hpkeKEMX25519 & 0xff,
+		hpkeKDFSHA256 >> 8, hpkeKDFSHA256 & 0xff,
+		hpkeAEADAES128 >> 8, hpkeAEADAES128 & 0xff}
+	pskIDHash := labeledExtract(suite, nil, "psk_id_hash", nil)
+	infoHash := labeledExtract(suite, nil, "info_hash", info)
+	ksContext := append(append([]byte{0}, pskIDHash...), infoHash...) // mode_base
+	secret := labeledExtract(suite, sharedSecret, "secret", nil)
+	key := labeledExpand(suite, secret, "key", ksContext, 16)
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return enc, &hpkeContext{
+		aead:           aead,
+		baseNonce:      labeledExpand(suite, secret, "base_nonce", ksContext, 12),
+		exporterSecret: labeledExpand(suite, secret, "exp", ksContext, sha256.Size),
+		suiteID:        suite,
+	}, nil
+}
+
+// export derives secret from context, RFC 9180, section 5.3.
+func (hc *hpkeContext) export(exporterContext []byte, length int) []byte {
+	return labeledExpand(hc.suiteID, hc.exporterSecret, "sec", exporterContext, length)
+}
+
+func labeledExtract(suiteID, salt []byte, label string, ikm []byte) []byte {
+	b := append([]byte("HPKE-v1"), suiteID...)
+	b = append(b, label...)
+	return hkdfExtract(salt, append(b, ikm...))
+}
+
+func labeledExpand(suiteID, prk []byte, label string, info []byte, length int) []byte {
+	b := binary.BigEndian.AppendUint16(nil, uint16(length))
+	b = append(b, "HPKE-v1"...)
+	b = append(b, suiteID...)
+	b = append(b, label...)
+	return hkdfExpand(prk, append(b, info...), length)
+}
+
+// hkdfExtract and hkdfExpand implement HKDF-SHA256 as described in RFC 5869.
+func hkdfExtract(salt, ikm []byte) []byte {
+	if salt == nil {
+		salt = make([]byte, sha256.Size)
+	}
+	h := hmac.New(sha256.New, salt)
+	h.Write(ikm)
+	return h.Sum(nil)
+}
+
+func hkdfExpand(prk, info []byte, length int) []byte {
+	var out, t []byte
+	h := hmac.New(sha256.New, prk)
+	for i := byte(1); len(out) < length; i++ {
+		h.Reset()
+		h.Write(t)
+		h.Write(info)
+		h.Write([]byte{i})
+		t = h.Sum(nil)
+		out = append(out, t...)
+	}
+	return out[:length]
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// appendVector appends b prefixed with its two-byte length.
+func appendVector(dst, b []byte) []byte {
+	dst = binary.BigEndian.AppendUint16(dst, uint16(len(b)))
+	return append(dst, b...)
+}
+
+// readVector reads vector prefixed with its two-byte length, returning it
+// along with the rest of b.
+func readVector(b []byte) (v, rest []byte, ok bool) {
+	if len(b) < 2 {
+		return nil, nil, false
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return nil, nil, false
+	}
+	return b[2 : 2+n], b[2+n:], true
+}
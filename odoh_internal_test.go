@@ -0,0 +1,61 @@
+package dot
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestHKDF(t *testing.T) {
+	// RFC 5869, appendix A.1
+	unhex := func(s string) []byte {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	ikm := bytes.Repeat([]byte{0x0b}, 22)
+	salt := unhex("000102030405060708090a0b0c")
+	info := unhex("f0f1f2f3f4f5f6f7f8f9")
+	prk := hkdfExtract(salt, ikm)
+	if want := unhex("077709362c2e32df0ddc3f0dc47bba6390b6c73bb50f9c3122ec844ad7c2b3e5"); !bytes.Equal(prk, want) {
+		t.Errorf("got PRK %x, want %x", prk, want)
+	}
+	okm := hkdfExpand(prk, info, 42)
+	if want := unhex("3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865"); !bytes.Equal(okm, want) {
+		t.Errorf("got OKM %x, want %x", okm, want)
+	}
+}
+
+func TestParseODoHConfigs(t *testing.T) {
+	pub := bytes.Repeat([]byte{9}, 32) // X25519 base point
+	supported := appendVector([]byte{0x00, 0x20, 0x00, 0x01, 0x00, 0x01}, pub)
+	config := func(version uint16, contents []byte) []byte {
+		return appendVector([]byte{byte(version >> 8), byte(version)}, contents)
+	}
+	for _, tc := range []struct {
+		name string
+		b    []byte
+		ok   bool
+	}{
+		{"supported", appendVector(nil, config(odohVersion, supported)), true},
+		{"after unsupported", appendVector(nil, append(config(0xff03, supported), config(odohVersion, supported)...)), true},
+		{"unknown version", appendVector(nil, config(0xff03, supported)), false},
+		{"other aead", appendVector(nil, config(odohVersion, appendVector([]byte{0x00, 0x20, 0x00, 0x01, 0x00, 0x03}, pub))), false},
+		{"short key", appendVector(nil, config(odohVersion, appendVector([]byte{0x00, 0x20, 0x00, 0x01, 0x00, 0x01}, pub[:31]))), false},
+		{"trailing data", append(appendVector(nil, config(odohVersion, supported)), 0), false},
+		{"truncated", appendVector(nil, config(odohVersion, supported))[:20], false},
+		{"empty", nil, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := parseODoHConfigs(tc.b)
+			if (err == nil) != tc.ok {
+				t.Fatalf("got error %v, want ok=%t", err, tc.ok)
+			}
+			if err == nil && (!bytes.Equal(cfg.publicKey.Bytes(), pub) || len(cfg.keyID) != 32) {
+				t.Errorf("got config with key %x, key ID %x", cfg.publicKey.Bytes(), cfg.keyID)
+			}
+		})
+	}
+}
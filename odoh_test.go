@@ -0,0 +1,261 @@
+//go:build go1.26
+
+package dot_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hpke"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/artyom/dot"
+	"github.com/artyom/dot/dottest"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// odohTarget is an Oblivious DoH target implemented after RFC 9230 with
+// crypto/hpke, answering queries with h.
+type odohTarget struct {
+	h dot.Handler
+
+	mu      sync.Mutex
+	key     *ecdh.PrivateKey
+	keyID   []byte
+	configs []byte
+	padded  bool // all query plaintexts were padded to 128 bytes
+
+	fetches atomic.Int32 // config requests
+}
+
+func newODoHTarget(t *testing.T, h dot.Handler) *odohTarget {
+	tg := &odohTarget{h: h, padded: true}
+	tg.rotate(t)
+	return tg
+}
+
+// rotate replaces target key, as targets periodically do.
+func (tg *odohTarget) rotate(t *testing.T) {
+	t.Helper()
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := []byte{0x00, 0x20, 0x00, 0x01, 0x00, 0x01} // X25519, HKDF-SHA256, AES-128-GCM
+	contents = appendVec(contents, key.PublicKey().Bytes())
+	prk, err := hkdf.Extract(sha256.New, contents, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID, err := hkdf.Expand(sha256.New, prk, "odoh key id", sha256.Size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// clients must skip configurations they do not support
+	unsupported := appendVec([]byte{0x00, 0x10, 0x00, 0x01, 0x00, 0x01}, make([]byte, 65)) // P-256
+	var configs []byte
+	configs = appendVec(binary.BigEndian.AppendUint16(configs, 0x0001), unsupported)
+	configs = appendVec(binary.BigEndian.AppendUint16(configs, 0x0001), contents)
+
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+	tg.key, tg.keyID, tg.configs = key, keyID, appendVec(nil, configs)
+}
+
+func (tg *odohTarget) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tg.mu.Lock()
+	key, keyID, configs := tg.key, tg.keyID, tg.configs
+	tg.mu.Unlock()
+	if r.Method == http.MethodGet && r.URL.Path == "/.well-known/odohconfigs" {
+		tg.fetches.Add(1)
+		w.Write(configs)
+		return
+	}
+	if r.Method != http.MethodPost || r.URL.Path != "/dns-query" ||
+		r.Header.Get("Content-Type") != "application/oblivious-dns-message" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	msg, _ := io.ReadAll(r.Body)
+	if len(msg) < 1 || msg[0] != 0x01 {
+		http.Error(w, "not a query", http.StatusBadRequest)
+		return
+	}
+	gotKeyID, rest, ok := readVec(msg[1:])
+	if !ok || !bytes.Equal(gotKeyID, keyID) {
+		http.Error(w, "unknown key", http.StatusUnauthorized)
+		return
+	}
+	encrypted, _, ok := readVec(rest)
+	if !ok || len(encrypted) < 32 {
+		http.Error(w, "malformed query", http.StatusBadRequest)
+		return
+	}
+	priv, err := hpke.NewDHKEMPrivateKey(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rcpt, err := hpke.NewRecipient(encrypted[:32], priv, hpke.HKDFSHA256(), hpke.AES128GCM(), []byte("odoh query"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	plain, err := rcpt.Open(appendVec([]byte{0x01}, keyID), encrypted[32:])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if len(plain)%128 != 0 {
+		tg.mu.Lock()
+		tg.padded = false
+		tg.mu.Unlock()
+	}
+	query, _, ok := readVec(plain)
+	if !ok {
+		http.Error(w, "malformed query", http.StatusBadRequest)
+		return
+	}
+	resp, err := tg.h.ServeDNS(r.Context(), query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	// RFC 9230, section 6.4
+	secret, err := rcpt.Export("odoh response", 16)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	prk, _ := hkdf.Extract(sha256.New, secret, appendVec(append([]byte(nil), plain...), nonce))
+	aeadKey, _ := hkdf.Expand(sha256.New, prk, "odoh key", 16)
+	aeadNonce, _ := hkdf.Expand(sha256.New, prk, "odoh nonce", 12)
+	block, _ := aes.NewCipher(aeadKey)
+	aead, _ := cipher.NewGCM(block)
+	ct := aead.Seal(nil, aeadNonce, appendVec(appendVec(nil, resp), nil), appendVec([]byte{0x02}, nonce))
+	w.Header().Set("Content-Type", "application/oblivious-dns-message")
+	w.Write(appendVec(appendVec([]byte{0x02}, nonce), ct))
+}
+
+// odohProxy is an oblivious proxy forwarding queries to targets over
+// plain HTTP, recording targets it was asked to use.
+type odohProxy struct {
+	mu      sync.Mutex
+	targets []string
+}
+
+func (p *odohProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	target := url.URL{Scheme: "http", Host: q.Get("targethost"), Path: q.Get("targetpath")}
+	p.mu.Lock()
+	p.targets = append(p.targets, target.String())
+	p.mu.Unlock()
+	resp, err := http.Post(target.String(), r.Header.Get("Content-Type"), r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+func appendVec(dst, b []byte) []byte {
+	dst = binary.BigEndian.AppendUint16(dst, uint16(len(b)))
+	return append(dst, b...)
+}
+
+func readVec(b []byte) (v, rest []byte, ok bool) {
+	if len(b) < 2 || len(b) < 2+int(binary.BigEndian.Uint16(b)) {
+		return nil, nil, false
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	return b[2 : 2+n], b[2+n:], true
+}
+
+func TestODoHClient(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		proxy  bool
+		rotate bool // target key is rotated after the first query
+	}{
+		{name: "direct"},
+		{name: "proxied", proxy: true},
+		{name: "key rotated", proxy: true, rotate: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tg := newODoHTarget(t, dottest.Zone{"example.com": {"192.0.2.1"}})
+			target := httptest.NewServer(tg)
+			defer target.Close()
+			p := new(odohProxy)
+			c := &dot.ODoHClient{Target: target.URL + "/dns-query"}
+			if tc.proxy {
+				proxy := httptest.NewServer(p)
+				defer proxy.Close()
+				c.Proxy = proxy.URL + "/proxy"
+			}
+			for i := range 2 {
+				if i == 1 && tc.rotate {
+					tg.rotate(t)
+				}
+				msg, err := serveQuery(t, c, "example.com", dnsmessage.TypeA)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if addrs := answerAddrs(msg); len(addrs) != 1 || addrs[0].String() != "192.0.2.1" {
+					t.Fatalf("got answer %v, want 192.0.2.1", addrs)
+				}
+			}
+			wantFetches := int32(1)
+			if tc.rotate {
+				wantFetches = 2
+			}
+			if n := tg.fetches.Load(); n != wantFetches {
+				t.Errorf("configs fetched %d times, want %d", n, wantFetches)
+			}
+			tg.mu.Lock()
+			if !tg.padded {
+				t.Error("query plaintext was not padded")
+			}
+			tg.mu.Unlock()
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			for _, u := range p.targets {
+				if u != c.Target {
+					t.Errorf("proxy was asked to forward query to %s, want %s", u, c.Target)
+				}
+			}
+			if tc.proxy && len(p.targets) == 0 {
+				t.Error("queries did not go through proxy")
+			}
+		})
+	}
+}
+
+func TestODoHClientNoConfig(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// only an unsupported configuration
+		config := appendVec([]byte{0x00, 0x01}, appendVec([]byte{0x00, 0x10, 0x00, 0x01, 0x00, 0x01}, make([]byte, 65)))
+		w.Write(appendVec(nil, config))
+	}))
+	defer target.Close()
+	c := &dot.ODoHClient{Target: target.URL + "/dns-query"}
+	if _, err := serveQuery(t, c, "example.com", dnsmessage.TypeA); err == nil {
+		t.Fatal("query succeeded without supported target configuration")
+	}
+}
@@ -0,0 +1,286 @@
+package dot
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Option configures Resolver returned by this package's functions.
+type Option func(*config)
+
+type config struct {
+	rootCAs        *x509.CertPool
+	handshakeHooks []func(tls.ConnectionState)
+	verifiers      []func(tls.ConnectionState) error
+	pick           func(addrs []string) string
+	timeout        time.Duration
+	port           string
+	noResumption   bool
+	sessionCache   tls.ClientSessionCache
+	noPooling      bool
+	fastOpen       bool
+	postQuantum    pqMode
+	fips           bool
+	keepWarm       time.Duration
+	dialer         func(ctx context.Context, network, address string) (net.Conn, error)
+	tlsClient      func(conn net.Conn, config *tls.Config) net.Conn
+}
+
+type pqMode uint8
+
+const (
+	pqDefault pqMode = iota
+	pqPreferred
+	pqRequired
+)
+
+func newConfig(opts []Option) config {
+	var c config
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// WithPort returns Option that makes Resolver connect to upstream addresses
+// on given port instead of the ones addresses have, e.g. to reach built-in
+// provider that also serves DNS-over-TLS on port 443 on a network blocking
+// port 853:
+//
+//	r := dot.Cloudflare(dot.WithPort(443))
+//
+// Zero port keeps addresses as is.
+func WithPort(port uint16) Option {
+	return func(c *config) {
+		if port == 0 {
+			c.port = ""
+			return
+		}
+		c.port = strconv.Itoa(int(port))
+	}
+}
+
+// WithoutResumption returns Option that disables TLS session resumption, so
+// that every connection does a full handshake and upstream cannot link
+// connections to each other by session tickets. It makes new connections
+// slower, since resumed handshakes skip certificate exchange.
+func WithoutResumption() Option {
+	return func(c *config) { c.noResumption = true }
+}
+
+// WithSessionCache returns Option that makes Resolver keep TLS sessions for
+// resumption in cache instead of its own one, so that resolvers and clients
+// created with the same cache share resumption state. Sessions are keyed by
+// server name, so cache can be shared between resolvers of different
+// upstreams:
+//
+//	cache := tls.NewLRUClientSessionCache(0)
+//	primary := dot.Quad9(dot.WithSessionCache(cache))
+//	fallback := dot.Cloudflare(dot.WithSessionCache(cache))
+//
+// To also share pooled connections, share a single Client instead, e.g. by
+// passing it to several Router routes. WithoutResumption overrides this
+// option.
+func WithSessionCache(cache tls.ClientSessionCache) Option {
+	return func(c *config) { c.sessionCache = cache }
+}
+
+// WithoutPooling returns Option that makes Client send every query over a
+// new connection, closed once response is received, as Resolver returned by
+// New does. Combined with WithoutResumption, it keeps upstream from linking
+// queries to each other by connection, at the cost of a handshake per query.
+// It has no effect on Resolver.
+func WithoutPooling() Option {
+	return func(c *config) { c.noPooling = true }
+}
+
+// WithFastOpen returns Option that enables TCP Fast Open on connections to
+// upstream, so that TLS handshake starts with the TCP one, saving a round
+// trip on connections to upstreams that support it. Previously unknown
+// upstreams are reached with a regular handshake, and if upstream does not
+// support it, connections fall back to it transparently.
+//
+// It is only supported on Linux with net.ipv4.tcp_fastopen sysctl enabling
+// client side, and has no effect elsewhere.
+func WithFastOpen() Option {
+	return func(c *config) { c.fastOpen = true }
+}
+
+// WithPostQuantum returns Option that makes Resolver prefer hybrid
+// post-quantum key exchange (X25519MLKEM768) in TLS handshakes with
+// upstream, falling back to classic key exchange if upstream does not
+// support it. It protects recorded traffic from being decrypted with a
+// quantum computer later.
+//
+// It requires Go 1.24 or newer, with older versions it has no effect. It is
+// also disabled by GODEBUG=tlsmlkem=0 setting, which is the default for
+// programs whose main module go.mod declares go version older than 1.24;
+// such programs should override it with "//go:debug tlsmlkem=1" directive.
+func WithPostQuantum() Option {
+	return func(c *config) { c.postQuantum = pqPreferred }
+}
+
+// WithPostQuantumRequired is like WithPostQuantum, but it makes handshakes
+// with upstreams that do not support post-quantum key exchange fail. With Go
+// versions older than 1.24, or with post-quantum key exchange disabled by
+// GODEBUG, all handshakes fail.
+func WithPostQuantumRequired() Option {
+	return func(c *config) { c.postQuantum = pqRequired }
+}
+
+// WithFIPS returns Option restricting TLS connections to upstream to protocol
+// versions, cipher suites and key exchanges approved by FIPS 140-3: TLS 1.2
+// with ECDHE and AES-GCM, or TLS 1.3 with AES-GCM, using P-256 or P-384
+// curves. Use it to get the same behavior regardless of whether the program
+// runs in Go FIPS 140-3 mode, which enforces a similar restriction by
+// itself; see https://go.dev/doc/security/fips140.
+//
+// crypto/tls does not allow to configure TLS 1.3 cipher suites, so outside
+// of FIPS 140-3 mode handshakes negotiating TLS_CHACHA20_POLY1305_SHA256 are
+// made to fail. Go prefers that suite on CPUs without AES hardware support,
+// so there handshakes with upstreams honoring client preference fail too.
+//
+// WithFIPS overrides WithPostQuantum, as hybrid post-quantum key exchanges
+// are not approved; combined with WithPostQuantumRequired, it makes every
+// connection attempt fail with an error.
+//
+// It only affects DNS-over-TLS connections: ODoHClient and DNSCryptClient
+// rely on cryptography FIPS 140-3 does not approve.
+func WithFIPS() Option {
+	return func(c *config) { c.fips = true }
+}
+
+// WithKeepWarm returns Option that makes Client keep its last idle pooled
+// connection open, sending a lightweight query over it every interval, so
+// that it is not silently dropped by NATs or firewalls, and the next query
+// does not have to establish a new connection. If probe query fails,
+// connection is closed. Other idle connections are closed once interval
+// passes. It has no effect on Resolver, and is disabled by default.
+func WithKeepWarm(interval time.Duration) Option {
+	return func(c *config) { c.keepWarm = interval }
+}
+
+// WithDialer returns Option that makes Resolver establish connections to
+// upstream with fn instead of net.Dialer, e.g. to reach it through an SSH
+// tunnel, a userspace WireGuard stack or another custom transport:
+//
+//	r := dot.Quad9(dot.WithDialer(sshClient.DialContext))
+//
+// fn is called with "tcp" network and the upstream address picked for the
+// connection; TLS handshake and DNS message framing are done over the
+// connection it returns as usual. WithFastOpen has no effect with custom
+// dialer. fn must be safe for concurrent use.
+func WithDialer(fn func(ctx context.Context, network, address string) (net.Conn, error)) Option {
+	return func(c *config) { c.dialer = fn }
+}
+
+// WithTLSClient returns Option that makes Resolver establish TLS over
+// connections to upstream with fn instead of tls.Client, e.g. to use an
+// alternative TLS implementation as package dotutls does. fn is called with
+// the configuration tls.Client would be, which it must not modify; the
+// connection it returns must verify upstream certificate as config requires.
+//
+// For Client, Verify and WithHandshakeHook to work, returned connection
+// should have HandshakeContext(context.Context) error and ConnectionState()
+// tls.ConnectionState methods, as *tls.Conn has, and call
+// config.VerifyConnection after handshake.
+func WithTLSClient(fn func(conn net.Conn, config *tls.Config) net.Conn) Option {
+	return func(c *config) { c.tlsClient = fn }
+}
+
+// WithTimeout returns Option that limits every lookup to d if its context has
+// no deadline, so that code doing lookups with context.Background does not
+// hang on unresponsive upstream. For Resolver, which sets its own deadlines
+// on connections as configured in resolv.conf(5), it applies to dialing and
+// to exchanges done by Forward and Verify; for Client, it applies to the
+// whole Exchange call. Non-positive d disables the limit, which is the
+// default.
+func WithTimeout(d time.Duration) Option {
+	return func(c *config) { c.timeout = d }
+}
+
+// WithSelector returns Option that makes Resolver call fn to pick upstream
+// address for every new connection, instead of picking a random one. fn is
+// called with addresses Resolver was created with, adjusted by WithPort if
+// used, and must return one of them; it must be safe for concurrent use.
+func WithSelector(fn func(addrs []string) string) Option {
+	return func(c *config) { c.pick = fn }
+}
+
+// WithRand returns Option that makes Resolver use src as the source of
+// randomness when picking upstream address for every new connection. Use it
+// to make address selection reproducible, e.g. with rand.NewSource(1).
+func WithRand(src rand.Source) Option {
+	var mu sync.Mutex
+	rnd := rand.New(src)
+	return WithSelector(func(addrs []string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		return addrs[rnd.Intn(len(addrs))]
+	})
+}
+
+// WithRootCAs returns Option that makes Resolver verify upstream certificates
+// against given pool instead of the system one. Use it with servers that have
+// certificates issued by a private certificate authority.
+func WithRootCAs(pool *x509.CertPool) Option {
+	return func(c *config) { c.rootCAs = pool }
+}
+
+// WithHandshakeHook returns Option that makes fn to be called after every
+// successful TLS handshake with upstream, including resumed ones, to inspect
+// connection details such as upstream certificates. fn must be safe for
+// concurrent use.
+func WithHandshakeHook(fn func(tls.ConnectionState)) Option {
+	return func(c *config) {
+		if fn != nil {
+			c.handshakeHooks = append(c.handshakeHooks, fn)
+		}
+	}
+}
+
+// WithExpiryWarning returns Option that makes fn to be called once any
+// certificate of the upstream's verified chain is found to expire within
+// window during TLS handshake. fn is called at most once per certificate, it
+// must be safe for concurrent use.
+//
+// Use it to get an early warning about upstream with failed certificate
+// renewal:
+//
+//	r := dot.New("dns.example.com", []string{"192.0.2.1:853"},
+//		dot.WithExpiryWarning(7*24*time.Hour, func(cert *x509.Certificate) {
+//			log.Printf("upstream certificate %s expires at %v", cert.Subject, cert.NotAfter)
+//		}))
+func WithExpiryWarning(window time.Duration, fn func(*x509.Certificate)) Option {
+	if fn == nil {
+		return func(*config) {}
+	}
+	var mu sync.Mutex
+	warned := make(map[string]struct{})
+	return WithHandshakeHook(func(cs tls.ConnectionState) {
+		chain := cs.PeerCertificates
+		if len(cs.VerifiedChains) != 0 {
+			chain = cs.VerifiedChains[0]
+		}
+		deadline := time.Now().Add(window)
+		for _, cert := range chain {
+			if cert.NotAfter.After(deadline) {
+				continue
+			}
+			key := string(cert.Raw)
+			mu.Lock()
+			_, seen := warned[key]
+			warned[key] = struct{}{}
+			mu.Unlock()
+			if !seen {
+				fn(cert)
+			}
+		}
+	})
+}
@@ -0,0 +1,138 @@
+package dot
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// ErrNoUpstreams is returned by Pool when it has no upstreams to send query
+// to.
+var ErrNoUpstreams = errors.New("dot: no upstreams available")
+
+// Pool is a Handler that spreads queries among a dynamic set of named
+// upstreams, picking a random one for every query with probability
+// proportional to its weight. Upstreams can be added, removed or re-weighted
+// at any time, queries in flight are not affected. Use it when upstream
+// endpoints come from service discovery:
+//
+//	p := dot.NewPool()
+//	for _, addr := range endpoints {
+//		p.Set(addr, dot.NewClient("dns.example.com", []string{addr}), 1)
+//	}
+//	r := dot.NewResolver(p)
+//
+// Pool is safe for concurrent use.
+type Pool struct {
+	mu        sync.RWMutex
+	upstreams map[string]poolUpstream
+	total     int // sum of weights
+}
+
+type poolUpstream struct {
+	h      Handler
+	weight int
+}
+
+// PoolUpstream describes upstream of Pool.
+type PoolUpstream struct {
+	Name    string
+	Handler Handler
+	Weight  int
+}
+
+// NewPool returns empty Pool.
+func NewPool() *Pool {
+	return &Pool{upstreams: make(map[string]poolUpstream)}
+}
+
+// Set adds upstream h under given name, replacing upstream already known
+// under it, if any. Upstreams with non-positive weight are kept, but get no
+// queries, which can be used to drain upstream before removing it.
+func (p *Pool) Set(name string, h Handler, weight int) {
+	if h == nil {
+		panic("dot: nil Handler")
+	}
+	if weight < 0 {
+		weight = 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total -= p.upstreams[name].weight
+	p.upstreams[name] = poolUpstream{h: h, weight: weight}
+	p.total += weight
+}
+
+// SetWeight changes weight of upstream known under given name, reporting
+// whether there is such upstream.
+func (p *Pool) SetWeight(name string, weight int) bool {
+	if weight < 0 {
+		weight = 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	u, ok := p.upstreams[name]
+	if !ok {
+		return false
+	}
+	p.total += weight - u.weight
+	u.weight = weight
+	p.upstreams[name] = u
+	return true
+}
+
+// Remove removes upstream known under given name, returning it, or nil if
+// there is none. Queries in flight to removed upstream continue; if it is a
+// Client, call its Shutdown or Close method to release its connections once
+// they complete.
+func (p *Pool) Remove(name string) Handler {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	u, ok := p.upstreams[name]
+	if !ok {
+		return nil
+	}
+	delete(p.upstreams, name)
+	p.total -= u.weight
+	return u.h
+}
+
+// Upstreams returns current upstreams ordered by name.
+func (p *Pool) Upstreams() []PoolUpstream {
+	p.mu.RLock()
+	out := make([]PoolUpstream, 0, len(p.upstreams))
+	for name, u := range p.upstreams {
+		out = append(out, PoolUpstream{Name: name, Handler: u.h, Weight: u.weight})
+	}
+	p.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// ServeDNS implements Handler interface. If pool has no upstreams with
+// positive weight, it returns ErrNoUpstreams.
+func (p *Pool) ServeDNS(ctx context.Context, query []byte) ([]byte, error) {
+	h := p.pick()
+	if h == nil {
+		return nil, ErrNoUpstreams
+	}
+	return h.ServeDNS(ctx, query)
+}
+
+func (p *Pool) pick() Handler {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.total <= 0 {
+		return nil
+	}
+	n := rand.Intn(p.total)
+	for _, u := range p.upstreams {
+		if n < u.weight {
+			return u.h
+		}
+		n -= u.weight
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build go1.24
+
+package dot
+
+import "crypto/tls"
+
+// postQuantumCurves lists hybrid post-quantum key exchanges supported by
+// crypto/tls, most preferred first.
+var postQuantumCurves = []tls.CurveID{tls.X25519MLKEM768}
@@ -0,0 +1,9 @@
+//go:build !go1.24
+
+package dot
+
+import "crypto/tls"
+
+// postQuantumCurves is empty, crypto/tls only supports post-quantum key
+// exchange since Go 1.24.
+var postQuantumCurves []tls.CurveID
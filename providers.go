@@ -0,0 +1,49 @@
+package dot
+
+import (
+	"net"
+	"strings"
+)
+
+// Provider is one of the built-in DNS-over-TLS services.
+type Provider struct {
+	// Name is a short lowercase name of the provider, e.g. "cloudflare".
+	Name string
+
+	// Resolver is the function returning Resolver for this provider,
+	// e.g. Cloudflare.
+	Resolver func(opts ...Option) *net.Resolver
+
+	serverName string
+	addrs      []string
+}
+
+var providers = []Provider{
+	{Name: "cloudflare", Resolver: Cloudflare, serverName: "cloudflare-dns.com", addrs: []string{"1.1.1.1:853", "1.0.0.1:853"}},
+	{Name: "google", Resolver: Google, serverName: "dns.google", addrs: []string{"8.8.8.8:853", "8.8.4.4:853"}},
+	{Name: "libreops", Resolver: LibreOps, serverName: "dot.libredns.gr", addrs: []string{"116.202.176.26:853"}},
+	{Name: "quad9", Resolver: Quad9, serverName: "dns.quad9.net", addrs: []string{"9.9.9.9:853", "149.112.112.112:853"}},
+}
+
+// Client returns Client using the same service as Resolver does, with
+// pooled connections reused across queries.
+func (p Provider) Client(opts ...Option) *Client {
+	return NewClient(p.serverName, p.addrs, opts...)
+}
+
+// Providers returns all built-in providers ordered by name.
+func Providers() []Provider {
+	out := make([]Provider, len(providers))
+	copy(out, providers)
+	return out
+}
+
+// LookupProvider returns built-in provider by its name, ignoring case.
+func LookupProvider(name string) (Provider, bool) {
+	for _, p := range providers {
+		if strings.EqualFold(p.Name, name) {
+			return p, true
+		}
+	}
+	return Provider{}, false
+}
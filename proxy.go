@@ -0,0 +1,107 @@
+package dot
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"time"
+)
+
+// ErrProxyClosed is returned by Proxy's Serve and ListenAndServe methods after
+// a call to Shutdown or Close.
+var ErrProxyClosed = errors.New("dot: proxy closed")
+
+// Proxy is a local stub resolver: it accepts plain DNS queries over UDP and
+// TCP and forwards them upstream over DNS-over-TLS.
+//
+// Zero value is not usable, at least Resolver or Handler must be set.
+type Proxy struct {
+	// Addr is the UDP and TCP address to listen on, ":53" if empty.
+	Addr string
+
+	// Resolver is used to reach upstream. It must be the one returned by
+	// one of this package's functions, or otherwise have its Dial field
+	// set to a function returning stream connections.
+	Resolver *net.Resolver
+
+	// Handler, if set, answers queries instead of forwarding them to
+	// Resolver directly. Use it to put a Cache in front of upstream:
+	//
+	//	p := &dot.Proxy{Handler: dot.NewCache(dot.Forward(dot.Quad9()), 1000)}
+	Handler Handler
+
+	// MaxQueries limits the number of queries forwarded concurrently, 100
+	// if zero. Once the limit is reached, proxy stops reading new queries
+	// until some of the in-flight ones complete.
+	MaxQueries int
+
+	// Timeout limits how long a single query may take upstream, 5 seconds
+	// if zero. Queries that fail or time out upstream are answered with
+	// SERVFAIL.
+	Timeout time.Duration
+
+	// ErrorLog specifies an optional logger for upstream and connection
+	// errors. If nil, errors are not logged.
+	ErrorLog *log.Logger
+
+	svc service
+}
+
+// ListenAndServe listens on both UDP and TCP on p.Addr and then calls Serve
+// to handle queries. It always returns a non-nil error; after Shutdown or
+// Close, the returned error is ErrProxyClosed.
+func (p *Proxy) ListenAndServe() error {
+	addr := p.Addr
+	if addr == "" {
+		addr = ":53"
+	}
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		pc.Close()
+		return err
+	}
+	return p.Serve(pc, l)
+}
+
+// Serve accepts queries on pc and l, forwarding them upstream. Either pc or l
+// may be nil, but not both. Serve always returns a non-nil error and closes
+// pc and l; after Shutdown or Close, the returned error is ErrProxyClosed.
+func (p *Proxy) Serve(pc net.PacketConn, l net.Listener) error {
+	if pc == nil && l == nil {
+		return errors.New("dot: no packet connection or listener to serve")
+	}
+	if p.Handler == nil && p.Resolver == nil {
+		return errors.New("dot: proxy has neither Handler nor Resolver")
+	}
+	p.init()
+	return p.svc.serve(pc, l)
+}
+
+// Shutdown gracefully shuts down the proxy: it stops accepting new queries,
+// waits for in-flight queries to be answered, then closes all listeners and
+// connections. If ctx expires before that, Shutdown closes everything and
+// returns context's error.
+func (p *Proxy) Shutdown(ctx context.Context) error {
+	p.init()
+	return p.svc.shutdown(ctx)
+}
+
+// Close immediately closes all listeners and connections, aborting queries
+// in flight. For a graceful shutdown, use Shutdown.
+func (p *Proxy) Close() error {
+	p.init()
+	return p.svc.close()
+}
+
+func (p *Proxy) init() {
+	h := p.Handler
+	if h == nil {
+		h = Forward(p.Resolver)
+	}
+	p.svc.init(ErrProxyClosed, h, p.MaxQueries, p.Timeout, p.ErrorLog)
+}
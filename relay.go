@@ -0,0 +1,75 @@
+package dot
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// dnscryptRelayMagic prefixes packets sent through anonymized DNSCrypt
+// relays, as described at
+// https://github.com/DNSCrypt/dnscrypt-protocol/blob/master/ANONYMIZED-DNSCRYPT.txt.
+var dnscryptRelayMagic = []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00, 0x00}
+
+// dnscryptRoundTrip sends DNSCrypt packet msg to server over network and
+// reads response. If relay is not nil, msg is sent to that anonymized relay
+// instead, prefixed with the address of server for relay to forward it to:
+// server then only sees relay address, while relay only sees encrypted
+// packets.
+func dnscryptRoundTrip(ctx context.Context, network string, server, relay *Stamp, msg []byte) ([]byte, error) {
+	addr := server.Addr
+	if relay != nil {
+		if relay.Proto != StampDNSCryptRelay {
+			return nil, errors.New("dot: relay stamp must be a DNSCrypt relay one")
+		}
+		var err error
+		if msg, err = relayedPacket(server.Addr, msg); err != nil {
+			return nil, err
+		}
+		addr = relay.Addr
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if d, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(d)
+	}
+	stop := closeOnDone(ctx, conn)
+	defer stop()
+	if network == "tcp" {
+		if err := writeMsg(conn, msg); err != nil {
+			return nil, err
+		}
+		return readMsg(conn)
+	}
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, maxMsgSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// relayedPacket returns msg prefixed with relay header addressing it to
+// server, which must be given as IP address and port.
+func relayedPacket(server string, msg []byte) ([]byte, error) {
+	ap, err := netip.ParseAddrPort(server)
+	if err != nil {
+		return nil, fmt.Errorf("dot: relayed DNSCrypt server address must be an IP one: %w", err)
+	}
+	ip := ap.Addr().As16() // IPv4 addresses are mapped
+	b := make([]byte, 0, len(dnscryptRelayMagic)+16+2+len(msg))
+	b = append(b, dnscryptRelayMagic...)
+	b = append(b, ip[:]...)
+	b = binary.BigEndian.AppendUint16(b, ap.Port())
+	return append(b, msg...), nil
+}
@@ -0,0 +1,70 @@
+package dot
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRelayedPacket(t *testing.T) {
+	for _, tc := range []struct {
+		server string
+		addr   []byte // IPv6 or mapped IPv4 address followed by port
+		ok     bool
+	}{
+		{"192.0.2.1:443", []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 192, 0, 2, 1, 0x01, 0xbb}, true},
+		{"[2001:db8::1]:8443", []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0x20, 0xfb}, true},
+		{"dnscrypt.example:443", nil, false},
+		{"192.0.2.1", nil, false},
+	} {
+		b, err := relayedPacket(tc.server, []byte("query"))
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: got error %v, want ok=%t", tc.server, err, tc.ok)
+			continue
+		}
+		if !tc.ok {
+			continue
+		}
+		want := append(append(append([]byte(nil), dnscryptRelayMagic...), tc.addr...), "query"...)
+		if !bytes.Equal(b, want) {
+			t.Errorf("%s: got packet %x, want %x", tc.server, b, want)
+		}
+	}
+}
+
+func TestDNSCryptRoundTripRelay(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	server := &Stamp{Proto: StampDNSCrypt, Addr: "192.0.2.1:443"}
+	relay := &Stamp{Proto: StampDNSCryptRelay, Addr: pc.LocalAddr().String()}
+	go func() {
+		// relay forwards nothing, only answers packets addressed right
+		buf := make([]byte, 512)
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		want, _ := relayedPacket(server.Addr, []byte("encrypted query"))
+		if bytes.Equal(buf[:n], want) {
+			pc.WriteTo([]byte("encrypted response"), addr)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := dnscryptRoundTrip(ctx, "udp", server, relay, []byte("encrypted query"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != "encrypted response" {
+		t.Errorf("got response %q", resp)
+	}
+
+	if _, err := dnscryptRoundTrip(ctx, "udp", server, &Stamp{Proto: StampODoHRelay, Addr: relay.Addr}, nil); err == nil {
+		t.Error("round trip through non-DNSCrypt relay succeeded")
+	}
+}
@@ -0,0 +1,68 @@
+package dot
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Reloadable is a Handler passing queries to Handler built by a load
+// function, which can be rebuilt with Reload at any time, e.g. on SIGHUP, to
+// apply configuration changes without a restart:
+//
+//	r, err := dot.NewReloadable(func() (dot.Handler, error) {
+//		f := dot.NewFilter(upstream, dot.BlockNXDomain)
+//		file, err := os.Open("blocklist.txt")
+//		if err != nil {
+//			return nil, err
+//		}
+//		defer file.Close()
+//		return f, f.Block(file)
+//	})
+//
+// Queries in flight during Reload complete with the Handler they started
+// with. Reloadable does not release resources of replaced Handlers; if load
+// creates a Client, replaced one should be shut down by the caller.
+type Reloadable struct {
+	load func() (Handler, error)
+
+	mu  sync.Mutex // serializes reloads
+	cur atomic.Pointer[handlerHolder]
+}
+
+// handlerHolder lets Handler of any dynamic type be stored in atomic.Pointer.
+type handlerHolder struct{ h Handler }
+
+// NewReloadable returns Reloadable using Handler load returns, or load's
+// error.
+func NewReloadable(load func() (Handler, error)) (*Reloadable, error) {
+	r := &Reloadable{load: load}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload calls load function and switches to the Handler it returns. If load
+// fails, previous Handler is kept and error is returned.
+func (r *Reloadable) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h, err := r.load()
+	if err != nil {
+		return err
+	}
+	if h == nil {
+		panic("dot: nil Handler")
+	}
+	r.cur.Store(&handlerHolder{h: h})
+	return nil
+}
+
+// Handler returns current Handler.
+func (r *Reloadable) Handler() Handler { return r.cur.Load().h }
+
+// ServeDNS implements Handler interface.
+func (r *Reloadable) ServeDNS(ctx context.Context, query []byte) ([]byte, error) {
+	return r.cur.Load().h.ServeDNS(ctx, query)
+}
@@ -0,0 +1,12 @@
+//go:build !js
+
+package dot
+
+import "net"
+
+func newResolver(serverName string, addrs []string, opts []Option) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial:     newDialFunc(serverName, addrs, newConfig(opts)),
+	}
+}
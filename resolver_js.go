@@ -0,0 +1,28 @@
+//go:build js
+
+package dot
+
+import "net"
+
+// dohURLs maps server names of built-in providers to their DNS-over-HTTPS
+// service URLs, where those are not "https://<server name>/dns-query".
+var dohURLs = map[string]string{
+	"dot.libredns.gr": "https://doh.libredns.gr/dns-query",
+}
+
+// newResolver returns Resolver using DNS-over-HTTPS service of serverName,
+// since browsers do not allow raw TCP connections. Addresses and options
+// have no effect: connections and TLS are handled by the browser.
+func newResolver(serverName string, addrs []string, opts []Option) *net.Resolver {
+	if serverName == "" {
+		panic("dot: server name cannot be empty")
+	}
+	if len(addrs) == 0 {
+		panic("dot: addrs cannot be empty")
+	}
+	u, ok := dohURLs[serverName]
+	if !ok {
+		u = "https://" + serverName + "/dns-query"
+	}
+	return NewResolver(&DoHClient{URL: u})
+}
@@ -0,0 +1,110 @@
+package dot
+
+import (
+	"context"
+	crand "crypto/rand"
+	"errors"
+)
+
+type caseKey struct{}
+
+// WithCaseRandomization returns a copy of ctx that makes queries this
+// package builds itself, such as those of LookupSRV, LookupNAPTR and Verify,
+// have letters of their names randomly upper- or lowercased, and makes
+// responses be accepted only if their question preserves this exact case,
+// as described in draft-vixie-dnsext-dns0x20. Over DNS-over-TLS spoofing is
+// already hard, but it guards against misbehaving proxies and gateways that
+// may be in the path. Some upstreams do not preserve question case, lookups
+// through them fail with this option.
+func WithCaseRandomization(ctx context.Context) context.Context {
+	return context.WithValue(ctx, caseKey{}, true)
+}
+
+func caseRandomization(ctx context.Context) bool {
+	v, _ := ctx.Value(caseKey{}).(bool)
+	return v
+}
+
+// prepareQuery randomizes case of query name in place if ctx asks for it,
+// returning whether it did.
+func prepareQuery(ctx context.Context, query []byte) bool {
+	if !caseRandomization(ctx) {
+		return false
+	}
+	n := questionLen(query)
+	if n < 0 {
+		return false
+	}
+	rnd := make([]byte, n)
+	if _, err := crand.Read(rnd); err != nil {
+		return false
+	}
+	name := query[12 : 12+n-4]
+	for i := 0; i < len(name); {
+		l := int(name[i])
+		for j := i + 1; j <= i+l; j++ {
+			if c := name[j] | 0x20; 'a' <= c && c <= 'z' && rnd[j]&1 != 0 {
+				name[j] ^= 0x20
+			}
+		}
+		i += 1 + l
+	}
+	return true
+}
+
+// checkResponse reports whether resp is a response to query this package
+// built: it must have the same ID and question, with question name matching
+// exactly if exact is true, or ignoring ASCII case otherwise.
+func checkResponse(query, resp []byte, exact bool) error {
+	if len(resp) < 12 || len(query) < 12 || resp[0] != query[0] || resp[1] != query[1] {
+		return errors.New("dot: response id does not match query id")
+	}
+	if resp[2]&0x80 == 0 {
+		return errors.New("dot: response is not a DNS response")
+	}
+	n := questionLen(query)
+	if n < 0 || resp[4] != 0 || resp[5] != 1 || len(resp) < 12+n {
+		return errors.New("dot: response question does not match query")
+	}
+	q, r := query[12:12+n], resp[12:12+n]
+	for i := range q {
+		a, b := q[i], r[i]
+		if !exact && 'A' <= a && a <= 'Z' {
+			a |= 0x20
+		}
+		if !exact && 'A' <= b && b <= 'Z' {
+			b |= 0x20
+		}
+		if a != b {
+			return errors.New("dot: response question does not match query")
+		}
+	}
+	return nil
+}
+
+// questionLen returns length in bytes of the single uncompressed question of
+// msg as built by this package, or -1 if msg does not have exactly one such
+// question.
+func questionLen(msg []byte) int {
+	if len(msg) < 12 || msg[4] != 0 || msg[5] != 1 {
+		return -1
+	}
+	i := 12
+	for {
+		if i >= len(msg) {
+			return -1
+		}
+		l := int(msg[i])
+		if l > 63 {
+			return -1 // compressed name
+		}
+		i += 1 + l
+		if l == 0 {
+			break
+		}
+	}
+	if i+4 > len(msg) {
+		return -1
+	}
+	return i + 4 - 12
+}
@@ -0,0 +1,89 @@
+package dot
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Router is a Handler passing queries to different handlers depending on the
+// queried name, for split-horizon setups where names of some domains are
+// only resolvable by specific servers:
+//
+//	internal := dot.NewClient("dns.corp.example", []string{"10.0.0.53:853"})
+//	rt := dot.NewRouter(dot.Forward(dot.Cloudflare()))
+//	rt.Route("corp.example", internal)
+//	rt.Route("10.in-addr.arpa", internal)
+//	r := dot.NewResolver(rt)
+//
+// Router is safe for concurrent use, routes may be changed while it serves
+// queries.
+type Router struct {
+	def Handler
+
+	mu     sync.RWMutex
+	routes map[string]Handler // keyed by lowercased domain without trailing dot
+}
+
+// NewRouter returns Router passing queries that match no route to def.
+func NewRouter(def Handler) *Router {
+	if def == nil {
+		panic("dot: nil Handler")
+	}
+	return &Router{def: def, routes: make(map[string]Handler)}
+}
+
+// Route makes queries for domain and its subdomains to be passed to h. If
+// more than one route matches a name, the one with the longest domain wins.
+// If h is nil, route for domain is removed. Domain is a plain name, such as
+// "corp.example"; leading "*." label, as in "*.corp.example", is ignored, as
+// routes always cover subdomains.
+func (rt *Router) Route(domain string, h Handler) {
+	domain = strings.TrimPrefix(hostsKey(domain), "*.")
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if h == nil {
+		delete(rt.routes, domain)
+		return
+	}
+	rt.routes[domain] = h
+}
+
+// Handler returns handler queries for name are passed to.
+func (rt *Router) Handler(name string) Handler {
+	name = hostsKey(name)
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	if len(rt.routes) == 0 {
+		return rt.def
+	}
+	for {
+		if h, ok := rt.routes[name]; ok {
+			return h
+		}
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			break
+		}
+		name = name[i+1:]
+	}
+	if h, ok := rt.routes[""]; ok {
+		return h // route for the root domain
+	}
+	return rt.def
+}
+
+// ServeDNS implements Handler interface.
+func (rt *Router) ServeDNS(ctx context.Context, query []byte) ([]byte, error) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(query); err != nil {
+		return rt.def.ServeDNS(ctx, query)
+	}
+	q, err := p.Question()
+	if err != nil {
+		return rt.def.ServeDNS(ctx, query)
+	}
+	return rt.Handler(q.Name.String()).ServeDNS(ctx, query)
+}
@@ -0,0 +1,44 @@
+package dot_test
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/artyom/dot"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestRouter(t *testing.T) {
+	var def, corp, lab, arpa atomic.Int32
+	rt := dot.NewRouter(answerHandler(&def))
+	rt.Route("*.corp.example", answerHandler(&corp))
+	rt.Route("Lab.Corp.Example.", answerHandler(&lab))
+	rt.Route("10.in-addr.arpa", answerHandler(&arpa))
+	rt.Route("gone.example", answerHandler(&corp))
+	rt.Route("gone.example", nil)
+	for _, tc := range []struct {
+		name string
+		want *atomic.Int32
+	}{
+		{"corp.example", &corp},
+		{"www.corp.example", &corp},
+		{"a.b.corp.example", &corp},
+		{"lab.corp.example", &lab},
+		{"host.lab.corp.example", &lab},
+		{"1.0.0.10.in-addr.arpa", &arpa},
+		{"1.0.0.11.in-addr.arpa", &def},
+		{"notcorp.example", &def},
+		{"gone.example", &def},
+		{"example.com", &def},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			before := tc.want.Load()
+			if _, err := serveQuery(t, rt, tc.name, dnsmessage.TypeA); err != nil {
+				t.Fatal(err)
+			}
+			if tc.want.Load() == before {
+				t.Error("query was passed to a wrong handler")
+			}
+		})
+	}
+}
@@ -0,0 +1,504 @@
+package dot
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// rpzAction is the policy RPZ rule applies.
+type rpzAction uint8
+
+const (
+	rpzLocal    rpzAction = iota // answer with local data
+	rpzNXDomain                  // CNAME .
+	rpzNoData                    // CNAME *.
+	rpzPassthru                  // CNAME rpz-passthru.
+	rpzDrop                      // CNAME rpz-drop.
+	rpzTCPOnly                   // CNAME rpz-tcp-only.
+)
+
+// rpzRule is the policy of a single RPZ trigger name.
+type rpzRule struct {
+	action rpzAction
+	data   []dnsmessage.Resource // local data, with names unset
+}
+
+// rpzZone holds QNAME rules of a single policy zone, keyed by names
+// relative to zone origin, lowercased and without trailing dot. Wildcard
+// rules are keyed by their names without the "*." part.
+type rpzZone struct {
+	names     map[string]*rpzRule
+	wildcards map[string]*rpzRule
+}
+
+// match returns rule matching name: the exact one, or the most specific
+// wildcard.
+func (z *rpzZone) match(name string) *rpzRule {
+	if r, ok := z.names[name]; ok {
+		return r
+	}
+	for {
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			return nil
+		}
+		name = name[i+1:]
+		if r, ok := z.wildcards[name]; ok {
+			return r
+		}
+	}
+}
+
+// LoadRPZ loads Response Policy Zone in the master file format from r, as
+// distributed by threat intelligence feeds, adding it after zones already
+// loaded. Zone origin is taken from $ORIGIN directive or the SOA record.
+//
+// Policies of QNAME triggers are supported: NXDOMAIN ("CNAME ."), NODATA
+// ("CNAME *."), PASSTHRU ("CNAME rpz-passthru."), DROP ("CNAME rpz-drop."),
+// TCP-Only ("CNAME rpz-tcp-only.") and local data, which rewrites answer to
+// given A, AAAA, TXT or CNAME records; CNAME target is then resolved with
+// the next Handler. Other triggers, such as IP-based ones, are ignored.
+//
+// When name matches several zones, the first loaded zone wins; within
+// zone, exact match wins over wildcards, and more specific wildcards win
+// over less specific ones. RPZ policies take precedence over blocklists,
+// allowlists take precedence over both.
+func (f *Filter) LoadRPZ(r io.Reader) error {
+	z := &rpzZone{names: make(map[string]*rpzRule), wildcards: make(map[string]*rpzRule)}
+	if err := parseZone(r, z.add); err != nil {
+		return err
+	}
+	f.addRPZ(z)
+	return nil
+}
+
+// LoadRPZRecords is like LoadRPZ, but it loads zone from its records, in
+// the order AXFR passes them, so that zones fetched with a zone transfer can
+// be used:
+//
+//	var rrs []dnsmessage.Resource
+//	err := dot.AXFR(ctx, r, "rpz.example.net", func(rr dnsmessage.Resource) error {
+//		rrs = append(rrs, rr)
+//		return nil
+//	})
+//	...
+//	err = f.LoadRPZRecords(rrs)
+//
+// Zone origin is taken from the first record, which must be SOA.
+func (f *Filter) LoadRPZRecords(rrs []dnsmessage.Resource) error {
+	if len(rrs) == 0 || rrs[0].Header.Type != dnsmessage.TypeSOA {
+		return errors.New("dot: policy zone does not start with SOA record")
+	}
+	origin := strings.ToLower(rrs[0].Header.Name.String())
+	z := &rpzZone{names: make(map[string]*rpzRule), wildcards: make(map[string]*rpzRule)}
+	for _, rr := range rrs[1:] {
+		name := strings.ToLower(rr.Header.Name.String())
+		if cname, ok := rr.Body.(*dnsmessage.CNAMEResource); ok {
+			z.add(origin, name, rr.Header.TTL, "CNAME", []string{strings.ToLower(cname.CNAME.String())})
+			continue
+		}
+		z.addData(origin, name, rr)
+	}
+	f.addRPZ(z)
+	return nil
+}
+
+func (f *Filter) addRPZ(z *rpzZone) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rpz = append(f.rpz, z)
+}
+
+// add adds rule for record of zone with given origin, with owner name and
+// record data as found in the master file, names being absolute.
+func (z *rpzZone) add(origin, owner string, ttl uint32, typ string, data []string) {
+	typ = strings.ToUpper(typ)
+	switch typ {
+	case "CNAME":
+		if len(data) != 1 {
+			return
+		}
+		target := strings.ToLower(data[0])
+		if !strings.HasSuffix(target, ".") {
+			target += "." + origin
+		}
+		rule := rpzRule{action: rpzLocal}
+		switch target {
+		case ".":
+			rule.action = rpzNXDomain
+		case "*.":
+			rule.action = rpzNoData
+		case "rpz-passthru.":
+			rule.action = rpzPassthru
+		case "rpz-drop.":
+			rule.action = rpzDrop
+		case "rpz-tcp-only.":
+			rule.action = rpzTCPOnly
+		default:
+			name, err := dnsmessage.NewName(target)
+			if err != nil {
+				return
+			}
+			rule.data = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeCNAME, Class: dnsmessage.ClassINET, TTL: ttl},
+				Body:   &dnsmessage.CNAMEResource{CNAME: name},
+			}}
+		}
+		if key, wildcard, ok := rpzKey(origin, owner); ok {
+			z.set(key, wildcard, &rule)
+		}
+	case "A", "AAAA":
+		if len(data) != 1 {
+			return
+		}
+		ip, err := netip.ParseAddr(data[0])
+		if err != nil {
+			return
+		}
+		rr := dnsmessage.Resource{Header: dnsmessage.ResourceHeader{Class: dnsmessage.ClassINET, TTL: ttl}}
+		switch {
+		case typ == "A" && ip.Is4():
+			rr.Header.Type, rr.Body = dnsmessage.TypeA, &dnsmessage.AResource{A: ip.As4()}
+		case typ == "AAAA" && ip.Is6():
+			rr.Header.Type, rr.Body = dnsmessage.TypeAAAA, &dnsmessage.AAAAResource{AAAA: ip.As16()}
+		default:
+			return
+		}
+		z.addData(origin, owner, rr)
+	case "TXT":
+		rr := dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET, TTL: ttl},
+			Body:   &dnsmessage.TXTResource{TXT: data},
+		}
+		z.addData(origin, owner, rr)
+	}
+}
+
+// addData adds local data record rr for owner name, unless owner already
+// has non-local policy.
+func (z *rpzZone) addData(origin, owner string, rr dnsmessage.Resource) {
+	switch rr.Body.(type) {
+	case *dnsmessage.AResource, *dnsmessage.AAAAResource, *dnsmessage.TXTResource:
+	default:
+		return
+	}
+	key, wildcard, ok := rpzKey(origin, owner)
+	if !ok {
+		return
+	}
+	m := z.names
+	if wildcard {
+		m = z.wildcards
+	}
+	rule, ok := m[key]
+	if !ok {
+		rule = &rpzRule{action: rpzLocal}
+		m[key] = rule
+	}
+	if rule.action == rpzLocal {
+		rule.data = append(rule.data, rr)
+	}
+}
+
+// set sets rule for key, unless key already has one.
+func (z *rpzZone) set(key string, wildcard bool, rule *rpzRule) {
+	m := z.names
+	if wildcard {
+		m = z.wildcards
+	}
+	if _, ok := m[key]; !ok {
+		m[key] = rule
+	}
+}
+
+// rpzKey returns trigger name owner of zone with given origin stands for,
+// in the form rpzZone keys it. It returns false if owner is not a QNAME
+// trigger.
+func rpzKey(origin, owner string) (key string, wildcard, ok bool) {
+	name, found := strings.CutSuffix(owner, "."+origin)
+	if !found || name == "" {
+		return "", false, false
+	}
+	// other trigger types are encoded as subdomains of special labels
+	if i := strings.LastIndexByte(name, '.'); strings.HasPrefix(name[i+1:], "rpz-") {
+		return "", false, false
+	}
+	if key, found = strings.CutPrefix(name, "*."); found {
+		return key, true, true
+	}
+	return name, false, !strings.Contains(name, "*")
+}
+
+// rpzMatch returns RPZ rule for name, or nil if there is none. f.mu must be
+// held.
+func (f *Filter) rpzMatch(name string) *rpzRule {
+	for _, z := range f.rpz {
+		if rule := z.match(name); rule != nil {
+			return rule
+		}
+	}
+	return nil
+}
+
+// applyRPZ answers query according to rule.
+func (f *Filter) applyRPZ(ctx context.Context, query []byte, hdr dnsmessage.Header, q dnsmessage.Question, rule *rpzRule) ([]byte, error) {
+	switch rule.action {
+	case rpzNXDomain:
+		return answerResponse(hdr, q, dnsmessage.RCodeNameError, nil)
+	case rpzNoData:
+		return answerResponse(hdr, q, dnsmessage.RCodeSuccess, nil)
+	case rpzPassthru:
+		return f.next.ServeDNS(ctx, query)
+	case rpzDrop:
+		return nil, nil
+	case rpzTCPOnly:
+		if client, ok := clientFrom(ctx); ok && client.proto == protoUDP {
+			hdr.Response, hdr.Truncated = true, true
+			msg := dnsmessage.Message{Header: hdr, Questions: []dnsmessage.Question{q}}
+			return msg.Pack()
+		}
+		return f.next.ServeDNS(ctx, query)
+	}
+	var answers []dnsmessage.Resource
+	var target *dnsmessage.Name
+	for _, rr := range rule.data {
+		typ := rr.Header.Type
+		if typ != q.Type && typ != dnsmessage.TypeCNAME && q.Type != dnsmessage.TypeALL {
+			continue
+		}
+		rr.Header.Name, rr.Header.Class = q.Name, q.Class
+		answers = append(answers, rr)
+		if c, ok := rr.Body.(*dnsmessage.CNAMEResource); ok {
+			target = &c.CNAME
+		}
+	}
+	if target != nil && q.Type != dnsmessage.TypeCNAME {
+		more, err := f.resolveTarget(ctx, target.String(), q.Type)
+		if err != nil {
+			return nil, err
+		}
+		answers = append(answers, more...)
+	}
+	return answerResponse(hdr, q, dnsmessage.RCodeSuccess, answers)
+}
+
+// resolveTarget returns answers next Handler has for CNAME target name.
+func (f *Filter) resolveTarget(ctx context.Context, name string, qtype dnsmessage.Type) ([]dnsmessage.Resource, error) {
+	query, err := newQuery(name, qtype)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.next.ServeDNS(ctx, query)
+	if err != nil || resp == nil {
+		return nil, err
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(resp); err != nil {
+		return nil, fmt.Errorf("dot: parsing response: %w", err)
+	}
+	return msg.Answers, nil
+}
+
+// parseZone reads master file from r, as described in RFC 1035, section
+// 5, calling fn for every record with the zone origin, absolute lowercased
+// owner name, TTL, type and record data fields. Class field is dropped;
+// $INCLUDE directives are not supported.
+func parseZone(r io.Reader, fn func(origin, owner string, ttl uint32, typ string, data []string)) error {
+	var (
+		origin  string
+		ttl     uint32 = 3600
+		owner   string
+		depth   int // of parentheses
+		fields  []string
+		blank   bool // record has no owner field
+		lineNum int
+	)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		lineNum++
+		line := sc.Text()
+		if depth == 0 {
+			blank = line != "" && (line[0] == ' ' || line[0] == '\t')
+		}
+		var err error
+		if fields, depth, err = zoneFields(fields, line, depth); err != nil {
+			return fmt.Errorf("dot: zone file line %d: %w", lineNum, err)
+		}
+		if depth > 0 || len(fields) == 0 {
+			continue
+		}
+		rec := fields
+		fields = nil
+		switch strings.ToUpper(rec[0]) {
+		case "$ORIGIN":
+			if len(rec) < 2 {
+				return fmt.Errorf("dot: zone file line %d: $ORIGIN without name", lineNum)
+			}
+			origin = absName(rec[1], origin)
+			continue
+		case "$TTL":
+			if len(rec) < 2 {
+				return fmt.Errorf("dot: zone file line %d: $TTL without value", lineNum)
+			}
+			v, ok := parseZoneTTL(rec[1])
+			if !ok {
+				return fmt.Errorf("dot: zone file line %d: invalid $TTL %q", lineNum, rec[1])
+			}
+			ttl = v
+			continue
+		case "$INCLUDE":
+			return fmt.Errorf("dot: zone file line %d: $INCLUDE is not supported", lineNum)
+		}
+		if !blank {
+			if owner = absName(rec[0], origin); owner == "" {
+				return fmt.Errorf("dot: zone file line %d: relative name %q without $ORIGIN", lineNum, rec[0])
+			}
+			rec = rec[1:]
+		}
+		if owner == "" {
+			return fmt.Errorf("dot: zone file line %d: record without owner name", lineNum)
+		}
+		recTTL := ttl
+		// TTL and class may come in either order before type
+		for len(rec) > 1 {
+			if v, ok := parseZoneTTL(rec[0]); ok {
+				recTTL, rec = v, rec[1:]
+				continue
+			}
+			if c := strings.ToUpper(rec[0]); c == "IN" || c == "CH" || c == "HS" || c == "CS" {
+				rec = rec[1:]
+				continue
+			}
+			break
+		}
+		if len(rec) == 0 {
+			return fmt.Errorf("dot: zone file line %d: record without type", lineNum)
+		}
+		typ := strings.ToUpper(rec[0])
+		if typ == "SOA" && origin == "" {
+			origin = owner
+		}
+		if origin == "" {
+			return fmt.Errorf("dot: zone file line %d: zone origin is unknown, $ORIGIN or SOA record must come first", lineNum)
+		}
+		fn(origin, owner, recTTL, typ, rec[1:])
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if depth > 0 {
+		return errors.New("dot: zone file: unbalanced parentheses")
+	}
+	return nil
+}
+
+// zoneFields appends fields of a master file line to fields, tracking
+// parentheses depth. Quoted strings are returned as a single field without
+// quotes.
+func zoneFields(fields []string, line string, depth int) ([]string, int, error) {
+	for i := 0; i < len(line); {
+		switch c := line[i]; {
+		case c == ';':
+			return fields, depth, nil
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '(':
+			depth++
+			i++
+		case c == ')':
+			if depth == 0 {
+				return nil, 0, errors.New("unbalanced parentheses")
+			}
+			depth--
+			i++
+		case c == '"':
+			var b strings.Builder
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+				}
+				b.WriteByte(line[i])
+			}
+			if i == len(line) {
+				return nil, 0, errors.New("unterminated quoted string")
+			}
+			i++
+			fields = append(fields, b.String())
+		default:
+			j := i
+			for j < len(line) && !strings.ContainsRune(" \t\r;()\"", rune(line[j])) {
+				j++
+			}
+			fields = append(fields, line[i:j])
+			i = j
+		}
+	}
+	return fields, depth, nil
+}
+
+// absName returns lowercased absolute form of master file name relative to
+// origin.
+func absName(name, origin string) string {
+	name = strings.ToLower(name)
+	switch {
+	case name == "@":
+		return origin
+	case strings.HasSuffix(name, "."):
+		return name
+	case origin == "":
+		return ""
+	case origin == ".":
+		return name + "."
+	}
+	return name + "." + origin
+}
+
+// parseZoneTTL parses master file TTL, either in seconds or with BIND-style
+// units, like "1h30m".
+func parseZoneTTL(s string) (uint32, bool) {
+	if s == "" || s[0] < '0' || s[0] > '9' {
+		return 0, false
+	}
+	if v, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(v), true
+	}
+	var total, n uint64
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= '0' && c <= '9' {
+			n = n*10 + uint64(c-'0')
+			continue
+		}
+		var unit uint64
+		switch c | 0x20 {
+		case 's':
+			unit = 1
+		case 'm':
+			unit = 60
+		case 'h':
+			unit = 3600
+		case 'd':
+			unit = 86400
+		case 'w':
+			unit = 7 * 86400
+		default:
+			return 0, false
+		}
+		total += n * unit
+		n = 0
+	}
+	if n != 0 || total > 1<<32-1 {
+		return 0, false
+	}
+	return uint32(total), true
+}
@@ -0,0 +1,191 @@
+package dot_test
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/artyom/dot"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const testRPZ = `$ORIGIN rpz.example.
+$TTL 1h
+@	SOA	localhost. root.localhost. (
+		1 3600 600 86400 60 ) ; serial, refresh, retry, expire, minimum
+	NS	localhost.
+
+nx.example.com		CNAME	.
+nodata.example.com	CNAME	*.
+pass.example.com	CNAME	rpz-passthru.
+drop.example.com	CNAME	rpz-drop.
+tcp.example.com		CNAME	rpz-tcp-only.
+local.example.com	A	192.0.2.10
+			AAAA	2001:db8::10
+			TXT	"walled garden"
+cname.example.com	CNAME	target.example.net.
+allowed.example.com	CNAME	.
+*.wild.example.com	CNAME	.
+exact.wild.example.com	CNAME	rpz-passthru.
+*.sub.wild.example.com	300 IN A 192.0.2.20
+32.10.2.0.192.rpz-ip	CNAME	. ; IP triggers are not supported
+`
+
+// answerStrings returns answer records data of msg: addresses, CNAME
+// targets and TXT strings.
+func answerStrings(msg *dnsmessage.Message) []string {
+	var out []string
+	for _, rr := range msg.Answers {
+		switch b := rr.Body.(type) {
+		case *dnsmessage.CNAMEResource:
+			out = append(out, b.CNAME.String())
+		case *dnsmessage.TXTResource:
+			out = append(out, strings.Join(b.TXT, " "))
+		}
+	}
+	for _, addr := range answerAddrs(msg) {
+		out = append(out, addr.String())
+	}
+	return out
+}
+
+func TestFilterRPZ(t *testing.T) {
+	var calls atomic.Int32
+	f := dot.NewFilter(answerHandler(&calls), dot.BlockNXDomain)
+	if err := f.LoadRPZ(strings.NewReader(testRPZ)); err != nil {
+		t.Fatal(err)
+	}
+	// the first zone wins
+	if err := f.LoadRPZ(strings.NewReader("$ORIGIN rpz2.example.\nnx.example.com A 192.0.2.99\nsecond.example.com CNAME .\n")); err != nil {
+		t.Fatal(err)
+	}
+	f.BlockNames("pass.example.com", "blocked.example.com")
+	f.AllowNames("allowed.example.com")
+
+	for _, tc := range []struct {
+		name     string
+		typ      dnsmessage.Type
+		dropped  bool
+		rcode    dnsmessage.RCode
+		answers  []string
+		upstream bool
+		blocked  bool
+	}{
+		{name: "nx.example.com", rcode: dnsmessage.RCodeNameError, blocked: true},
+		{name: "nodata.example.com", blocked: true},
+		{name: "pass.example.com", answers: []string{"192.0.2.1"}, upstream: true},
+		{name: "drop.example.com", dropped: true, blocked: true},
+		{name: "tcp.example.com", answers: []string{"192.0.2.1"}, upstream: true},
+		{name: "local.example.com", answers: []string{"192.0.2.10"}, blocked: true},
+		{name: "local.example.com", typ: dnsmessage.TypeAAAA, answers: []string{"2001:db8::10"}, blocked: true},
+		{name: "local.example.com", typ: dnsmessage.TypeTXT, answers: []string{"walled garden"}, blocked: true},
+		{name: "local.example.com", typ: dnsmessage.TypeMX, blocked: true},
+		{name: "cname.example.com", answers: []string{"target.example.net.", "192.0.2.1"}, upstream: true, blocked: true},
+		{name: "cname.example.com", typ: dnsmessage.TypeCNAME, answers: []string{"target.example.net."}, blocked: true},
+		{name: "allowed.example.com", answers: []string{"192.0.2.1"}, upstream: true},
+		{name: "a.wild.example.com", rcode: dnsmessage.RCodeNameError, blocked: true},
+		{name: "exact.wild.example.com", answers: []string{"192.0.2.1"}, upstream: true},
+		{name: "a.sub.wild.example.com", answers: []string{"192.0.2.20"}, blocked: true},
+		{name: "wild.example.com", answers: []string{"192.0.2.1"}, upstream: true},
+		{name: "second.example.com", rcode: dnsmessage.RCodeNameError, blocked: true},
+		{name: "blocked.example.com", rcode: dnsmessage.RCodeNameError, blocked: true},
+		{name: "32.10.2.0.192.rpz-ip", answers: []string{"192.0.2.1"}, upstream: true},
+	} {
+		if tc.typ == 0 {
+			tc.typ = dnsmessage.TypeA
+		}
+		t.Run(tc.name+"/"+tc.typ.String(), func(t *testing.T) {
+			if got := f.Blocked(tc.name); got != tc.blocked {
+				t.Errorf("Blocked reports %t, want %t", got, tc.blocked)
+			}
+			before := calls.Load()
+			msg, err := serveQuery(t, f, tc.name, tc.typ)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if passed := calls.Load() != before; passed != tc.upstream {
+				t.Errorf("query passed upstream: %t, want %t", passed, tc.upstream)
+			}
+			if msg == nil {
+				if !tc.dropped {
+					t.Fatal("query was dropped")
+				}
+				return
+			}
+			if tc.dropped {
+				t.Fatal("query was not dropped")
+			}
+			if msg.Header.RCode != tc.rcode {
+				t.Errorf("got rcode %v, want %v", msg.Header.RCode, tc.rcode)
+			}
+			if got := answerStrings(msg); strings.Join(got, ",") != strings.Join(tc.answers, ",") {
+				t.Errorf("got answers %q, want %q", got, tc.answers)
+			}
+		})
+	}
+}
+
+func TestFilterRPZRecords(t *testing.T) {
+	origin := dnsmessage.MustNewName("rpz.example.")
+	soa := dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: origin, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET, TTL: 3600},
+		Body: &dnsmessage.SOAResource{
+			NS:   dnsmessage.MustNewName("localhost."),
+			MBox: dnsmessage.MustNewName("root.localhost."),
+		},
+	}
+	rrs := []dnsmessage.Resource{
+		soa,
+		{
+			Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("NX.example.com.rpz.example."), Type: dnsmessage.TypeCNAME, Class: dnsmessage.ClassINET},
+			Body:   &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName(".")},
+		},
+		{
+			Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("local.example.com.rpz.example."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+			Body:   &dnsmessage.AResource{A: [4]byte{192, 0, 2, 10}},
+		},
+		soa, // AXFR ends with SOA again
+	}
+	var calls atomic.Int32
+	f := dot.NewFilter(answerHandler(&calls), dot.BlockNXDomain)
+	if err := f.LoadRPZRecords(rrs[1:]); err == nil {
+		t.Error("zone not starting with SOA was loaded")
+	}
+	if err := f.LoadRPZRecords(rrs); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := serveQuery(t, f, "nx.example.com", dnsmessage.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Header.RCode != dnsmessage.RCodeNameError {
+		t.Errorf("got rcode %v, want %v", msg.Header.RCode, dnsmessage.RCodeNameError)
+	}
+	if msg, err = serveQuery(t, f, "local.example.com", dnsmessage.TypeA); err != nil {
+		t.Fatal(err)
+	}
+	if got := answerStrings(msg); len(got) != 1 || got[0] != "192.0.2.10" {
+		t.Errorf("got answers %q, want 192.0.2.10", got)
+	}
+	if n := calls.Load(); n != 0 {
+		t.Errorf("upstream got %d queries", n)
+	}
+}
+
+func TestFilterRPZMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		name, zone string
+	}{
+		{"no origin", "nx.example.com CNAME .\n"},
+		{"include", "$ORIGIN rpz.example.\n$INCLUDE other.zone\n"},
+		{"bad ttl", "$TTL forever\n"},
+		{"unbalanced", "$ORIGIN rpz.example.\n@ SOA localhost. root.localhost. ( 1 2 3 4 5\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := dot.NewFilter(answerHandler(new(atomic.Int32)), dot.BlockNXDomain)
+			if err := f.LoadRPZ(strings.NewReader(tc.zone)); err == nil {
+				t.Error("malformed zone was loaded")
+			}
+		})
+	}
+}
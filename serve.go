@@ -0,0 +1,363 @@
+package dot
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// service implements the parts shared by Proxy and Server: serving queries
+// from packet connections and stream listeners, limiting concurrency, and
+// tracking everything that needs to be closed on shutdown.
+type service struct {
+	initOnce sync.Once
+	sem      chan struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+	closed   error // returned by serve loops after shutdown
+	errorLog *log.Logger
+	timeout  time.Duration
+	handler  Handler
+
+	mu         sync.Mutex
+	pconns     map[net.PacketConn]struct{}
+	listeners  map[net.Listener]struct{}
+	conns      map[net.Conn]struct{}
+	active     int // queries in flight
+	inShutdown bool
+}
+
+// init prepares s for use on its first call; subsequent calls are no-op.
+func (s *service) init(closed error, h Handler, maxQueries int, timeout time.Duration, errorLog *log.Logger) {
+	s.initOnce.Do(func() {
+		if maxQueries <= 0 {
+			maxQueries = 100
+		}
+		if timeout <= 0 {
+			timeout = 5 * time.Second
+		}
+		s.sem = make(chan struct{}, maxQueries)
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+		s.closed = closed
+		s.handler = h
+		s.timeout = timeout
+		s.errorLog = errorLog
+		s.pconns = make(map[net.PacketConn]struct{})
+		s.listeners = make(map[net.Listener]struct{})
+		s.conns = make(map[net.Conn]struct{})
+	})
+}
+
+// serve runs packet and stream loops for pc and l until either of them
+// fails, or service is shut down. Either pc or l may be nil, but not both.
+func (s *service) serve(pc net.PacketConn, l net.Listener) error {
+	if !s.track(pc, l) {
+		if pc != nil {
+			pc.Close()
+		}
+		if l != nil {
+			l.Close()
+		}
+		return s.closed
+	}
+	errc := make(chan error, 2)
+	var n int
+	if pc != nil {
+		n++
+		go func() { errc <- s.servePacket(pc) }()
+	}
+	if l != nil {
+		n++
+		go func() { errc <- s.serveStream(l) }()
+	}
+	err := <-errc
+	if n == 2 {
+		// one loop failed, tear down the other one too
+		l.Close()
+		if !s.shuttingDown() {
+			pc.Close()
+		}
+		<-errc
+	}
+	if s.shuttingDown() {
+		return s.closed
+	}
+	return err
+}
+
+// shutdown stops reading new queries and waits for in-flight queries to be
+// answered before closing everything. If ctx expires first, shutdown closes
+// everything and returns context's error.
+func (s *service) shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.inShutdown = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	past := time.Unix(1, 0)
+	for pc := range s.pconns {
+		pc.SetReadDeadline(past)
+	}
+	for c := range s.conns {
+		c.SetReadDeadline(past)
+	}
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		active := s.active
+		s.mu.Unlock()
+		if active == 0 {
+			return s.close()
+		}
+		select {
+		case <-ctx.Done():
+			s.close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// close immediately closes all listeners and connections.
+func (s *service) close() error {
+	s.cancel()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inShutdown = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for pc := range s.pconns {
+		pc.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	return nil
+}
+
+// track registers pc and l so that they can be closed on shutdown. It
+// returns false if service is already shutting down.
+func (s *service) track(pc net.PacketConn, l net.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inShutdown {
+		return false
+	}
+	if pc != nil {
+		s.pconns[pc] = struct{}{}
+	}
+	if l != nil {
+		s.listeners[l] = struct{}{}
+	}
+	return true
+}
+
+func (s *service) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inShutdown
+}
+
+// startQuery acquires a concurrency slot and marks query as in flight. It
+// returns false if service is shutting down, in which case the query should
+// be dropped.
+func (s *service) startQuery() bool {
+	select {
+	case s.sem <- struct{}{}:
+	case <-s.ctx.Done():
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inShutdown {
+		<-s.sem
+		return false
+	}
+	s.active++
+	return true
+}
+
+func (s *service) endQuery() {
+	s.mu.Lock()
+	s.active--
+	s.mu.Unlock()
+	<-s.sem
+}
+
+func (s *service) servePacket(pc net.PacketConn) error {
+	buf := make([]byte, maxMsgSize)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if s.shuttingDown() {
+				return s.closed
+			}
+			// on shutdown pc is left open for in-flight queries to be
+			// answered, it's closed by close
+			s.mu.Lock()
+			delete(s.pconns, pc)
+			s.mu.Unlock()
+			pc.Close()
+			return err
+		}
+		if !s.startQuery() {
+			continue
+		}
+		query := make([]byte, n)
+		copy(query, buf[:n])
+		go func() {
+			defer s.endQuery()
+			resp := s.handle(query, clientInfo{addr: addr, proto: protoUDP})
+			if resp == nil {
+				return
+			}
+			if len(resp) > udpSize(query) {
+				if resp = truncate(resp); resp == nil {
+					return
+				}
+			}
+			if _, err := pc.WriteTo(resp, addr); err != nil {
+				s.logf("dot: writing response to %v: %v", addr, err)
+			}
+		}()
+	}
+}
+
+func (s *service) serveStream(l net.Listener) error {
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			l.Close()
+			if s.shuttingDown() {
+				return s.closed
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.inShutdown {
+			s.mu.Unlock()
+			conn.Close()
+			continue
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// tcpIdleTimeout is how long idle client stream connection is kept open.
+const tcpIdleTimeout = 10 * time.Second
+
+func (s *service) serveConn(conn net.Conn) {
+	var wg sync.WaitGroup
+	defer func() {
+		wg.Wait()
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+	var wmu sync.Mutex // serializes writes of pipelined responses
+	client := clientInfo{addr: conn.RemoteAddr(), proto: protoTCP}
+	if _, ok := conn.(*tls.Conn); ok {
+		client.proto = protoDoT
+	}
+	for {
+		conn.SetReadDeadline(time.Now().Add(tcpIdleTimeout))
+		if s.shuttingDown() {
+			return
+		}
+		query, err := readMsg(conn)
+		if err != nil {
+			return
+		}
+		if !s.startQuery() {
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer s.endQuery()
+			resp := s.handle(query, client)
+			if resp == nil {
+				return
+			}
+			wmu.Lock()
+			defer wmu.Unlock()
+			if err := writeMsg(conn, resp); err != nil {
+				s.logf("dot: writing response to %v: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// handle passes query received from client to the handler and returns the
+// response to send back.
+func (s *service) handle(query []byte, client clientInfo) []byte {
+	ctx, cancel := context.WithTimeout(s.ctx, s.timeout)
+	defer cancel()
+	ctx = context.WithValue(ctx, clientKey{}, client)
+	return handleQuery(ctx, s.handler, query, s.logf)
+}
+
+type clientKey struct{}
+
+// clientInfo describes client that sent query to Proxy, Server or
+// DoHHandler, it is passed to handlers in context.
+type clientInfo struct {
+	addr  net.Addr
+	proto transportProto
+}
+
+// transportProto is the transport query was received over, its values
+// match the ones of dnstap SocketProtocol.
+type transportProto uint8
+
+const (
+	protoUDP transportProto = 1
+	protoTCP transportProto = 2
+	protoDoT transportProto = 3
+	protoDoH transportProto = 4
+)
+
+// clientFrom returns client set by the serving code, if any.
+func clientFrom(ctx context.Context) (clientInfo, bool) {
+	client, ok := ctx.Value(clientKey{}).(clientInfo)
+	return client, ok
+}
+
+// handleQuery passes query to h and returns the response to send back to the
+// client. If h fails, it returns a SERVFAIL response. It returns nil if query
+// is malformed and should be dropped.
+func handleQuery(ctx context.Context, h Handler, query []byte, logf func(string, ...interface{})) []byte {
+	var parser dnsmessage.Parser
+	if hdr, err := parser.Start(query); err != nil || hdr.Response {
+		return nil
+	}
+	resp, err := h.ServeDNS(ctx, query)
+	if err != nil {
+		logf("dot: handling query: %v", err)
+		return errorResponse(query, dnsmessage.RCodeServerFailure)
+	}
+	return resp
+}
+
+func (s *service) logf(format string, args ...interface{}) {
+	if s.errorLog != nil {
+		s.errorLog.Printf(format, args...)
+	}
+}
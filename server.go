@@ -0,0 +1,142 @@
+package dot
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"time"
+)
+
+// ErrServerClosed is returned by Server's Serve, ListenAndServe, and
+// ListenAndServeTLS methods after a call to Shutdown or Close.
+var ErrServerClosed = errors.New("dot: server closed")
+
+// Server is a DNS-over-TLS server as described in RFC 7858. It terminates
+// TLS and answers queries with Handler, or forwards them to Resolver if
+// Handler is nil.
+type Server struct {
+	// Addr is the TCP address to listen on, ":853" if empty.
+	Addr string
+
+	// TLSConfig provides TLS configuration for ListenAndServe and Serve,
+	// it must provide at least one certificate. Server adds "dot" to its
+	// NextProtos.
+	TLSConfig *tls.Config
+
+	// Handler answers queries. If nil, queries are forwarded to Resolver.
+	Handler Handler
+
+	// Resolver is used to forward queries if Handler is nil. It must be
+	// the one returned by one of this package's functions, or otherwise
+	// have its Dial field set to a function returning stream connections.
+	Resolver *net.Resolver
+
+	// MaxQueries limits the number of queries handled concurrently, 100 if
+	// zero. Once the limit is reached, server stops reading new queries
+	// until some of the in-flight ones complete.
+	MaxQueries int
+
+	// Timeout limits how long handling a single query may take, 5 seconds
+	// if zero. Queries that fail or time out are answered with SERVFAIL.
+	Timeout time.Duration
+
+	// ErrorLog specifies an optional logger for handler and connection
+	// errors. If nil, errors are not logged.
+	ErrorLog *log.Logger
+
+	svc service
+}
+
+// ListenAndServe listens on TCP address s.Addr and then calls Serve to handle
+// queries. It always returns a non-nil error; after Shutdown or Close, the
+// returned error is ErrServerClosed.
+func (s *Server) ListenAndServe() error {
+	addr := s.Addr
+	if addr == "" {
+		addr = ":853"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// ListenAndServeTLS acts identically to ListenAndServe, except that it loads
+// certificate and matching private key from given files, adding them to a
+// copy of s.TLSConfig. If certificate is signed by a certificate authority,
+// certFile should be the concatenation of server's certificate, any
+// intermediates, and the CA's certificate.
+func (s *Server) ListenAndServeTLS(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	cfg := &tls.Config{}
+	if s.TLSConfig != nil {
+		cfg = s.TLSConfig.Clone()
+	}
+	cfg.Certificates = append(cfg.Certificates, cert)
+	s.TLSConfig = cfg
+	return s.ListenAndServe()
+}
+
+// Serve accepts TCP connections on l, doing TLS handshake and answering
+// queries. Serve always returns a non-nil error and closes l; after Shutdown
+// or Close, the returned error is ErrServerClosed.
+func (s *Server) Serve(l net.Listener) error {
+	if s.TLSConfig == nil || (len(s.TLSConfig.Certificates) == 0 &&
+		s.TLSConfig.GetCertificate == nil && s.TLSConfig.GetConfigForClient == nil) {
+		l.Close()
+		return errors.New("dot: server TLSConfig has no certificates")
+	}
+	if s.Handler == nil && s.Resolver == nil {
+		l.Close()
+		return errors.New("dot: server has neither Handler nor Resolver")
+	}
+	s.init()
+	cfg := s.TLSConfig.Clone()
+	if !hasProto(cfg.NextProtos, alpnProto) {
+		cfg.NextProtos = append(cfg.NextProtos, alpnProto)
+	}
+	return s.svc.serve(nil, tls.NewListener(l, cfg))
+}
+
+// Shutdown gracefully shuts down the server: it stops accepting new queries,
+// waits for in-flight queries to be answered, then closes all listeners and
+// connections. If ctx expires before that, Shutdown closes everything and
+// returns context's error.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.init()
+	return s.svc.shutdown(ctx)
+}
+
+// Close immediately closes all listeners and connections, aborting queries
+// in flight. For a graceful shutdown, use Shutdown.
+func (s *Server) Close() error {
+	s.init()
+	return s.svc.close()
+}
+
+func (s *Server) init() {
+	h := s.Handler
+	if h == nil {
+		h = Forward(s.Resolver)
+	}
+	s.svc.init(ErrServerClosed, h, s.MaxQueries, s.Timeout, s.ErrorLog)
+}
+
+// alpnProto is the ALPN protocol identifier of DNS-over-TLS, see
+// https://www.iana.org/assignments/tls-extensiontype-values/
+const alpnProto = "dot"
+
+func hasProto(protos []string, proto string) bool {
+	for _, p := range protos {
+		if p == proto {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package dot
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Shard returns Handler that spreads queries among upstreams so that no
+// single provider sees complete lookup history. Upstream is picked by hash
+// of the registrable domain of queried name, so all lookups of a site, e.g.
+// "example.com" and "www.example.com", consistently go to the same provider,
+// which learns nothing about names sent to others:
+//
+//	h := dot.Shard(
+//		dot.NewClient("dns.quad9.net", []string{"9.9.9.9:853"}),
+//		dot.NewClient("one.one.one.one", []string{"1.1.1.1:853"}),
+//	)
+//
+// Assignment of domains to upstreams only depends on the order of upstreams,
+// so it survives process restarts, and every provider keeps seeing the same
+// part of lookup history. Unlike Fallback, Shard does not retry failed queries with
+// other upstreams, as that would leak names to them; wrap upstreams with
+// Fallback for that. Queries that cannot be parsed go to the first upstream.
+// Shard panics if upstreams is empty.
+func Shard(upstreams ...Handler) Handler {
+	checkUpstreams(upstreams)
+	return HandlerFunc(func(ctx context.Context, query []byte) ([]byte, error) {
+		var p dnsmessage.Parser
+		if _, err := p.Start(query); err != nil {
+			return upstreams[0].ServeDNS(ctx, query)
+		}
+		q, err := p.Question()
+		if err != nil {
+			return upstreams[0].ServeDNS(ctx, query)
+		}
+		name := hostsKey(q.Name.String())
+		if domain, err := publicsuffix.EffectiveTLDPlusOne(name); err == nil {
+			name = domain
+		}
+		hash := fnv.New64a()
+		hash.Write([]byte(name))
+		return upstreams[hash.Sum64()%uint64(len(upstreams))].ServeDNS(ctx, query)
+	})
+}
+
+// ShardRandom is like Shard, but it sends every query to a random upstream.
+// Every provider then sees only a sample of all lookups, but over time it
+// also sees every frequently used name.
+func ShardRandom(upstreams ...Handler) Handler {
+	checkUpstreams(upstreams)
+	return HandlerFunc(func(ctx context.Context, query []byte) ([]byte, error) {
+		return upstreams[rand.Intn(len(upstreams))].ServeDNS(ctx, query)
+	})
+}
+
+func checkUpstreams(upstreams []Handler) {
+	if len(upstreams) == 0 {
+		panic("dot: no upstreams")
+	}
+	for _, h := range upstreams {
+		if h == nil {
+			panic("dot: nil Handler")
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package dot_test
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/artyom/dot"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestShard(t *testing.T) {
+	// upstream returns index of the upstream that answered the query to name
+	// with Handler returned by a new Shard call, as it is after restart
+	upstream := func(name string) int {
+		t.Helper()
+		calls := make([]atomic.Int32, 3)
+		hs := make([]dot.Handler, len(calls))
+		for i := range calls {
+			hs[i] = answerHandler(&calls[i])
+		}
+		if _, err := serveQuery(t, dot.Shard(hs...), name, dnsmessage.TypeA); err != nil {
+			t.Fatal(err)
+		}
+		for i := range calls {
+			if calls[i].Load() != 0 {
+				return i
+			}
+		}
+		t.Fatalf("query for %s was not sent upstream", name)
+		return -1
+	}
+	used := make(map[int]bool)
+	for _, names := range [][]string{
+		{"example.com", "www.example.com", "a.b.example.com", "EXAMPLE.com"},
+		{"example.org", "www.example.org"},
+		{"example.co.uk", "www.example.co.uk"},
+		{"example.net", "mail.example.net"},
+		{"example.info", "www.example.info"},
+		{"example.dev", "api.example.dev"},
+	} {
+		t.Run(names[0], func(t *testing.T) {
+			want := upstream(names[0])
+			used[want] = true
+			for _, name := range names {
+				for range 3 {
+					if got := upstream(name); got != want {
+						t.Errorf("%s sent to upstream %d, want %d", name, got, want)
+					}
+				}
+			}
+		})
+	}
+	if len(used) < 2 {
+		t.Errorf("all domains sent to the same upstream")
+	}
+}
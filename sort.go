@@ -0,0 +1,192 @@
+package dot
+
+import (
+	"net"
+	"net/netip"
+	"slices"
+)
+
+// SortAddrs sorts addrs in place in the order of preference for connecting
+// to them, following destination address selection rules of RFC 6724,
+// section 6. Addresses are checked against local connectivity: the ones this
+// host has no route to are moved to the end, and the ones sharing scope and
+// type with the local address used to reach them are preferred, so that on
+// a host without working IPv6 connectivity IPv4 addresses come first.
+//
+// Rules 3, 4 and 7, which depend on address states and interfaces not
+// exposed by the operating system, are not applied.
+func SortAddrs(addrs []netip.Addr) {
+	if len(addrs) < 2 {
+		return
+	}
+	type entry struct {
+		dst, src netip.Addr // src is invalid if dst is unreachable
+	}
+	entries := make([]entry, len(addrs))
+	for i, ip := range addrs {
+		entries[i] = entry{dst: ip, src: sourceAddr(ip)}
+	}
+	slices.SortStableFunc(entries, func(a, b entry) int {
+		return compareRFC6724(a.dst, a.src, b.dst, b.src)
+	})
+	for i := range entries {
+		addrs[i] = entries[i].dst
+	}
+}
+
+// InterleaveAddrs returns a copy of addrs reordered to alternate between IPv6
+// and IPv4 addresses, starting with the family of the first address and
+// keeping the relative order within each family, as RFC 8305, section 4
+// recommends for Happy Eyeballs connection attempts. Use it on addresses
+// sorted by SortAddrs, so that a connection attempt to an address of the
+// other family starts early if the preferred one does not work.
+func InterleaveAddrs(addrs []netip.Addr) []netip.Addr {
+	var first, second []netip.Addr
+	for _, ip := range addrs {
+		if len(first) == 0 || first[0].Unmap().Is4() == ip.Unmap().Is4() {
+			first = append(first, ip)
+		} else {
+			second = append(second, ip)
+		}
+	}
+	out := make([]netip.Addr, 0, len(addrs))
+	for len(first) != 0 || len(second) != 0 {
+		if len(first) != 0 {
+			out = append(out, first[0])
+			first = first[1:]
+		}
+		if len(second) != 0 {
+			out = append(out, second[0])
+			second = second[1:]
+		}
+	}
+	return out
+}
+
+// sourceAddr returns local address the operating system would use to reach
+// dst, or invalid address if there is no route to dst. No packets are sent.
+func sourceAddr(dst netip.Addr) netip.Addr {
+	conn, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(netip.AddrPortFrom(dst, 9)))
+	if err != nil {
+		return netip.Addr{}
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).AddrPort().Addr()
+}
+
+// compareRFC6724 compares destination addresses a and b with their source
+// addresses, returning a negative number if a is preferred over b.
+func compareRFC6724(a, aSrc, b, bSrc netip.Addr) int {
+	a, aSrc, b, bSrc = a.Unmap(), aSrc.Unmap(), b.Unmap(), bSrc.Unmap()
+	// rule 1: avoid unusable destinations
+	if aSrc.IsValid() != bSrc.IsValid() {
+		if aSrc.IsValid() {
+			return -1
+		}
+		return 1
+	}
+	if !aSrc.IsValid() {
+		return 0
+	}
+	// rule 2: prefer matching scope
+	aScope, bScope := addrScope(a), addrScope(b)
+	if am, bm := aScope == addrScope(aSrc), bScope == addrScope(bSrc); am != bm {
+		if am {
+			return -1
+		}
+		return 1
+	}
+	// rule 5: prefer matching label
+	aPolicy, bPolicy := policyOf(a), policyOf(b)
+	if am, bm := aPolicy.label == policyOf(aSrc).label, bPolicy.label == policyOf(bSrc).label; am != bm {
+		if am {
+			return -1
+		}
+		return 1
+	}
+	// rule 6: prefer higher precedence
+	if aPolicy.precedence != bPolicy.precedence {
+		return int(bPolicy.precedence) - int(aPolicy.precedence)
+	}
+	// rule 8: prefer smaller scope
+	if aScope != bScope {
+		return int(aScope) - int(bScope)
+	}
+	// rule 9: use longest matching prefix; like most implementations, it
+	// is only applied to IPv6, since it breaks DNS round robin for IPv4
+	if a.Is6() && b.Is6() && aSrc.Is6() && bSrc.Is6() {
+		return commonPrefixLen(b, bSrc) - commonPrefixLen(a, aSrc)
+	}
+	// rule 10: otherwise, leave the order unchanged
+	return 0
+}
+
+// addrScope returns scope of ip as defined by RFC 4291 for IPv6 and by RFC
+// 6724, section 3.2 for IPv4.
+func addrScope(ip netip.Addr) uint8 {
+	const (
+		scopeLinkLocal = 0x2
+		scopeSiteLocal = 0x5
+		scopeGlobal    = 0xe
+	)
+	switch {
+	case ip.IsLoopback(), ip.IsLinkLocalUnicast():
+		return scopeLinkLocal
+	case ip.Is6() && ip.IsMulticast():
+		return ip.As16()[1] & 0xf
+	case ip.Is6() && ip.As16()[0] == 0xfe && ip.As16()[1]&0xc0 == 0xc0: // fec0::/10
+		return scopeSiteLocal
+	}
+	return scopeGlobal
+}
+
+type policy struct {
+	prefix            netip.Prefix
+	precedence, label uint8
+}
+
+// policyTable is the default policy table of RFC 6724, section 2.1, ordered
+// by decreasing prefix length. IPv4 addresses are matched in their
+// IPv4-mapped form.
+var policyTable = []policy{
+	{netip.MustParsePrefix("::1/128"), 50, 0},
+	{netip.MustParsePrefix("::ffff:0:0/96"), 35, 4},
+	{netip.MustParsePrefix("::/96"), 1, 3},
+	{netip.MustParsePrefix("2001::/32"), 5, 5},
+	{netip.MustParsePrefix("2002::/16"), 30, 2},
+	{netip.MustParsePrefix("3ffe::/16"), 1, 12},
+	{netip.MustParsePrefix("fec0::/10"), 1, 11},
+	{netip.MustParsePrefix("fc00::/7"), 3, 13},
+	{netip.MustParsePrefix("::/0"), 40, 1},
+}
+
+func policyOf(ip netip.Addr) policy {
+	ip = netip.AddrFrom16(ip.As16())
+	for _, p := range policyTable {
+		if p.prefix.Contains(ip) {
+			return p
+		}
+	}
+	return policyTable[len(policyTable)-1]
+}
+
+// commonPrefixLen returns the number of leading bits a and b share, ignoring
+// interface identifier part of IPv6 addresses, as RFC 6724, section 2.2
+// suggests.
+func commonPrefixLen(a, b netip.Addr) int {
+	a16, b16 := a.As16(), b.As16()
+	var n int
+	for i := 0; i < 8; i++ {
+		x := a16[i] ^ b16[i]
+		if x == 0 {
+			n += 8
+			continue
+		}
+		for x&0x80 == 0 {
+			n++
+			x <<= 1
+		}
+		break
+	}
+	return n
+}
@@ -0,0 +1,145 @@
+package dot
+
+import (
+	"context"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// SpecialUse returns Handler that keeps queries for special-use domain
+// names from reaching next, which is expected to be a public upstream that
+// cannot resolve them anyway: forwarding such queries leaks internal names
+// and addresses to the provider.
+//
+// Names that must never be resolved by DNS are answered locally:
+// "localhost" and its subdomains resolve to loopback addresses, as does
+// reverse lookup of loopback addresses (RFC 6761, section 6.3); "invalid",
+// "test" (RFC 6761), "onion" (RFC 7686) and "alt" (RFC 9476) domains, and
+// reverse zones of loopback and unspecified addresses get NXDOMAIN.
+//
+// Queries for private-use names are passed to local, typically a resolver
+// of the local network, or answered with NXDOMAIN if local is nil: "local"
+// domain (RFC 6762), "home.arpa" (RFC 8375), "internal", and reverse zones
+// of private, shared (RFC 6598), link-local and unique local addresses (RFC
+// 6303). Combine it with MDNS to resolve "local" names with multicast DNS:
+//
+//	h := dot.SpecialUse(dot.NewClient("dns.quad9.net", []string{"9.9.9.9:853"}), nil)
+//	r := dot.NewResolver(dot.MDNS(h))
+func SpecialUse(next, local Handler) Handler {
+	if next == nil {
+		panic("dot: nil Handler")
+	}
+	return HandlerFunc(func(ctx context.Context, query []byte) ([]byte, error) {
+		var p dnsmessage.Parser
+		hdr, err := p.Start(query)
+		if err != nil {
+			return next.ServeDNS(ctx, query)
+		}
+		q, err := p.Question()
+		if err != nil {
+			return next.ServeDNS(ctx, query)
+		}
+		name := hostsKey(q.Name.String())
+		switch specialDomain(name) {
+		case specialNone:
+			return next.ServeDNS(ctx, query)
+		case specialPrivate:
+			if local != nil {
+				return local.ServeDNS(ctx, query)
+			}
+		case specialLoopback:
+			return loopbackResponse(hdr, q, name)
+		}
+		return answerResponse(hdr, q, dnsmessage.RCodeNameError, nil)
+	})
+}
+
+type specialKind uint8
+
+const (
+	specialNone     specialKind = iota
+	specialLoopback             // localhost names, answered with loopback addresses
+	specialInvalid              // never resolvable, answered with NXDOMAIN
+	specialPrivate              // only resolvable on local network
+)
+
+// specialDomains maps special-use domains, lowercased and without trailing
+// dot, to their kinds. Names match their subdomains too.
+var specialDomains = func() map[string]specialKind {
+	m := map[string]specialKind{
+		"localhost": specialLoopback,
+		"invalid":   specialInvalid,
+		"test":      specialInvalid,
+		"onion":     specialInvalid,
+		"alt":       specialInvalid,
+
+		"127.in-addr.arpa": specialLoopback,
+		"0.in-addr.arpa":   specialInvalid,
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa": specialLoopback,
+		"0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa": specialInvalid,
+
+		"local":                specialPrivate,
+		"home.arpa":            specialPrivate,
+		"internal":             specialPrivate,
+		"10.in-addr.arpa":      specialPrivate,
+		"168.192.in-addr.arpa": specialPrivate,
+		"254.169.in-addr.arpa": specialPrivate,
+		"d.f.ip6.arpa":         specialPrivate,
+		"8.e.f.ip6.arpa":       specialPrivate,
+		"9.e.f.ip6.arpa":       specialPrivate,
+		"a.e.f.ip6.arpa":       specialPrivate,
+		"b.e.f.ip6.arpa":       specialPrivate,
+	}
+	for i := 16; i < 32; i++ {
+		m[strconv.Itoa(i)+".172.in-addr.arpa"] = specialPrivate
+	}
+	for i := 64; i < 128; i++ {
+		m[strconv.Itoa(i)+".100.in-addr.arpa"] = specialPrivate
+	}
+	return m
+}()
+
+// specialDomain returns kind of special-use domain name belongs to. Name
+// must be lowercased and have no trailing dot.
+func specialDomain(name string) specialKind {
+	for {
+		if kind, ok := specialDomains[name]; ok {
+			return kind
+		}
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			return specialNone
+		}
+		name = name[i+1:]
+	}
+}
+
+// loopbackResponse answers query for localhost name or reverse name of a
+// loopback address.
+func loopbackResponse(hdr dnsmessage.Header, q dnsmessage.Question, name string) ([]byte, error) {
+	rh := dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: q.Class}
+	var answers []dnsmessage.Resource
+	if ip, ok := reverseAddr(name); ok {
+		if !ip.IsLoopback() {
+			return answerResponse(hdr, q, dnsmessage.RCodeNameError, nil)
+		}
+		if q.Type == dnsmessage.TypePTR {
+			answers = append(answers, dnsmessage.Resource{Header: rh, Body: &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName("localhost.")}})
+		}
+		return answerResponse(hdr, q, dnsmessage.RCodeSuccess, answers)
+	}
+	if strings.HasSuffix(name, ".arpa") {
+		// part of reverse zone, not an address
+		return answerResponse(hdr, q, dnsmessage.RCodeSuccess, nil)
+	}
+	switch q.Type {
+	case dnsmessage.TypeA:
+		answers = append(answers, dnsmessage.Resource{Header: rh, Body: &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}}})
+	case dnsmessage.TypeAAAA:
+		answers = append(answers, dnsmessage.Resource{Header: rh, Body: &dnsmessage.AAAAResource{AAAA: netip.IPv6Loopback().As16()}})
+	}
+	return answerResponse(hdr, q, dnsmessage.RCodeSuccess, answers)
+}
@@ -0,0 +1,215 @@
+package dot
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// StampProto is the protocol a DNS stamp describes.
+type StampProto byte
+
+// Protocols of DNS stamps, as listed in the stamps specification at
+// https://dnscrypt.info/stamps-specifications.
+const (
+	StampPlain         StampProto = 0x00
+	StampDNSCrypt      StampProto = 0x01
+	StampDoH           StampProto = 0x02
+	StampDoT           StampProto = 0x03
+	StampDoQ           StampProto = 0x04
+	StampODoHTarget    StampProto = 0x05
+	StampDNSCryptRelay StampProto = 0x81
+	StampODoHRelay     StampProto = 0x85
+)
+
+// stampDefaultPortTLS is the default port of DNSCrypt, DoH and relay stamps.
+const stampDefaultPortTLS = "443"
+
+// Stamp is a parsed DNS stamp, a compact "sdns://" encoding of everything
+// needed to reach an encrypted DNS server or relay. Anonymized DNSCrypt relay
+// and server lists published by the DNSCrypt project use this format.
+//
+// Fields not used by Proto are left empty.
+type Stamp struct {
+	Proto StampProto
+
+	// Properties server claims to have.
+	DNSSEC   bool // server does DNSSEC validation
+	NoLog    bool // server does not log queries
+	NoFilter bool // server does not filter responses
+
+	// Addr is server or relay address in the host:port form, with the
+	// default port of the protocol if stamp has none. It may be empty
+	// for DoH, DoT and DoQ servers, meaning Host must be resolved.
+	Addr string
+
+	// PublicKey is the DNSCrypt provider public key.
+	PublicKey []byte
+
+	// ProviderName is the DNSCrypt provider name.
+	ProviderName string
+
+	// Hashes are SHA256 digests of TBS certificates found in the
+	// server's certificate chain.
+	Hashes [][]byte
+
+	// Host is the server host name, optionally with port.
+	Host string
+
+	// Path is the absolute URI path of DoH and ODoH endpoints.
+	Path string
+
+	// Bootstrap lists addresses of resolvers recommended for resolving
+	// Host.
+	Bootstrap []string
+}
+
+// ParseStamp parses DNS stamp in the "sdns://" form.
+func ParseStamp(s string) (*Stamp, error) {
+	enc, ok := strings.CutPrefix(s, "sdns://")
+	if !ok {
+		return nil, errors.New("dot: stamp must start with sdns://")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(enc, "="))
+	if err != nil {
+		return nil, fmt.Errorf("dot: malformed stamp: %w", err)
+	}
+	if len(b) == 0 {
+		return nil, errors.New("dot: empty stamp")
+	}
+	st := &Stamp{Proto: StampProto(b[0])}
+	p := stampParser{b: b[1:]}
+	if st.Proto != StampDNSCryptRelay {
+		props := p.props()
+		st.DNSSEC = props&1 != 0
+		st.NoLog = props&2 != 0
+		st.NoFilter = props&4 != 0
+	}
+	switch st.Proto {
+	case StampPlain:
+		st.Addr = withDefaultPort(p.string(), "53")
+	case StampDNSCrypt:
+		st.Addr = withDefaultPort(p.string(), stampDefaultPortTLS)
+		st.PublicKey = p.bytes()
+		st.ProviderName = p.string()
+	case StampDoH, StampODoHRelay:
+		st.Addr = withDefaultPort(p.string(), stampDefaultPortTLS)
+		st.Hashes = p.vector()
+		st.Host = p.string()
+		st.Path = p.string()
+		st.Bootstrap = p.optionalStrings()
+	case StampDoT, StampDoQ:
+		st.Addr = withDefaultPort(p.string(), "853")
+		st.Hashes = p.vector()
+		st.Host = p.string()
+		st.Bootstrap = p.optionalStrings()
+	case StampODoHTarget:
+		st.Host = p.string()
+		st.Path = p.string()
+	case StampDNSCryptRelay:
+		st.Addr = withDefaultPort(p.string(), stampDefaultPortTLS)
+	default:
+		return nil, fmt.Errorf("dot: unsupported stamp protocol %#x", byte(st.Proto))
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	if len(p.b) != 0 {
+		return nil, errors.New("dot: malformed stamp: trailing data")
+	}
+	if st.Proto == StampDNSCrypt && (len(st.PublicKey) != 32 || st.ProviderName == "") {
+		return nil, errors.New("dot: malformed DNSCrypt stamp")
+	}
+	return st, nil
+}
+
+// withDefaultPort returns addr with port appended if it has none. An empty
+// addr is returned as is.
+func withDefaultPort(addr, port string) string {
+	if addr == "" {
+		return ""
+	}
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(strings.Trim(addr, "[]"), port)
+}
+
+// stampParser reads fields of a decoded stamp, remembering the first error.
+type stampParser struct {
+	b   []byte
+	err error
+}
+
+var errMalformedStamp = errors.New("dot: malformed stamp")
+
+func (p *stampParser) props() uint64 {
+	if p.err != nil {
+		return 0
+	}
+	if len(p.b) < 8 {
+		p.err = errMalformedStamp
+		return 0
+	}
+	v := binary.LittleEndian.Uint64(p.b)
+	p.b = p.b[8:]
+	return v
+}
+
+// bytes reads length-prefixed field.
+func (p *stampParser) bytes() []byte {
+	if p.err != nil {
+		return nil
+	}
+	if len(p.b) < 1 || len(p.b) < 1+int(p.b[0]) {
+		p.err = errMalformedStamp
+		return nil
+	}
+	n := int(p.b[0])
+	v := append([]byte(nil), p.b[1:1+n]...)
+	p.b = p.b[1+n:]
+	return v
+}
+
+func (p *stampParser) string() string { return string(p.bytes()) }
+
+// vector reads a set of length-prefixed fields, where the high bit of every
+// length but the last one is set.
+func (p *stampParser) vector() [][]byte {
+	var out [][]byte
+	for p.err == nil {
+		if len(p.b) < 1 {
+			p.err = errMalformedStamp
+			return nil
+		}
+		more := p.b[0]&0x80 != 0
+		n := int(p.b[0] &^ 0x80)
+		if len(p.b) < 1+n {
+			p.err = errMalformedStamp
+			return nil
+		}
+		if n != 0 {
+			out = append(out, append([]byte(nil), p.b[1:1+n]...))
+		}
+		p.b = p.b[1+n:]
+		if !more {
+			break
+		}
+	}
+	return out
+}
+
+// optionalStrings reads a vector of strings, if there is any data left.
+func (p *stampParser) optionalStrings() []string {
+	if p.err != nil || len(p.b) == 0 {
+		return nil
+	}
+	var out []string
+	for _, v := range p.vector() {
+		out = append(out, string(v))
+	}
+	return out
+}
@@ -0,0 +1,122 @@
+package dot_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"slices"
+	"testing"
+
+	"github.com/artyom/dot"
+)
+
+// stampField returns s prefixed with its length, as stamps encode strings.
+func stampField(s string) []byte { return append([]byte{byte(len(s))}, s...) }
+
+func encodeStamp(parts ...[]byte) string {
+	return "sdns://" + base64.RawURLEncoding.EncodeToString(bytes.Join(parts, nil))
+}
+
+func TestParseStamp(t *testing.T) {
+	key := string(bytes.Repeat([]byte{0xab}, 32))
+	hash1, hash2 := string(bytes.Repeat([]byte{1}, 32)), string(bytes.Repeat([]byte{2}, 32))
+	noProps := make([]byte, 8)
+	for _, tc := range []struct {
+		name  string
+		stamp string
+		want  dot.Stamp
+	}{
+		{
+			name:  "doh",
+			stamp: "sdns://AgcAAAAAAAAABzEuMC4wLjEAEmRucy5jbG91ZGZsYXJlLmNvbQovZG5zLXF1ZXJ5",
+			want: dot.Stamp{Proto: dot.StampDoH, DNSSEC: true, NoLog: true, NoFilter: true,
+				Addr: "1.0.0.1:443", Host: "dns.cloudflare.com", Path: "/dns-query"},
+		},
+		{
+			name:  "doh padded",
+			stamp: "sdns://AgcAAAAAAAAABzEuMC4wLjEAEmRucy5jbG91ZGZsYXJlLmNvbQovZG5zLXF1ZXJ5==",
+			want: dot.Stamp{Proto: dot.StampDoH, DNSSEC: true, NoLog: true, NoFilter: true,
+				Addr: "1.0.0.1:443", Host: "dns.cloudflare.com", Path: "/dns-query"},
+		},
+		{
+			name:  "dnscrypt",
+			stamp: encodeStamp([]byte{0x01}, []byte{1, 0, 0, 0, 0, 0, 0, 0}, stampField("192.0.2.1:8443"), stampField(key), stampField("2.dnscrypt-cert.example")),
+			want: dot.Stamp{Proto: dot.StampDNSCrypt, DNSSEC: true, Addr: "192.0.2.1:8443",
+				PublicKey: []byte(key), ProviderName: "2.dnscrypt-cert.example"},
+		},
+		{
+			name:  "dnscrypt ipv6 default port",
+			stamp: encodeStamp([]byte{0x01}, noProps, stampField("[2001:db8::1]"), stampField(key), stampField("2.dnscrypt-cert.example")),
+			want: dot.Stamp{Proto: dot.StampDNSCrypt, Addr: "[2001:db8::1]:443",
+				PublicKey: []byte(key), ProviderName: "2.dnscrypt-cert.example"},
+		},
+		{
+			name: "dot",
+			stamp: encodeStamp([]byte{0x03}, []byte{2, 0, 0, 0, 0, 0, 0, 0}, stampField("192.0.2.2"),
+				[]byte{0x80 | 32}, []byte(hash1), stampField(hash2),
+				stampField("dot.example"), stampField("192.0.2.53")),
+			want: dot.Stamp{Proto: dot.StampDoT, NoLog: true, Addr: "192.0.2.2:853",
+				Hashes: [][]byte{[]byte(hash1), []byte(hash2)}, Host: "dot.example", Bootstrap: []string{"192.0.2.53"}},
+		},
+		{
+			name:  "dot without address",
+			stamp: encodeStamp([]byte{0x03}, noProps, stampField(""), stampField(""), stampField("dot.example:8853")),
+			want:  dot.Stamp{Proto: dot.StampDoT, Host: "dot.example:8853"},
+		},
+		{
+			name:  "plain",
+			stamp: encodeStamp([]byte{0x00}, []byte{4, 0, 0, 0, 0, 0, 0, 0}, stampField("192.0.2.4")),
+			want:  dot.Stamp{Proto: dot.StampPlain, NoFilter: true, Addr: "192.0.2.4:53"},
+		},
+		{
+			name:  "odoh target",
+			stamp: encodeStamp([]byte{0x05}, noProps, stampField("odoh.example"), stampField("/dns-query")),
+			want:  dot.Stamp{Proto: dot.StampODoHTarget, Host: "odoh.example", Path: "/dns-query"},
+		},
+		{
+			name:  "dnscrypt relay",
+			stamp: encodeStamp([]byte{0x81}, stampField("192.0.2.3")),
+			want:  dot.Stamp{Proto: dot.StampDNSCryptRelay, Addr: "192.0.2.3:443"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			st, err := dot.ParseStamp(tc.stamp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalStamps(st, &tc.want) {
+				t.Errorf("got %+v\nwant %+v", st, &tc.want)
+			}
+		})
+	}
+}
+
+func equalStamps(a, b *dot.Stamp) bool {
+	return a.Proto == b.Proto && a.DNSSEC == b.DNSSEC && a.NoLog == b.NoLog && a.NoFilter == b.NoFilter &&
+		a.Addr == b.Addr && bytes.Equal(a.PublicKey, b.PublicKey) && a.ProviderName == b.ProviderName &&
+		slices.EqualFunc(a.Hashes, b.Hashes, bytes.Equal) && a.Host == b.Host && a.Path == b.Path &&
+		slices.Equal(a.Bootstrap, b.Bootstrap)
+}
+
+func TestParseStampMalformed(t *testing.T) {
+	noProps := make([]byte, 8)
+	for _, tc := range []struct {
+		name, stamp string
+	}{
+		{"no scheme", "AgcAAAAAAAAABzEuMC4wLjE"},
+		{"bad base64", "sdns://@@@"},
+		{"empty", "sdns://"},
+		{"unknown protocol", encodeStamp([]byte{0x42}, noProps)},
+		{"short props", encodeStamp([]byte{0x00}, []byte{1, 0, 0})},
+		{"truncated field", encodeStamp([]byte{0x00}, noProps, []byte{10}, []byte("192"))},
+		{"trailing data", encodeStamp([]byte{0x81}, stampField("192.0.2.3"), []byte{0})},
+		{"dnscrypt short key", encodeStamp([]byte{0x01}, noProps, stampField("192.0.2.1"), stampField("short"), stampField("2.dnscrypt-cert.example"))},
+		{"dnscrypt no provider", encodeStamp([]byte{0x01}, noProps, stampField("192.0.2.1"), stampField(string(make([]byte, 32))), stampField(""))},
+		{"unterminated hashes", encodeStamp([]byte{0x03}, noProps, stampField("192.0.2.2"), []byte{0x80 | 1, 1})},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if st, err := dot.ParseStamp(tc.stamp); err == nil {
+				t.Errorf("got %+v, want error", st)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package dot
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// ErrNoSystemConfig is returned by FromSystem if operating system has no
+// encrypted DNS configured, or if reading its configuration is not
+// supported.
+var ErrNoSystemConfig = errors.New("dot: no encrypted DNS configured in the system")
+
+// FromSystem returns Resolver using encrypted DNS service the operating system
+// is configured with, so that programs can honor what the user already
+// configured rather than overriding it. Supported are:
+//
+//   - Linux: global DNS-over-TLS servers of systemd-resolved, from
+//     resolved.conf(5) and its drop-ins, with DNSOverTLS set to "yes" or
+//     "opportunistic". Only servers listed with their TLS name, as in
+//     "9.9.9.9#dns.quad9.net", are used, and their certificates are always
+//     verified. Per-link servers set by network managers are ignored.
+//   - Windows: DNS-over-HTTPS servers set for network interfaces, either
+//     with a custom template or a template Windows knows for the server
+//     address.
+//
+// Android Private DNS and macOS encrypted DNS profiles are not readable by
+// programs, FromSystem returns ErrNoSystemConfig there. If system lists
+// several servers, queries go to the first one, falling back to the next
+// ones on failures as with Fallback.
+func FromSystem() (*net.Resolver, error) {
+	return systemResolver()
+}
+
+// chainResolvers returns Resolver using handlers in order with Fallback.
+func chainResolvers(hs []Handler) *net.Resolver {
+	h := hs[len(hs)-1]
+	for i := len(hs) - 2; i >= 0; i-- {
+		h = Fallback(hs[i], h)
+	}
+	return NewResolver(h)
+}
+
+// resolvedConf is the part of systemd-resolved configuration FromSystem
+// needs.
+type resolvedConf struct {
+	dns []string // DNS= entries
+	tls string   // DNSOverTLS= value
+}
+
+// parse reads a single resolved.conf(5) file, applying its settings over
+// the ones already read.
+func (c *resolvedConf) parse(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	var section string
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			section = strings.Trim(line, "[]")
+			continue
+		}
+		key, val, ok := strings.Cut(line, "=")
+		if !ok || section != "Resolve" {
+			continue
+		}
+		switch key, val = strings.TrimSpace(key), strings.TrimSpace(val); key {
+		case "DNS":
+			if val == "" {
+				c.dns = nil // empty assignment resets the list
+				continue
+			}
+			c.dns = append(c.dns, strings.Fields(val)...)
+		case "DNSOverTLS":
+			c.tls = val
+		}
+	}
+	return sc.Err()
+}
+
+// servers returns DNS-over-TLS servers configuration enables, grouped by
+// server name in the order names first appear.
+func (c *resolvedConf) servers() (names []string, addrs map[string][]string) {
+	switch strings.ToLower(c.tls) {
+	case "yes", "true", "on", "1", "opportunistic":
+	default:
+		return nil, nil
+	}
+	addrs = make(map[string][]string)
+	for _, entry := range c.dns {
+		addr, name, ok := parseResolvedDNS(entry)
+		if !ok {
+			continue
+		}
+		if _, seen := addrs[name]; !seen {
+			names = append(names, name)
+		}
+		addrs[name] = append(addrs[name], addr)
+	}
+	return names, addrs
+}
+
+// parseResolvedDNS parses DNS= entry of resolved.conf(5) in the
+// "address[:port][%interface][#name]" form, returning its address in the
+// host:port form, port 853 if entry has none. It returns false if entry is
+// malformed or has no server name.
+func parseResolvedDNS(entry string) (addr, name string, ok bool) {
+	entry, name, ok = strings.Cut(entry, "#")
+	if !ok || name == "" {
+		return "", "", false
+	}
+	entry, _, _ = strings.Cut(entry, "%")
+	host, port := strings.TrimSuffix(strings.TrimPrefix(entry, "["), "]"), "853"
+	if _, err := netip.ParseAddr(host); err != nil {
+		if host, port, err = net.SplitHostPort(entry); err != nil {
+			return "", "", false
+		}
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return "", "", false
+	}
+	return net.JoinHostPort(ip.String(), port), name, true
+}
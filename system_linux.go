@@ -0,0 +1,62 @@
+package dot
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// resolvedDropInDirs are directories systemd-resolved reads configuration
+// drop-ins from, in order of precedence.
+var resolvedDropInDirs = []string{
+	"/etc/systemd/resolved.conf.d",
+	"/run/systemd/resolved.conf.d",
+	"/usr/local/lib/systemd/resolved.conf.d",
+	"/usr/lib/systemd/resolved.conf.d",
+}
+
+func systemResolver() (*net.Resolver, error) {
+	files := []string{"/etc/systemd/resolved.conf"}
+	dropIns := make(map[string]string)
+	for _, dir := range resolvedDropInDirs {
+		matches, _ := filepath.Glob(filepath.Join(dir, "*.conf"))
+		for _, name := range matches {
+			if _, ok := dropIns[filepath.Base(name)]; !ok {
+				dropIns[filepath.Base(name)] = name
+			}
+		}
+	}
+	bases := make([]string, 0, len(dropIns))
+	for base := range dropIns {
+		bases = append(bases, base)
+	}
+	sort.Strings(bases)
+	for _, base := range bases {
+		files = append(files, dropIns[base])
+	}
+	var conf resolvedConf
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			continue
+		}
+		err = conf.parse(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	names, addrs := conf.servers()
+	switch len(names) {
+	case 0:
+		return nil, ErrNoSystemConfig
+	case 1:
+		return New(names[0], addrs[names[0]]), nil
+	}
+	hs := make([]Handler, len(names))
+	for i, name := range names {
+		hs[i] = NewClient(name, addrs[name])
+	}
+	return chainResolvers(hs), nil
+}
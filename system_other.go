@@ -0,0 +1,7 @@
+//go:build !linux && !windows
+
+package dot
+
+import "net"
+
+func systemResolver() (*net.Resolver, error) { return nil, ErrNoSystemConfig }
@@ -0,0 +1,99 @@
+package dot
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"sort"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+const (
+	dnscacheInterfacesKey = `SYSTEM\CurrentControlSet\Services\Dnscache\InterfaceSpecificParameters`
+	dohWellKnownKey       = `SYSTEM\CurrentControlSet\Services\Dnscache\Parameters\DohWellKnownServers`
+)
+
+func systemResolver() (*net.Resolver, error) {
+	ifaces, err := subKeys(registry.LOCAL_MACHINE, dnscacheInterfacesKey)
+	if err != nil {
+		return nil, ErrNoSystemConfig
+	}
+	var hs []Handler
+	seen := make(map[string]bool)
+	for _, iface := range ifaces {
+		for _, family := range []string{"Doh", "Doh6"} {
+			path := dnscacheInterfacesKey + `\` + iface + `\DohInterfaceSettings\` + family
+			servers, err := subKeys(registry.LOCAL_MACHINE, path)
+			if err != nil {
+				continue
+			}
+			for _, server := range servers {
+				ip, err := netip.ParseAddr(server)
+				if err != nil || seen[server] {
+					continue
+				}
+				template := stringValue(registry.LOCAL_MACHINE, path+`\`+server, "DohTemplate")
+				if template == "" {
+					template = stringValue(registry.LOCAL_MACHINE, dohWellKnownKey+`\`+server, "Template")
+				}
+				if template == "" {
+					continue
+				}
+				seen[server] = true
+				hs = append(hs, dohClientTo(template, ip))
+			}
+		}
+	}
+	if len(hs) == 0 {
+		return nil, ErrNoSystemConfig
+	}
+	return chainResolvers(hs), nil
+}
+
+// dohClientTo returns DoHClient using url, connecting to ip instead of
+// resolving url host name.
+func dohClientTo(url string, ip netip.Addr) *DoHClient {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.Proxy = nil
+	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		var d net.Dialer
+		return d.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+	}
+	return &DoHClient{URL: url, HTTPClient: &http.Client{Transport: tr}}
+}
+
+// subKeys returns names of subkeys of key at path, sorted.
+func subKeys(root registry.Key, path string) ([]string, error) {
+	k, err := registry.OpenKey(root, path, registry.ENUMERATE_SUB_KEYS)
+	if err != nil {
+		return nil, err
+	}
+	defer k.Close()
+	names, err := k.ReadSubKeyNames(-1)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// stringValue returns string value name of key at path, or empty string if
+// there is none.
+func stringValue(root registry.Key, path, name string) string {
+	k, err := registry.OpenKey(root, path, registry.QUERY_VALUE)
+	if err != nil {
+		return ""
+	}
+	defer k.Close()
+	v, _, err := k.GetStringValue(name)
+	if err != nil {
+		return ""
+	}
+	return v
+}
@@ -0,0 +1,16 @@
+package dot
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setFastOpen is the net.Dialer Control function enabling TCP Fast Open on
+// outgoing connection. Failure to enable it is not an error, kernels that do
+// not support it just do a regular handshake.
+func setFastOpen(_, _ string, c syscall.RawConn) error {
+	return c.Control(func(fd uintptr) {
+		unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_FASTOPEN_CONNECT, 1)
+	})
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package dot
+
+import "syscall"
+
+// setFastOpen does nothing, TCP Fast Open is only supported on Linux.
+func setFastOpen(_, _ string, _ syscall.RawConn) error { return nil }
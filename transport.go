@@ -0,0 +1,42 @@
+package dot
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// Transport returns a copy of base that resolves host names using r. If base
+// is nil, a copy of http.DefaultTransport is used.
+//
+// Returned transport's DialContext is replaced with the one using r, and its
+// DialTLSContext and DialTLS are cleared, since they would otherwise bypass
+// the resolver. Use it to make HTTP client resolve names over DNS-over-TLS:
+//
+//	client := &http.Client{Transport: dot.Transport(dot.Quad9(), nil)}
+func Transport(r *net.Resolver, base *http.Transport) *http.Transport {
+	var t *http.Transport
+	if base != nil {
+		t = base.Clone()
+	} else {
+		t = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	t.DialContext = Dialer(r).DialContext
+	t.Dial = nil
+	t.DialTLSContext = nil
+	t.DialTLS = nil
+	return t
+}
+
+// Dialer returns net.Dialer that resolves host names using r, with 30 seconds
+// connect timeout and TCP keep-alive period. Use it with any TCP or UDP client
+// library accepting net.Dialer or its DialContext method:
+//
+//	conn, err := dot.Dialer(dot.Cloudflare()).DialContext(ctx, "tcp", "example.com:443")
+func Dialer(r *net.Resolver) *net.Dialer {
+	return &net.Dialer{
+		Resolver:  r,
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+}
@@ -0,0 +1,29 @@
+package dot
+
+import "context"
+
+type upstreamKey struct{}
+
+// WithUpstream returns a copy of ctx that makes lookups done with it connect
+// to upstream at addr, in the host:port form, instead of the one Resolver or
+// Client would otherwise pick. Upstream certificate is still verified against
+// the server name Resolver or Client was created with. Use it to direct a
+// single query to a particular upstream, e.g. when debugging or comparing
+// upstreams:
+//
+//	r := dot.Quad9()
+//	addrs, err := r.LookupHost(dot.WithUpstream(ctx, "149.112.112.112:853"), "example.com")
+//
+// Client sends such queries over a dedicated connection, bypassing its pool.
+// Handlers answering queries themselves, such as Cache, may still answer
+// without reaching upstream. Note that net.Resolver merges concurrent lookups
+// of the same name, so such lookups may share an upstream.
+func WithUpstream(ctx context.Context, addr string) context.Context {
+	return context.WithValue(ctx, upstreamKey{}, addr)
+}
+
+// upstreamFrom returns upstream address set by WithUpstream, if any.
+func upstreamFrom(ctx context.Context) (string, bool) {
+	addr, ok := ctx.Value(upstreamKey{}).(string)
+	return addr, ok && addr != ""
+}
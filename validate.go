@@ -0,0 +1,94 @@
+package dot
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Validate checks arguments of New, DialFunc and NewClient, returning error
+// describing the first problem found: serverName must be a valid host name
+// or IP address, and addrs must be a non-empty list of distinct addresses in
+// the host:port form, where host is either an IP address or a valid host
+// name, and port is a number.
+//
+// Functions taking these arguments panic if they are empty, and report other
+// problems only once they dial upstream. Use Validate, or NewChecked and
+// NewClientChecked, with user-supplied configuration.
+func Validate(serverName string, addrs []string) error {
+	if serverName == "" {
+		return errors.New("dot: server name cannot be empty")
+	}
+	if _, err := netip.ParseAddr(serverName); err != nil && !validHostname(serverName) {
+		return fmt.Errorf("dot: invalid server name %q", serverName)
+	}
+	if len(addrs) == 0 {
+		return errors.New("dot: addrs cannot be empty")
+	}
+	seen := make(map[string]struct{}, len(addrs))
+	for _, addr := range addrs {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("dot: invalid address %q: %w", addr, err)
+		}
+		if ip, err := netip.ParseAddr(host); err == nil {
+			host = ip.Unmap().String()
+		} else if validHostname(host) {
+			host = hostsKey(host)
+		} else {
+			return fmt.Errorf("dot: invalid address %q: invalid host", addr)
+		}
+		n, err := strconv.ParseUint(port, 10, 16)
+		if err != nil || n == 0 {
+			return fmt.Errorf("dot: invalid address %q: invalid port", addr)
+		}
+		key := net.JoinHostPort(host, strconv.FormatUint(n, 10))
+		if _, ok := seen[key]; ok {
+			return fmt.Errorf("dot: duplicate address %q", addr)
+		}
+		seen[key] = struct{}{}
+	}
+	return nil
+}
+
+// NewChecked is like New, but it returns an error instead of panicking if
+// its arguments do not pass Validate.
+func NewChecked(serverName string, addrs []string, opts ...Option) (*net.Resolver, error) {
+	if err := Validate(serverName, addrs); err != nil {
+		return nil, err
+	}
+	return newResolver(serverName, addrs, opts), nil
+}
+
+// NewClientChecked is like NewClient, but it returns an error instead of
+// panicking if its arguments do not pass Validate.
+func NewClientChecked(serverName string, addrs []string, opts ...Option) (*Client, error) {
+	if err := Validate(serverName, addrs); err != nil {
+		return nil, err
+	}
+	return NewClient(serverName, addrs, opts...), nil
+}
+
+// validHostname reports whether name is a syntactically valid host name, with
+// optional trailing dot.
+func validHostname(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+package dot
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Known-answer lookup performed by Verify: one.one.one.one is operated by
+// Cloudflare and is not expected to ever change its address.
+const (
+	verifyName = "one.one.one.one"
+	verifyAddr = "1.1.1.1"
+)
+
+// Report describes outcome of Verify.
+type Report struct {
+	Addr        net.Addr      // remote address of the upstream connection
+	TLSVersion  uint16        // negotiated TLS version, e.g. tls.VersionTLS13
+	CipherSuite uint16        // negotiated cipher suite
+	Handshake   time.Duration // time to establish connection, including TLS handshake
+	Query       time.Duration // time to get the answer over established connection
+
+	// Chain is the verified certificate chain of the upstream, leaf
+	// certificate first.
+	Chain []*x509.Certificate
+
+	// Expires is the earliest expiration time among certificates of Chain.
+	Expires time.Time
+
+	// Answers lists addresses the known-answer lookup returned.
+	Answers []net.IP
+}
+
+// Verify confirms that encrypted DNS actually works with r: it establishes a
+// new TLS connection, checks that it has verified certificate chain that is
+// not expired, and performs a known-answer lookup over it.
+//
+// If connection was established, Verify returns non-nil Report even on
+// error, so that its details can be inspected. r must be the one returned by
+// one of this package's functions.
+func Verify(ctx context.Context, r *net.Resolver) (*Report, error) {
+	query, err := newQuery(verifyName, dnsmessage.TypeA)
+	if err != nil {
+		return nil, err
+	}
+	exact := prepareQuery(ctx, query)
+	res, err := timedExchange(ctx, r, query)
+	if res == nil {
+		return nil, err
+	}
+	rep := &Report{
+		Addr:      res.remote,
+		Handshake: res.handshake,
+		Query:     res.query,
+	}
+	if res.tls != nil {
+		rep.TLSVersion = res.tls.Version
+		rep.CipherSuite = res.tls.CipherSuite
+		if len(res.tls.VerifiedChains) != 0 {
+			rep.Chain = res.tls.VerifiedChains[0]
+		}
+		for _, cert := range rep.Chain {
+			if rep.Expires.IsZero() || cert.NotAfter.Before(rep.Expires) {
+				rep.Expires = cert.NotAfter
+			}
+		}
+	}
+	if err != nil {
+		return rep, err
+	}
+	if res.tls == nil {
+		return rep, errors.New("dot: resolver connection is not a TLS one")
+	}
+	if len(rep.Chain) == 0 {
+		return rep, errors.New("dot: upstream certificate chain was not verified")
+	}
+	if time.Now().After(rep.Expires) {
+		return rep, fmt.Errorf("dot: upstream certificate chain expired at %v", rep.Expires)
+	}
+
+	if err := checkResponse(query, res.resp, exact); err != nil {
+		return rep, err
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(res.resp); err != nil {
+		return rep, fmt.Errorf("dot: parsing response: %w", err)
+	}
+	if msg.Header.RCode != dnsmessage.RCodeSuccess {
+		return rep, fmt.Errorf("dot: %s: response code %v", verifyName, msg.Header.RCode)
+	}
+	var found bool
+	want := net.ParseIP(verifyAddr)
+	for _, rr := range msg.Answers {
+		if a, ok := rr.Body.(*dnsmessage.AResource); ok {
+			ip := net.IP(a.A[:])
+			rep.Answers = append(rep.Answers, ip)
+			found = found || ip.Equal(want)
+		}
+	}
+	if !found {
+		return rep, fmt.Errorf("dot: %s did not resolve to expected %s, got %v", verifyName, verifyAddr, rep.Answers)
+	}
+	return rep, nil
+}
@@ -0,0 +1,68 @@
+package dot_test
+
+import (
+	"context"
+	"crypto/tls"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/artyom/dot"
+	"github.com/artyom/dot/dottest"
+)
+
+func TestVerify(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		zone      dottest.Zone
+		fault     dottest.Fault
+		cert      dottest.Cert
+		wantErr   bool
+		handshake bool // report must describe completed handshake
+	}{
+		{name: "ok", zone: dottest.Zone{"one.one.one.one": {"1.1.1.1"}}, handshake: true},
+		{name: "wrong answer", zone: dottest.Zone{"one.one.one.one": {"192.0.2.1"}}, wantErr: true, handshake: true},
+		{name: "nxdomain", zone: dottest.Zone{}, wantErr: true, handshake: true},
+		{name: "no response", zone: dottest.Zone{}, fault: dottest.Drop, wantErr: true, handshake: true},
+		{name: "reset", zone: dottest.Zone{}, fault: dottest.Reset, wantErr: true, handshake: true},
+		{name: "expired cert", zone: dottest.Zone{}, cert: dottest.ExpiredCert, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := dottest.NewUnstartedServer(tc.zone)
+			srv.Cert = tc.cert
+			if tc.fault != dottest.NoFault {
+				srv.Fault = func([]byte) dottest.Fault { return tc.fault }
+			}
+			srv.Start()
+			defer srv.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			rep, err := dot.Verify(ctx, srv.Resolver())
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, want error: %t", err, tc.wantErr)
+			}
+			if rep == nil {
+				t.Fatal("got nil report")
+			}
+			if rep.Addr == nil || rep.Addr.String() != srv.Addr {
+				t.Errorf("report address %v, want %s", rep.Addr, srv.Addr)
+			}
+			if !tc.handshake {
+				return
+			}
+			if rep.Handshake <= 0 {
+				t.Errorf("handshake duration %v, want positive", rep.Handshake)
+			}
+			if rep.TLSVersion != tls.VersionTLS13 {
+				t.Errorf("TLS version %s, want TLS 1.3", tls.VersionName(rep.TLSVersion))
+			}
+			if len(rep.Chain) == 0 || !slices.Contains(rep.Chain[0].DNSNames, dottest.ServerName) {
+				t.Errorf("report chain does not start with a certificate for %s", dottest.ServerName)
+			}
+			if rep.Expires.IsZero() {
+				t.Error("report has no chain expiry time")
+			}
+		})
+	}
+}
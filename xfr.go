@@ -0,0 +1,143 @@
+package dot
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// AXFR requests full transfer of zone over a new connection obtained from
+// r.Dial, as described in RFC 9103, and calls fn with every record received,
+// in order, including the SOA records opening and closing the transfer. If fn
+// returns an error, transfer is aborted and the error is returned. r should
+// be the one returned by New, with upstream permitting transfers of zone to
+// this client.
+func AXFR(ctx context.Context, r *net.Resolver, zone string, fn func(dnsmessage.Resource) error) error {
+	return transfer(ctx, r, zone, dnsmessage.TypeAXFR, 0, fn)
+}
+
+// IXFR is like AXFR, but it requests incremental transfer of changes made to
+// zone since the version with given SOA serial, as described in RFC 1995.
+// Records are passed to fn as upstream sends them: the new SOA, then, for
+// every change, the old SOA followed by deleted records and the new SOA
+// followed by added ones, and the new SOA again. If zone has not changed, fn
+// is called once with the current SOA. Upstream may reply with a full
+// transfer instead, as AXFR does.
+func IXFR(ctx context.Context, r *net.Resolver, zone string, serial uint32, fn func(dnsmessage.Resource) error) error {
+	return transfer(ctx, r, zone, typeIXFR, serial, fn)
+}
+
+// typeIXFR is the IXFR query type, not defined by dnsmessage.
+const typeIXFR dnsmessage.Type = 251
+
+func transfer(ctx context.Context, r *net.Resolver, zone string, qtype dnsmessage.Type, serial uint32, fn func(dnsmessage.Resource) error) error {
+	if r == nil || r.Dial == nil {
+		return errors.New("dot: resolver has no Dial function")
+	}
+	if !strings.HasSuffix(zone, ".") {
+		zone += "."
+	}
+	name, err := dnsmessage.NewName(zone)
+	if err != nil {
+		return err
+	}
+	var id [2]byte
+	if _, err := crand.Read(id[:]); err != nil {
+		return err
+	}
+	q := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: binary.BigEndian.Uint16(id[:])},
+		Questions: []dnsmessage.Question{{Name: name, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	if qtype == typeIXFR {
+		q.Authorities = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET},
+			Body:   &dnsmessage.SOAResource{NS: name, MBox: name, Serial: serial},
+		}}
+	}
+	query, err := q.Pack()
+	if err != nil {
+		return err
+	}
+
+	conn, err := r.Dial(ctx, "tcp", "")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if d, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(d)
+	}
+	stop := closeOnDone(ctx, conn)
+	defer stop()
+	if err := writeMsg(conn, query); err != nil {
+		return err
+	}
+
+	var (
+		first   uint32 // serial of the first SOA
+		soas    int    // number of SOA records seen
+		records int
+		full    bool // response turned out to be a full transfer
+	)
+	for {
+		b, err := readMsg(conn)
+		if err != nil {
+			return err
+		}
+		if records == 0 {
+			// only the first message must repeat the question, RFC 5936,
+			// section 2.2.1
+			if err := checkResponse(query, b, false); err != nil {
+				return err
+			}
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(b); err != nil {
+			return fmt.Errorf("dot: parsing response: %w", err)
+		}
+		if msg.Header.ID != q.Header.ID {
+			return errors.New("dot: response id does not match query id")
+		}
+		if msg.Header.RCode != dnsmessage.RCodeSuccess {
+			return fmt.Errorf("dot: zone transfer failed: response code %v", msg.Header.RCode)
+		}
+		for _, rr := range msg.Answers {
+			records++
+			soa, isSOA := rr.Body.(*dnsmessage.SOAResource)
+			if records == 1 && !isSOA {
+				return errors.New("dot: zone transfer does not start with SOA record")
+			}
+			if records == 2 && !isSOA {
+				full = true
+			}
+			if err := fn(rr); err != nil {
+				return err
+			}
+			if !isSOA {
+				continue
+			}
+			soas++
+			switch {
+			case soas == 1:
+				first = soa.Serial
+				// compare serials as described in RFC 1982
+				if qtype == typeIXFR && int32(first-serial) <= 0 {
+					// zone is up to date
+					return nil
+				}
+			case qtype == dnsmessage.TypeAXFR || full:
+				return nil
+			case soas%2 == 0 && soa.Serial == first:
+				// new SOA where the next change would start
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package dot_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/artyom/dot"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var xfrZone = dnsmessage.MustNewName("example.com.")
+
+func soaRR(serial uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: xfrZone, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET, TTL: 3600},
+		Body: &dnsmessage.SOAResource{
+			NS:     dnsmessage.MustNewName("ns.example.com."),
+			MBox:   dnsmessage.MustNewName("hostmaster.example.com."),
+			Serial: serial,
+		},
+	}
+}
+
+func aRR(name string, last byte) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 3600},
+		Body:   &dnsmessage.AResource{A: [4]byte{192, 0, 2, last}},
+	}
+}
+
+// xfrResolver returns Resolver whose connections go to a server answering
+// transfer query with messages carrying given answers, and rcode. Serial
+// of the SOA record in IXFR query is sent to serials.
+func xfrResolver(rcode dnsmessage.RCode, serials chan<- uint32, messages ...[]dnsmessage.Resource) *net.Resolver {
+	return &net.Resolver{
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			client, server := net.Pipe()
+			go func() {
+				defer server.Close()
+				var n uint16
+				if err := binary.Read(server, binary.BigEndian, &n); err != nil {
+					return
+				}
+				query := make([]byte, n)
+				if _, err := io.ReadFull(server, query); err != nil {
+					return
+				}
+				var q dnsmessage.Message
+				if err := q.Unpack(query); err != nil {
+					return
+				}
+				if len(q.Authorities) == 1 {
+					if soa, ok := q.Authorities[0].Body.(*dnsmessage.SOAResource); ok {
+						serials <- soa.Serial
+					}
+				}
+				for i, answers := range messages {
+					msg := dnsmessage.Message{
+						Header:  dnsmessage.Header{ID: q.Header.ID, Response: true, Authoritative: true, RCode: rcode},
+						Answers: answers,
+					}
+					if i == 0 {
+						msg.Questions = q.Questions
+					}
+					b, err := msg.Pack()
+					if err != nil {
+						return
+					}
+					if _, err := server.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(b))), b...)); err != nil {
+						return
+					}
+				}
+			}()
+			return client, nil
+		},
+	}
+}
+
+func TestZoneTransfer(t *testing.T) {
+	errStop := errors.New("stop")
+	for _, tc := range []struct {
+		name     string
+		ixfr     bool
+		serial   uint32 // for IXFR
+		rcode    dnsmessage.RCode
+		messages [][]dnsmessage.Resource
+		stop     int // fn fails on this record, if positive
+		want     int // number of records passed to fn
+		wantErr  bool
+	}{
+		{
+			name: "axfr",
+			messages: [][]dnsmessage.Resource{
+				{soaRR(5), aRR("a.example.com.", 1), aRR("b.example.com.", 2)},
+				{aRR("c.example.com.", 3), soaRR(5)},
+				{aRR("after.example.com.", 9)}, // must not be read
+			},
+			want: 5,
+		},
+		{
+			name:     "axfr of empty zone",
+			messages: [][]dnsmessage.Resource{{soaRR(5), soaRR(5)}},
+			want:     2,
+		},
+		{
+			name:     "ixfr up to date",
+			ixfr:     true,
+			serial:   5,
+			messages: [][]dnsmessage.Resource{{soaRR(5)}},
+			want:     1,
+		},
+		{
+			name:     "ixfr newer than upstream",
+			ixfr:     true,
+			serial:   10,
+			messages: [][]dnsmessage.Resource{{soaRR(5)}},
+			want:     1,
+		},
+		{
+			name:   "ixfr incremental",
+			ixfr:   true,
+			serial: 5,
+			messages: [][]dnsmessage.Resource{
+				{soaRR(7), soaRR(5), aRR("old.example.com.", 1), soaRR(6), aRR("new.example.com.", 2)},
+				{soaRR(6), soaRR(7), aRR("newer.example.com.", 3), soaRR(7)},
+			},
+			want: 9,
+		},
+		{
+			name:   "ixfr serial wrapped",
+			ixfr:   true,
+			serial: 0xfffffffe,
+			messages: [][]dnsmessage.Resource{
+				{soaRR(1), soaRR(0xfffffffe), soaRR(1), aRR("new.example.com.", 2), soaRR(1)},
+			},
+			want: 5,
+		},
+		{
+			name:   "ixfr answered with full transfer",
+			ixfr:   true,
+			serial: 5,
+			messages: [][]dnsmessage.Resource{
+				{soaRR(7), aRR("a.example.com.", 1), aRR("b.example.com.", 2), soaRR(7)},
+			},
+			want: 4,
+		},
+		{
+			name:     "no leading soa",
+			messages: [][]dnsmessage.Resource{{aRR("a.example.com.", 1), soaRR(5)}},
+			want:     0,
+			wantErr:  true,
+		},
+		{
+			name:     "refused",
+			rcode:    dnsmessage.RCodeRefused,
+			messages: [][]dnsmessage.Resource{nil},
+			wantErr:  true,
+		},
+		{
+			name:     "aborted by fn",
+			messages: [][]dnsmessage.Resource{{soaRR(5), aRR("a.example.com.", 1), aRR("b.example.com.", 2), soaRR(5)}},
+			stop:     2,
+			want:     2,
+			wantErr:  true,
+		},
+		{
+			name:     "connection closed early",
+			messages: [][]dnsmessage.Resource{{soaRR(5), aRR("a.example.com.", 1)}},
+			want:     2,
+			wantErr:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			serials := make(chan uint32, 1)
+			r := xfrResolver(tc.rcode, serials, tc.messages...)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			var got int
+			fn := func(dnsmessage.Resource) error {
+				got++
+				if got == tc.stop {
+					return errStop
+				}
+				return nil
+			}
+			var err error
+			if tc.ixfr {
+				err = dot.IXFR(ctx, r, "example.com", tc.serial, fn)
+			} else {
+				err = dot.AXFR(ctx, r, "example.com", fn)
+			}
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, want error: %t", err, tc.wantErr)
+			}
+			if tc.stop != 0 && !errors.Is(err, errStop) {
+				t.Errorf("got error %v, want one returned by fn", err)
+			}
+			if got != tc.want {
+				t.Errorf("fn called with %d records, want %d", got, tc.want)
+			}
+			if tc.ixfr {
+				select {
+				case s := <-serials:
+					if s != tc.serial {
+						t.Errorf("IXFR query has serial %d, want %d", s, tc.serial)
+					}
+				default:
+					t.Error("IXFR query has no SOA record")
+				}
+			}
+		})
+	}
+}